@@ -0,0 +1,192 @@
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/crypto"
+	"go.alargerobot.dev/notebook/data"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	minPasswordLength = 8
+	//maxPasswordLength bcrypt ignores everything past 72 bytes.
+	maxPasswordLength   = 72
+	defaultSessionHours = 24
+	sessionIssuer       = "notebook"
+)
+
+//errBadCredentials Deliberately the same whether the user doesn't exist, is disabled or got their password wrong.
+var errBadCredentials = errors.New("invalid username or password")
+
+//ServiceAPI Local accounts, for installs that don't sign in through Trinity or an OpenID Connect provider. Signing in
+//hands back a session token (an HS256 JWT) that's sent as the bearer token from then on.
+type ServiceAPI struct {
+	data       data.DataStore
+	kms        *crypto.VaultKMS
+	key        []byte
+	signer     jose.Signer
+	sessionTTL time.Duration
+	dummyHash  []byte
+}
+
+//Session ...
+type Session struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+//NewAccountService Sessions are signed with the "sessionKey" config option and last "sessionHours". Without a
+//sessionKey a random one is used, so sessions don't survive a restart and only work on the instance that issued them.
+//Two-factor secrets are sealed with the KMS.
+func NewAccountService(db data.DataStore, kms *crypto.VaultKMS) *ServiceAPI {
+	svc := &ServiceAPI{
+		data:       db,
+		kms:        kms,
+		key:        []byte(common.CurrentConfig.SessionKey),
+		sessionTTL: time.Duration(defaultSessionHours) * time.Hour,
+	}
+	if common.CurrentConfig.SessionHours > 0 {
+		svc.sessionTTL = time.Duration(common.CurrentConfig.SessionHours) * time.Hour
+	}
+	if len(svc.key) == 0 {
+		common.LogWarn("accounts", "sessionKey", "not set, sessions will only be valid until the service restarts")
+		svc.key = make([]byte, 32)
+		if _, err := rand.Read(svc.key); err != nil {
+			panic(err)
+		}
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: svc.key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		panic(err)
+	}
+	svc.signer = signer
+	//Compared against when the user doesn't exist, so a login for an unknown user takes as long as one for a real user.
+	svc.dummyHash, _ = bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+	return svc
+}
+
+//Register Creates an account. Only admins get to do this.
+func (svc *ServiceAPI) Register(ctx context.Context, request data.NewUserRequest) error {
+	if request.Username == "" || strings.ContainsAny(request.Username, " \t\r\n:") {
+		return errors.New("usernames can't be empty or contain spaces or colons")
+	}
+	hash, err := hashPassword(request.Password)
+	if err != nil {
+		return err
+	}
+	return svc.data.NewUser(ctx, data.User{
+		Id:        uuid.New().String(),
+		Username:  request.Username,
+		PassHash:  hash,
+		Group:     request.Group,
+		CreatedAt: common.UnixTimestampInMS(),
+	})
+}
+
+//Login Checks the password (and the second factor, if the user has turned it on) and starts a new session.
+func (svc *ServiceAPI) Login(ctx context.Context, username, password, code string) (Session, error) {
+	user, err := svc.data.GetUser(ctx, username)
+	if err != nil {
+		if errors.Is(err, common.ErrTimeout) || errors.Is(err, common.ErrUnavailable) {
+			return Session{}, err
+		}
+		bcrypt.CompareHashAndPassword(svc.dummyHash, []byte(password))
+		return Session{}, errBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(password)) != nil || user.Disabled {
+		return Session{}, errBadCredentials
+	}
+	if err := svc.CheckSecondFactor(ctx, username, code); err != nil {
+		return Session{}, err
+	}
+	return svc.newSession(username)
+}
+
+//ChangePassword Replaces the user's password if oldPassword is their current one. Every existing session is ended,
+//a new one is returned in their place.
+func (svc *ServiceAPI) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (Session, error) {
+	user, err := svc.data.GetUser(ctx, username)
+	if err != nil {
+		return Session{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(oldPassword)) != nil {
+		return Session{}, errors.New("current password is wrong")
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return Session{}, err
+	}
+	//Sessions are stamped to the second, so the change is recorded as happening at the start of this one to let the
+	//new session through.
+	changedAt := time.Now().Truncate(time.Second).UnixNano() / int64(time.Millisecond)
+	if err := svc.data.SetUserPassword(ctx, username, hash, changedAt); err != nil {
+		return Session{}, err
+	}
+	return svc.newSession(username)
+}
+
+//SetDisabled Disables (or re-enables) an account. A disabled account can't sign in, and its sessions stop working.
+func (svc *ServiceAPI) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	return svc.data.SetUserDisabled(ctx, username, disabled)
+}
+
+//VerifySession Checks the session token and returns the account it belongs to and when the session expires.
+func (svc *ServiceAPI) VerifySession(ctx context.Context, token string) (data.User, time.Time, error) {
+	var claims jwt.Claims
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return data.User{}, time.Time{}, err
+	}
+	if err := parsed.Claims(svc.key, &claims); err != nil {
+		return data.User{}, time.Time{}, errors.New("invalid session")
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: sessionIssuer, Time: time.Now()}, 0); err != nil || claims.IssuedAt == nil || claims.Expiry == nil {
+		return data.User{}, time.Time{}, errors.New("session has expired")
+	}
+
+	user, err := svc.data.GetUser(ctx, claims.Subject)
+	if err != nil {
+		return data.User{}, time.Time{}, err
+	}
+	if user.Disabled {
+		return data.User{}, time.Time{}, errors.New("account is disabled")
+	}
+	if claims.IssuedAt.Time().UnixNano()/int64(time.Millisecond) < user.PasswordChangedAt {
+		return data.User{}, time.Time{}, errors.New("session has expired")
+	}
+	return user, claims.Expiry.Time(), nil
+}
+
+func (svc *ServiceAPI) newSession(username string) (Session, error) {
+	now := time.Now()
+	expiresAt := now.Add(svc.sessionTTL)
+	token, err := jwt.Signed(svc.signer).Claims(jwt.Claims{
+		ID:       uuid.New().String(),
+		Issuer:   sessionIssuer,
+		Subject:  username,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(expiresAt),
+	}).CompactSerialize()
+	if err != nil {
+		return Session{}, common.LogError("", err)
+	}
+	return Session{Token: token, ExpiresAt: expiresAt.UnixNano() / int64(time.Millisecond)}, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", errors.New("passwords need to be between 8 and 72 characters long")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
@@ -0,0 +1,182 @@
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const (
+	recoveryCodeCount = 10
+	//recoveryCodeSize Random bytes per code, 10 bytes is 16 base32 characters.
+	recoveryCodeSize = 10
+)
+
+var (
+	//ErrSecondFactorRequired Returned when the user has two-factor authentication turned on and didn't give a code.
+	ErrSecondFactorRequired = errors.New("a second factor code is required")
+	errBadSecondFactor      = errors.New("invalid second factor code")
+)
+
+//TwoFactorEnrollment What the user needs to set up their authenticator app. The secret is only ever shown here.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+//TwoFactorStatus ...
+type TwoFactorStatus struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recoveryCodes"`
+}
+
+//EnrollTwoFactor Starts (or restarts) enrollment with a new secret. Two-factor authentication isn't turned on until
+//ConfirmTwoFactor is called with a code from it.
+func (svc *ServiceAPI) EnrollTwoFactor(ctx context.Context, username string) (TwoFactorEnrollment, error) {
+	if current, found, err := svc.data.GetTwoFactor(ctx, username); err != nil {
+		return TwoFactorEnrollment{}, err
+	} else if found && current.Enabled {
+		return TwoFactorEnrollment{}, errors.New("two-factor authentication is already on, turn it off first")
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return TwoFactorEnrollment{}, common.LogError("", err)
+	}
+	encoded := totpEncoding.EncodeToString(secret)
+	sealed, err := svc.kms.Encrypt(ctx, encoded)
+	if err != nil {
+		return TwoFactorEnrollment{}, common.LogError("", err)
+	}
+	err = svc.data.SaveTwoFactor(ctx, data.TwoFactor{
+		Username:     username,
+		SealedSecret: sealed,
+		CreatedAt:    common.UnixTimestampInMS(),
+	})
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	return TwoFactorEnrollment{Secret: encoded, URL: totpURL(username, secret)}, nil
+}
+
+//ConfirmTwoFactor Turns two-factor authentication on once the user has shown their authenticator works, and returns
+//their recovery codes. They aren't stored in a form that can be shown again.
+func (svc *ServiceAPI) ConfirmTwoFactor(ctx context.Context, username, code string) ([]string, error) {
+	twoFactor, found, err := svc.data.GetTwoFactor(ctx, username)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, errors.New("two-factor enrollment hasn't been started")
+	} else if twoFactor.Enabled {
+		return nil, errors.New("two-factor authentication is already on")
+	}
+	secret, err := svc.unsealSecret(ctx, twoFactor)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, errBadSecondFactor
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	twoFactor.RecoveryCodes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, common.LogError("", err)
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = encoded[:8] + "-" + encoded[8:]
+		twoFactor.RecoveryCodes[i] = hashRecoveryCode(codes[i])
+	}
+	twoFactor.Enabled, twoFactor.LastStep = true, step
+	if err := svc.data.SaveTwoFactor(ctx, twoFactor); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//DisableTwoFactor Turns two-factor authentication off, which takes a valid code (or recovery code) so a stolen
+//session can't do it.
+func (svc *ServiceAPI) DisableTwoFactor(ctx context.Context, username, code string) error {
+	if _, found, err := svc.data.GetTwoFactor(ctx, username); err != nil {
+		return err
+	} else if !found {
+		return nil
+	}
+	if err := svc.CheckSecondFactor(ctx, username, code); err != nil {
+		return err
+	}
+	return svc.data.DeleteTwoFactor(ctx, username)
+}
+
+//TwoFactorStatus ...
+func (svc *ServiceAPI) TwoFactorStatus(ctx context.Context, username string) (TwoFactorStatus, error) {
+	twoFactor, _, err := svc.data.GetTwoFactor(ctx, username)
+	return TwoFactorStatus{Enabled: twoFactor.Enabled, RecoveryCodes: len(twoFactor.RecoveryCodes)}, err
+}
+
+//CheckSecondFactor Passes if the user hasn't turned two-factor authentication on. Otherwise code has to be a current
+//TOTP code that hasn't been used yet, or one of their recovery codes (which is then used up). Returns
+//ErrSecondFactorRequired if code is empty.
+func (svc *ServiceAPI) CheckSecondFactor(ctx context.Context, username, code string) error {
+	twoFactor, found, err := svc.data.GetTwoFactor(ctx, username)
+	if err != nil {
+		return err
+	} else if !found || !twoFactor.Enabled {
+		return nil
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrSecondFactorRequired
+	}
+
+	if len(code) != totpDigits {
+		if used, err := svc.data.UseRecoveryCode(ctx, username, hashRecoveryCode(code)); err != nil {
+			return err
+		} else if !used {
+			return errBadSecondFactor
+		}
+		common.LogInfo("accounts", username, "signed in with a recovery code")
+		return nil
+	}
+
+	secret, err := svc.unsealSecret(ctx, twoFactor)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return errBadSecondFactor
+	}
+	//Only one use per code, so one seen over someone's shoulder (or in a log) is already spent.
+	if advanced, err := svc.data.AdvanceTwoFactorStep(ctx, username, step); err != nil {
+		return err
+	} else if !advanced {
+		return errBadSecondFactor
+	}
+	return nil
+}
+
+func (svc *ServiceAPI) unsealSecret(ctx context.Context, twoFactor data.TwoFactor) ([]byte, error) {
+	encoded, err := svc.kms.Decrypt(ctx, twoFactor.SealedSecret)
+	if err != nil {
+		return nil, common.LogError("", err)
+	}
+	return totpEncoding.DecodeString(string(encoded))
+}
+
+//hashRecoveryCode Codes are compared case insensitively and without the dash, since they're typed in by hand.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//initAccountRoutes Local accounts only exist when they're what users sign in with.
+func (api *Routes) initAccountRoutes() {
+	if common.CurrentConfig.AuthProvider != ProviderLocal {
+		return
+	}
+	api.handle(Route{Name: "login", Method: "POST", Path: "/api/ash/accounts/login", Access: AccessPublic,
+		Summary: "Signs in to a local account", Request: data.LoginRequest{}, Response: accounts.Session{}}, api.audited("account.login", api.login))
+	api.handle(Route{Name: "newaccount", Method: "POST", Path: "/api/ash/accounts/new", Access: AccessUser, Scope: "admin",
+		Summary: "Creates a local account", Request: data.NewUserRequest{}}, api.audited("account.create", api.newaccount))
+	api.handle(Route{Name: "changepassword", Method: "POST", Path: "/api/ash/accounts/password", Access: AccessUser,
+		Summary: "Changes the user's password, ending every other session", Request: data.ChangePasswordRequest{}, Response: accounts.Session{}}, api.audited("account.password", api.changepassword))
+	api.handle(Route{Name: "disableaccount", Method: "POST", Path: "/api/ash/accounts/disable", Access: AccessUser, Scope: "admin",
+		Summary: "Disables (or re-enables) a local account", Request: data.DisableUserRequest{}}, api.audited("account.disable", api.disableaccount))
+}
+
+func (api *Routes) login(resp http.ResponseWriter, r *http.Request) {
+	var request data.LoginRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "login", 400)
+		return
+	}
+	auditActor(r, request.Username)
+	session, err := api.accounts.Login(r.Context(), request.Username, request.Password, request.Code)
+	common.WriteResponse(resp, 401, session, err)
+}
+func (api *Routes) newaccount(resp http.ResponseWriter, r *http.Request) {
+	var request data.NewUserRequest
+	if api.user.HasPermission(r, "admin") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "newaccount", 401)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "newaccount", 400)
+		return
+	}
+	auditResources(r, request.Username)
+	common.WriteResponse(resp, 400, nil, api.accounts.Register(r.Context(), request))
+}
+func (api *Routes) changepassword(resp http.ResponseWriter, r *http.Request) {
+	var request data.ChangePasswordRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "changepassword", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		session, err := api.accounts.ChangePassword(r.Context(), username, request.OldPassword, request.NewPassword)
+		common.WriteResponse(resp, 400, session, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "changepassword", 401)
+	}
+}
+func (api *Routes) disableaccount(resp http.ResponseWriter, r *http.Request) {
+	var request data.DisableUserRequest
+	if api.user.HasPermission(r, "admin") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "disableaccount", 401)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "disableaccount", 400)
+		return
+	}
+	auditResources(r, request.Username)
+	if username, err := api.user.GetUsernameFromToken(r); err != nil {
+		common.WriteFailureResponse(err, resp, "disableaccount", 401)
+	} else if username == request.Username {
+		common.WriteFailureResponse(errors.New("you can't disable your own account"), resp, "disableaccount", 400)
+	} else {
+		common.WriteResponse(resp, 400, nil, api.accounts.SetDisabled(r.Context(), request.Username, request.Disabled))
+	}
+}
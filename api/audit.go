@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/audit"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const maxAuditLimit = 1000
+
+type auditDetailsKey struct{}
+
+//auditDetails What the handler knows about the action that the route doesn't, like the ID of a page it created.
+type auditDetails struct {
+	actor     string
+	resources []string
+}
+
+//auditStatusWriter Remembers the status the handler responded with, to work out the outcome from.
+type auditStatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditStatusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (api *Routes) initAuditRoutes() {
+	query := []string{"actor", "action", "resource", "outcome", "since", "until", "after", "limit"}
+	api.handle(Route{Name: "auditentries", Method: "GET", Path: "/api/ash/audit", Access: AccessUser, Scope: "admin", Query: query,
+		Summary: "The audit log entries matching the query", Response: []data.AuditEntry{}}, api.audited("audit.read", api.auditentries))
+	api.handle(Route{Name: "exportaudit", Method: "GET", Path: "/api/ash/audit/export", Access: AccessUser, Scope: "admin", Query: query,
+		Summary: "Every audit log entry matching the query, as JSON lines", Response: data.AuditEntry{}, ResponseType: "application/x-ndjson"}, api.audited("audit.export", api.exportaudit))
+	api.handle(Route{Name: "verifyaudit", Method: "GET", Path: "/api/ash/audit/verify", Access: AccessUser, Scope: "admin", Query: []string{"headSeq", "headHash"},
+		Summary: "Checks the audit log's hash chain, and that it still has a head recorded earlier", Response: audit.Verification{}}, api.audited("audit.verify", api.verifyaudit))
+}
+
+//audited Records the action in the audit log once the handler's done. Resources are the route params named in params,
+//plus anything the handler adds with auditResources. The outcome comes from the status the handler responded with.
+func (api *Routes) audited(action string, handler func(http.ResponseWriter, *http.Request), params ...string) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, r *http.Request) {
+		details := &auditDetails{}
+		r = r.WithContext(context.WithValue(r.Context(), auditDetailsKey{}, details))
+		writer := &auditStatusWriter{ResponseWriter: resp, status: http.StatusOK}
+		handler(writer, r)
+
+		var resources []string
+		for _, param := range params {
+			if value := vestigo.Param(r, param); value != "" {
+				resources = append(resources, value)
+			}
+		}
+		resources = append(resources, details.resources...)
+		outcome := audit.OutcomeSuccess
+		if writer.status == http.StatusUnauthorized || writer.status == http.StatusForbidden {
+			outcome = audit.OutcomeDenied
+		} else if writer.status >= 400 {
+			outcome = audit.OutcomeFailed
+		}
+
+		if details.actor != "" {
+			api.auditLog.Record(data.AuditEntry{Actor: details.actor, Action: action, Resources: resources, Outcome: outcome, ClientIP: common.ClientIP(r)})
+		} else {
+			api.user.Audit(r, action, outcome, resources...)
+		}
+	}
+}
+
+//auditResources Adds resources the route params don't cover to the request's audit entry.
+func auditResources(r *http.Request, resources ...string) {
+	if details, ok := r.Context().Value(auditDetailsKey{}).(*auditDetails); ok {
+		for _, resource := range resources {
+			if resource != "" {
+				details.resources = append(details.resources, resource)
+			}
+		}
+	}
+}
+
+//auditActor Says who the request's audit entry is for, on routes where that's not whoever the token belongs to (like
+//signing in, where there isn't a token yet).
+func auditActor(r *http.Request, actor string) {
+	if details, ok := r.Context().Value(auditDetailsKey{}).(*auditDetails); ok {
+		details.actor = actor
+	}
+}
+
+func (api *Routes) auditentries(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "auditentries", 401)
+		return
+	}
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		common.WriteResponse(resp, 400, nil, err)
+		return
+	}
+	entries, err := api.auditLog.Query(r.Context(), query)
+	if entries == nil {
+		entries = []data.AuditEntry{}
+	}
+	common.WriteResponse(resp, 500, entries, err)
+}
+
+//exportaudit Every matching entry, as JSON lines.
+func (api *Routes) exportaudit(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "exportaudit", 401)
+		return
+	}
+	query, err := auditQueryFromRequest(r)
+	if err != nil {
+		common.WriteResponse(resp, 400, nil, err)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/x-ndjson")
+	resp.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	//Too late to change the status by the time this fails, the export just ends early.
+	common.LogError("exportaudit", api.auditLog.Export(r.Context(), query, resp))
+}
+
+func (api *Routes) verifyaudit(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "verifyaudit", 401)
+		return
+	}
+	//The head a previous verification returned, if it was recorded somewhere.
+	var headSeq int64
+	if value := r.URL.Query().Get("headSeq"); value != "" {
+		var err error
+		if headSeq, err = strconv.ParseInt(value, 10, 64); err != nil || headSeq < 0 {
+			common.WriteResponse(resp, 400, nil, errors.New("headSeq has to be a positive number"))
+			return
+		}
+	}
+	result, err := api.auditLog.Verify(r.Context(), headSeq, r.URL.Query().Get("headHash"))
+	common.WriteResponse(resp, 500, result, err)
+}
+
+//auditQueryFromRequest Reads the actor, action, resource, outcome, since, until (ms timestamps), after (a seq) and
+//limit query params.
+func auditQueryFromRequest(r *http.Request) (query data.AuditQuery, err error) {
+	params := r.URL.Query()
+	query.Actor = params.Get("actor")
+	query.Action = params.Get("action")
+	query.Resource = params.Get("resource")
+	query.Outcome = params.Get("outcome")
+	for name, field := range map[string]*int64{"since": &query.Since, "until": &query.Until, "after": &query.AfterSeq, "limit": &query.Limit} {
+		if value := params.Get(name); value != "" {
+			if *field, err = strconv.ParseInt(value, 10, 64); err != nil || *field < 0 {
+				return query, errors.New(name + " has to be a positive number")
+			}
+		}
+	}
+	if query.Limit > maxAuditLimit {
+		query.Limit = maxAuditLimit
+	}
+	return query, nil
+}
@@ -1,33 +1,52 @@
 package api
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/audit"
 	"go.alargerobot.dev/notebook/common"
 	"go.alargerobot.dev/notebook/crypto"
 	"go.alargerobot.dev/notebook/data"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
+const (
+	jwtCacheKey = "jwtaccess"
+	//maxJWTCacheTTL The longest (in seconds) a validated JWT is trusted before it's checked again, even if it expires
+	//later than that.
+	maxJWTCacheTTL = 300
+)
+
 //Auth ...
 type Auth struct {
-	datastore   *data.DataStore
+	datastore   data.DataStore
 	vault       *crypto.VaultKMS
+	cache       data.CacheService
+	provider    AuthProvider
+	keyUsage    *keyUsageRecorder
+	auditLog    *audit.Log
 	knownScopes map[string]bool
 }
 
 //NewUserService ...
-func NewUserService(db *data.DataStore, vaultClient *crypto.VaultKMS) *Auth {
+func NewUserService(db data.DataStore, vaultClient *crypto.VaultKMS, cache data.CacheService, accountSvc *accounts.ServiceAPI, auditLog *audit.Log) *Auth {
 	authSvc := &Auth{
 		vault:       vaultClient,
 		datastore:   db,
+		cache:       cache,
+		provider:    NewAuthProvider(accountSvc),
+		keyUsage:    newKeyUsageRecorder(db),
+		auditLog:    auditLog,
 		knownScopes: make(map[string]bool),
 	}
+	authSvc.keyUsage.start()
 
 	authSvc.knownScopes["notebook"] = true
 	authSvc.knownScopes["notebook:read"] = true
@@ -37,6 +56,7 @@ func NewUserService(db *data.DataStore, vaultClient *crypto.VaultKMS) *Auth {
 	authSvc.knownScopes["tags"] = true
 	authSvc.knownScopes["admin"] = true
 	authSvc.knownScopes["admin:apikey"] = true
+	authSvc.knownScopes["admin:webhook"] = true
 
 	return authSvc
 }
@@ -66,7 +86,10 @@ func (u *Auth) AuthTokenProvided(r *http.Request) common.APIResponse {
 func (u *Auth) AnyTokenProvided(r *http.Request) common.APIResponse {
 	var tokenProvided bool
 	if success, header := u.GetUserHeader(r); success {
-		tokenProvided, _ = u.getTokenType(header)
+		var tokenType string
+		if tokenProvided, tokenType = u.getTokenType(r.Context(), header); !tokenProvided {
+			u.auditRejected(r, tokenType)
+		}
 	}
 	if tokenProvided {
 		return common.CreateAPIResponse("success", nil, 200)
@@ -77,9 +100,10 @@ func (u *Auth) AnyTokenProvided(r *http.Request) common.APIResponse {
 //NotAnAPIKey Used by Request validators to disallow access to a route if the provided token is not a JWT from Trinity.
 func (u *Auth) NotAnAPIKey(r *http.Request) common.APIResponse {
 	if success, header := u.GetUserHeader(r); success {
-		if validToken, tokenType := u.getTokenType(header); validToken && tokenType == "JWT" {
+		if validToken, tokenType := u.getTokenType(r.Context(), header); validToken && tokenType == "JWT" {
 			return common.CreateAPIResponse("success", nil, 200)
 		} else {
+			u.auditRejected(r, tokenType)
 			if tokenType != "JWT" {
 				return common.CreateAPIResponse("failed", errors.New("the provided token was not the correct type"), 403)
 			}
@@ -91,12 +115,39 @@ func (u *Auth) NotAnAPIKey(r *http.Request) common.APIResponse {
 	return common.CreateAPIResponse("failed", errors.New("no token was provided"), 401)
 }
 
+//Audit Records that whoever made the request did (or tried to do) action to resources.
+func (u *Auth) Audit(r *http.Request, action, outcome string, resources ...string) {
+	access, _ := u.GetAccessLevelFromToken(r)
+	u.auditLog.Record(data.AuditEntry{
+		Actor:     access.Username,
+		TokenType: access.TokenType,
+		KeyID:     access.KeyID,
+		Action:    action,
+		Resources: resources,
+		Outcome:   outcome,
+		ClientIP:  common.ClientIP(r),
+	})
+}
+
+//auditRejected Records a request turned away because of its token. Who it belongs to isn't known (or can't be trusted).
+func (u *Auth) auditRejected(r *http.Request, tokenType string) {
+	u.auditLog.Record(data.AuditEntry{
+		TokenType: tokenType,
+		Action:    "auth.token",
+		Resources: []string{r.URL.Path},
+		Outcome:   audit.OutcomeDenied,
+		ClientIP:  common.ClientIP(r),
+	})
+}
+
 //ValidateAPIToken Checks that the provided token is valid and allowed to perform the provided action.
-func (u *Auth) ValidateAPIToken(key, action string) (bool, error) {
+func (u *Auth) ValidateAPIToken(ctx context.Context, key, action string) (bool, error) {
 	hashed := hex.EncodeToString(common.ToSHA256Bytes([]byte(key)))
-	if key, err := u.datastore.GetAPIKey(hashed); err == nil {
-		if key.Hash == hashed {
-			isValid := strings.Contains(key.Scopes, action) && u.knownScopes[action] == true
+	if key, err := u.datastore.GetAPIKey(ctx, hashed); err == nil {
+		if apiKeyExpired(key) {
+			return false, errors.New("api key has expired")
+		} else if key.Hash == hashed {
+			isValid := common.Contains(key.ScopeList, action) && u.knownScopes[action] == true
 			if isValid {
 				return true, nil
 			} else {
@@ -112,38 +163,26 @@ func (u *Auth) ValidateAPIToken(key, action string) (bool, error) {
 
 //GetUsernameFromToken ...
 func (u *Auth) GetUsernameFromToken(r *http.Request) (string, error) {
-	if gotToken, token := u.GetUserHeader(r); gotToken {
-		_, tokenType := u.getTokenType(token)
-		if tokenType == "JWT" {
-			accessLvl, err := u.getJWTAccess(token)
-			if err == nil {
-				return accessLvl.Username, nil
-			} else {
-				return "", err
-			}
-		} else if tokenType == "API" {
-			accessLvl, err := u.getAPIKeyAccess(token)
-			if err == nil {
-				return accessLvl.Username, nil
-			} else {
-				return "", err
-			}
-		} else {
-			return "", errors.New("provided token was invalid")
-		}
-	} else {
-		return "", errors.New("no token provided, or provided token was invalid")
-	}
+	accessLvl, err := u.GetAccessLevelFromToken(r)
+	return accessLvl.Username, err
 }
 
-//GetAccessLevelFromToken ...
+//GetAccessLevelFromToken Resolves the access level of the request's token. It's only worked out once per request, the
+//validator and the handler share the result.
 func (u *Auth) GetAccessLevelFromToken(r *http.Request) (data.AccessLevel, error) {
+	accessLvl, err := common.Memoize(r, "accessLevel", func() (interface{}, error) {
+		return u.resolveAccessLevel(r)
+	})
+	return accessLvl.(data.AccessLevel), err
+}
+
+func (u *Auth) resolveAccessLevel(r *http.Request) (data.AccessLevel, error) {
 	if gotToken, token := u.GetUserHeader(r); gotToken {
-		_, tokenType := u.getTokenType(token)
+		_, tokenType := u.getTokenType(r.Context(), token)
 		if tokenType == "JWT" {
-			return u.getJWTAccess(token)
+			return u.getJWTAccess(r.Context(), token)
 		} else if tokenType == "API" {
-			return u.getAPIKeyAccess(token)
+			return u.getAPIKeyAccess(r.Context(), token, common.ClientIP(r))
 		} else {
 			return data.AccessLevel{}, errors.New("provided token was invalid")
 		}
@@ -152,29 +191,52 @@ func (u *Auth) GetAccessLevelFromToken(r *http.Request) (data.AccessLevel, error
 	}
 }
 
-//HasPermission ...
+//HasPermission Keys limited to tagged pages only ever have "notebook:read".
 func (u *Auth) HasPermission(r *http.Request, scope string) bool {
 	if access, e := u.GetAccessLevelFromToken(r); e != nil {
 		return false
 	} else {
-		if strings.Contains(scope, "notebook:") && common.Contains(access.Scopes, "notebook") {
+		if access.Constraints.ReadOnly() && scope != "notebook:read" {
+			return false
+		} else if strings.HasPrefix(scope, "notebook:") && common.Contains(access.Scopes, "notebook") {
 			return true
-		} else if strings.Contains(scope, "admin:") && common.Contains(access.Scopes, "admin") {
+		} else if strings.HasPrefix(scope, "admin:") && common.Contains(access.Scopes, "admin") {
 			return true
 		}
 		return common.Contains(access.Scopes, scope)
 	}
 }
 
-func (u *Auth) getTokenType(token string) (validToken bool, tokenType string) {
+//NotebookAllowed False if the request was made with a key that's limited to other notebooks.
+func (u *Auth) NotebookAllowed(r *http.Request, notebookID string) bool {
+	access, err := u.GetAccessLevelFromToken(r)
+	return err == nil && access.Constraints.AllowsNotebook(notebookID)
+}
+
+//PageAllowed False if the request was made with a key that's limited to other notebooks, or to pages with tags this
+//one doesn't have.
+func (u *Auth) PageAllowed(r *http.Request, page data.Page) bool {
+	access, err := u.GetAccessLevelFromToken(r)
+	return err == nil && access.Constraints.AllowsPage(page)
+}
+
+//GetConstraints What the request's key is limited to, nothing for anything other than a key.
+func (u *Auth) GetConstraints(r *http.Request) data.APIKeyConstraints {
+	access, _ := u.GetAccessLevelFromToken(r)
+	return access.Constraints
+}
+
+func (u *Auth) getTokenType(ctx context.Context, token string) (validToken bool, tokenType string) {
 	if _, err := jwt.ParseSigned(token); err == nil {
 		validToken = true
 		tokenType = "JWT"
 	} else {
 		hashedToken := hex.EncodeToString(common.ToSHA256Bytes([]byte(token)))
-		if _, err := u.datastore.GetAPIKey(hashedToken); err == nil {
+		if key, err := u.datastore.GetAPIKey(ctx, hashedToken); err == nil && !apiKeyExpired(key) {
 			validToken = true
 			tokenType = "API"
+		} else if err == nil {
+			tokenType = "API"
 		} else {
 			common.Logger.Errorln(err)
 		}
@@ -182,39 +244,55 @@ func (u *Auth) getTokenType(token string) (validToken bool, tokenType string) {
 	return validToken, tokenType
 }
 
-func (u *Auth) getJWTAccess(token string) (data.AccessLevel, error) {
-	var user data.User
-	var serviceResp common.APIResponse
-	c := http.Client{}
-	req, _ := http.NewRequest("GET", common.BaseAPIURL+"/trinity/user", nil)
-	req.Header.Add("Authorization", "Bearer "+token)
-	if httpResp, err := c.Do(req); err == nil && httpResp.StatusCode == 200 {
-		if body, err := ioutil.ReadAll(httpResp.Body); err == nil {
-			json.Unmarshal(body, &serviceResp)
-			json.Unmarshal([]byte(serviceResp.Response), &user)
-			lvl := data.AccessLevel{Username: user.Username}
-			lvl.Scopes = []string{"notebook", "tags"}
-			if user.Group == "root" {
-				lvl.Scopes = append(lvl.Scopes, "admin")
-			} else {
-				lvl.Scopes = append(lvl.Scopes, "admin:apikey")
-			}
-			return lvl, nil
-		} else {
-			return data.AccessLevel{}, common.LogError("apiResp", err)
-		}
-	} else {
+//getJWTAccess Checks the JWT with the auth provider. Checked tokens are cached (by hash) until they expire, or for
+//maxJWTCacheTTL if that's sooner.
+func (u *Auth) getJWTAccess(ctx context.Context, token string) (data.AccessLevel, error) {
+	var lvl data.AccessLevel
+	tokenHash := hex.EncodeToString(common.ToSHA256Bytes([]byte(token)))
+	if cached := u.cache.GetString(jwtCacheKey, tokenHash); cached != "" && json.Unmarshal([]byte(cached), &lvl) == nil {
+		return lvl, nil
+	}
+
+	identity, err := u.provider.Verify(ctx, token)
+	if err != nil {
 		return data.AccessLevel{}, err
 	}
+	lvl = data.AccessLevel{Username: identity.Username, TokenType: "JWT", Scopes: identity.Scopes}
+
+	//Tokens without an expiry aren't cached, there'd be nothing to bound how long they're trusted for.
+	if !identity.Expiry.IsZero() && !identity.NoCache {
+		ttl := int(time.Until(identity.Expiry).Seconds())
+		if ttl > maxJWTCacheTTL {
+			ttl = maxJWTCacheTTL
+		}
+		if encoded, err := json.Marshal(lvl); err == nil && ttl > 0 {
+			u.cache.PutStringWithExpiration(jwtCacheKey, tokenHash, string(encoded), ttl)
+		}
+	}
+	return lvl, nil
 }
-func (u *Auth) getAPIKeyAccess(token string) (data.AccessLevel, error) {
+
+//getAPIKeyAccess Also records the use of the key, and who from.
+func (u *Auth) getAPIKeyAccess(ctx context.Context, token, ip string) (data.AccessLevel, error) {
 	hashedToken := hex.EncodeToString(common.ToSHA256Bytes([]byte(token)))
-	if token, err := u.datastore.GetAPIKey(hashedToken); err == nil {
+	if token, err := u.datastore.GetAPIKey(ctx, hashedToken); err == nil {
+		if apiKeyExpired(token) {
+			return data.AccessLevel{}, errors.New("api key has expired")
+		}
+		u.keyUsage.record(hashedToken, ip)
 		return data.AccessLevel{
-			Username: token.Creator,
-			Scopes:   strings.Split(token.Scopes, ","),
+			Username:    token.Creator,
+			TokenType:   "API",
+			KeyID:       token.ID,
+			Scopes:      token.ScopeList,
+			Constraints: token.Constraints,
 		}, nil
 	} else {
 		return data.AccessLevel{}, err
 	}
 }
+
+//apiKeyExpired Keys with no expiry never do.
+func apiKeyExpired(key data.UserAPIKey) bool {
+	return key.ExpiresAt != 0 && key.ExpiresAt <= common.UnixTimestampInMS()
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/common"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || common.Contains(common.AllowedOrigins(), origin)
+	},
+}
+
+func (api *Routes) initCollabRoutes() {
+	api.handle(Route{Name: "livepage", Method: "GET", Path: "/api/ash/notebook/:nbid/live/:id", Access: AccessToken, Scope: "notebook:write", TokenInQuery: true,
+		Summary: "Upgrades to a websocket for editing the page together", ResponseType: "websocket"}, api.livepage)
+}
+
+func (api *Routes) livepage(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "livepage", 401)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); !allowed {
+		api.writeAccessDenied(resp, err, "livepage")
+		return
+	}
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		common.WriteFailureResponse(err, resp, "livepage", 400)
+		return
+	}
+	conn, err := upgrader.Upgrade(resp, r, nil)
+	if err != nil {
+		common.LogError("", err)
+		return
+	}
+	api.collab.Serve(conn, vestigo.Param(r, "id"), vestigo.Param(r, "nbid"), username)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+func (api *Routes) initCommentRoutes() {
+	api.handle(Route{Name: "comments", Method: "GET", Path: "/api/ash/notebook/:nbid/page/:id/comments", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The page's comments", Response: []data.PageComment{}}, api.comments)
+	api.handle(Route{Name: "newcomment", Method: "POST", Path: "/api/ash/notebook/:nbid/page/:id/comments/new", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Comments on the page", Request: data.NewCommentRequest{}, Response: data.PageComment{}}, api.newcomment)
+	api.handle(Route{Name: "editcomment", Method: "PUT", Path: "/api/ash/notebook/:nbid/page/:id/comments/:cid", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Edits one of the user's comments", Request: data.EditCommentRequest{}}, api.editcomment)
+	api.handle(Route{Name: "resolvecomment", Method: "PUT", Path: "/api/ash/notebook/:nbid/page/:id/comments/:cid/resolve", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Resolves (or reopens) a comment", Request: data.ResolveCommentRequest{}}, api.resolvecomment)
+	api.handle(Route{Name: "deletecomment", Method: "DELETE", Path: "/api/ash/notebook/:nbid/page/:id/comments/:cid/delete", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Deletes a comment"}, api.deletecomment)
+
+	api.handle(Route{Name: "sharedcommentvisibility", Method: "PUT", Path: "/api/ash/sharing/comments/:id", Access: AccessUser,
+		Summary: "Shows (or hides) the page's comments through the share link", Request: map[string]bool{}}, api.sharedcommentvisibility)
+	api.handle(Route{Name: "sharedpagecomments", Method: "GET", Path: "/api/ash/sharing/:id/comments", Access: AccessPublic,
+		Summary: "The comments on the page a share link points at, if they're shared", Response: []data.PageComment{}}, api.sharedpagecomments)
+}
+
+func (api *Routes) comments(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:read") {
+		if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); allowed {
+			comments, err := api.notebookSvc.GetComments(r.Context(), vestigo.Param(r, "id"), vestigo.Param(r, "nbid"))
+			common.WriteResponse(resp, 500, comments, err)
+		} else {
+			api.writeAccessDenied(resp, err, "comments")
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "comments", 401)
+	}
+}
+func (api *Routes) newcomment(resp http.ResponseWriter, r *http.Request) {
+	var request data.NewCommentRequest
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "newcomment", 401)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); !allowed {
+		api.writeAccessDenied(resp, err, "newcomment")
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "newcomment", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		comment, err := api.notebookSvc.AddComment(r.Context(), request, vestigo.Param(r, "id"), vestigo.Param(r, "nbid"), username)
+		common.WriteResponse(resp, 400, comment, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "newcomment", 400)
+	}
+}
+func (api *Routes) editcomment(resp http.ResponseWriter, r *http.Request) {
+	var request data.EditCommentRequest
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "editcomment", 401)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); !allowed {
+		api.writeAccessDenied(resp, err, "editcomment")
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "editcomment", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		common.WriteResponse(resp, 400, nil, api.notebookSvc.EditComment(r.Context(), vestigo.Param(r, "cid"), request.Body, vestigo.Param(r, "id"), vestigo.Param(r, "nbid"), username))
+	} else {
+		common.WriteFailureResponse(err, resp, "editcomment", 400)
+	}
+}
+func (api *Routes) resolvecomment(resp http.ResponseWriter, r *http.Request) {
+	var request data.ResolveCommentRequest
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "resolvecomment", 401)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); !allowed {
+		api.writeAccessDenied(resp, err, "resolvecomment")
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "resolvecomment", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		common.WriteResponse(resp, 400, nil, api.notebookSvc.ResolveComment(r.Context(), vestigo.Param(r, "cid"), vestigo.Param(r, "id"), request.Resolved, username))
+	} else {
+		common.WriteFailureResponse(err, resp, "resolvecomment", 400)
+	}
+}
+func (api *Routes) deletecomment(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "deletecomment", 401)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); !allowed {
+		api.writeAccessDenied(resp, err, "deletecomment")
+		return
+	}
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		common.WriteFailureResponse(err, resp, "deletecomment", 400)
+		return
+	}
+	creator, err := api.data.GetPageCreator(r.Context(), vestigo.Param(r, "id"))
+	if err != nil {
+		common.WriteFailureResponse(err, resp, "deletecomment", 500)
+		return
+	}
+	common.WriteResponse(resp, 400, nil, api.notebookSvc.DeleteComment(r.Context(), vestigo.Param(r, "cid"), vestigo.Param(r, "id"), username, creator == username))
+}
+func (api *Routes) sharedcommentvisibility(resp http.ResponseWriter, r *http.Request) {
+	var request map[string]bool
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		common.WriteResponse(resp, 400, nil, err)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "sharedcommentvisibility", 400)
+		return
+	}
+	common.WriteResponse(resp, 400, nil, api.data.SetSharedPageComments(r.Context(), vestigo.Param(r, "id"), username, request["allow"]))
+}
+func (api *Routes) sharedpagecomments(resp http.ResponseWriter, r *http.Request) {
+	pageToken := vestigo.Param(r, "id")
+	if pageToken == "" {
+		common.WriteResponse(resp, 400, nil, errors.New("page token not specified"))
+		return
+	}
+	sharedPageMD, err := api.data.GetSharedPageInfo(r.Context(), pageToken)
+	if err != nil {
+		common.WriteResponse(resp, 400, nil, err)
+		return
+	}
+	if !sharedPageMD.AllowComments {
+		common.WriteResponse(resp, 403, nil, errors.New("comments aren't shared for this page"))
+		return
+	}
+	comments, err := api.notebookSvc.GetComments(r.Context(), sharedPageMD.PageID, sharedPageMD.NotebookID)
+	common.WriteResponse(resp, 500, comments, err)
+}
+
+func (api *Routes) writeAccessDenied(resp http.ResponseWriter, err error, functionName string) {
+	if err != nil {
+		common.WriteFailureResponse(err, resp, functionName, 500)
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, functionName, 401)
+	}
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.alargerobot.dev/notebook/audit"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const (
+	//graphqlCacheSeconds How long a query's result is cached for.
+	graphqlCacheSeconds = 60
+	//graphqlGenerations Where each user's generation is kept, see graphqlCache.
+	graphqlGenerations = "dcgqlgen"
+	//graphqlEveryone The generation of what every user can see, like tags.
+	graphqlEveryone = "*"
+)
+
+//graphqlQuery A GraphQL request, as it's POSTed.
+type graphqlQuery struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+//graphqlAudit An audit entry a query's resolvers asked for. They're kept with the cached result, so reading a page's
+//content out of the cache is audited the same as decrypting it.
+type graphqlAudit struct {
+	Action    string   `json:"action"`
+	Resources []string `json:"resources"`
+}
+
+//graphqlCacheEntry ...
+type graphqlCacheEntry struct {
+	Result json.RawMessage `json:"result"`
+	Audits []graphqlAudit  `json:"audits,omitempty"`
+}
+
+//graphqlState What the resolvers of a single request share.
+type graphqlState struct {
+	r      *http.Request
+	audits []graphqlAudit
+}
+
+type graphqlStateKey struct{}
+
+//graphqlError An error as the resolvers return it, an APIError (see common.ToAPIError) with its code in the
+//extensions so clients can switch on it like they would under /api/v2.
+type graphqlError struct {
+	*common.APIError
+}
+
+//Extensions ...
+func (e graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code, "status": e.Status}
+}
+
+//graphqlCache Query results are cached for graphqlCacheSeconds, keyed by the hash of the token (what a query can see
+//depends on it), the query and the generations of the user and of everyone. A generation changes whenever something a
+//query could have read does, on any instance sharing the cache, so a result is never served once it's out of date.
+type graphqlCache struct {
+	cache data.CacheService
+}
+
+//Emit Every event is a change to something a query could have read on behalf of username.
+func (c *graphqlCache) Emit(username, event string, payload interface{}) {
+	c.invalidate(username)
+}
+
+//invalidate Starts a new generation. It only has to outlive the results cached in the one before it, after that an
+//expired generation can't be mistaken for a live one.
+func (c *graphqlCache) invalidate(username string) {
+	c.cache.PutStringWithExpiration(graphqlGenerations, username, strconv.FormatInt(time.Now().UnixNano(), 10), graphqlCacheSeconds)
+}
+
+func (c *graphqlCache) key(token, username string, query graphqlQuery) string {
+	variables, _ := json.Marshal(query.Variables)
+	return strings.Join([]string{
+		token,
+		c.cache.GetString(graphqlGenerations, username),
+		c.cache.GetString(graphqlGenerations, graphqlEveryone),
+		query.OperationName,
+		query.Query,
+		string(variables),
+	}, "\x00")
+}
+
+func (c *graphqlCache) get(cacheKey string) *graphqlCacheEntry {
+	cached := c.cache.GetCachedQuery(cacheKey)
+	if cached == "" {
+		return nil
+	}
+	var entry graphqlCacheEntry
+	if err := json.Unmarshal([]byte(cached), &entry); err != nil {
+		c.cache.DeleteCachedQuery(cacheKey)
+		return nil
+	}
+	return &entry
+}
+
+func (c *graphqlCache) put(cacheKey string, result *graphql.Result, audits []graphqlAudit) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	if entry, err := json.Marshal(graphqlCacheEntry{Result: encoded, Audits: audits}); err == nil {
+		c.cache.CacheQuery(cacheKey, string(entry), graphqlCacheSeconds)
+	}
+}
+
+//initGraphQLRoutes GraphQL over notebooks, pages, tags and shared pages, so a client can fetch what a view needs in
+//one request. It's an /api/v2 route: the request and response are plain JSON and anything that fails before the query
+//runs is an APIError.
+func (api *Routes) initGraphQLRoutes() {
+	schema, err := api.graphqlSchema()
+	if err != nil {
+		panic(err)
+	}
+	api.schema = schema
+
+	api.handle(Route{Name: "graphql", Method: "POST", Path: "/api/v2/graphql", Access: AccessToken, SecondFactor: true,
+		Summary: "Runs a GraphQL query or mutation, each field checks the token's scopes itself", Request: graphqlQuery{}, Response: graphql.Result{}}, api.graphqlquery)
+}
+
+//graphqlquery Queries (but not mutations) that succeed are cached, see graphqlCache. Failures inside the query are
+//in the result's errors like GraphQL clients expect, with a 200.
+func (api *Routes) graphqlquery(resp http.ResponseWriter, r *http.Request) {
+	var query graphqlQuery
+	err := decodeV2Body(resp, r, &query)
+	if err == nil && strings.TrimSpace(query.Query) == "" {
+		err = v2Invalid("query is required")
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		common.WriteAPIError(resp, common.NewAPIError(http.StatusUnauthorized, common.ErrCodeUnauthorized, "invalid token"))
+		return
+	}
+
+	var cacheKey string
+	if isGraphQLQuery(query) {
+		_, token := api.user.GetUserHeader(r)
+		cacheKey = api.gqlCache.key(token, username, query)
+		if entry := api.gqlCache.get(cacheKey); entry != nil {
+			for _, audited := range entry.Audits {
+				api.user.Audit(r, audited.Action, audit.OutcomeSuccess, audited.Resources...)
+			}
+			common.WriteJSON(resp, http.StatusOK, entry.Result)
+			return
+		}
+	}
+
+	state := &graphqlState{r: r}
+	result := graphql.Do(graphql.Params{
+		Schema:         api.schema,
+		RequestString:  query.Query,
+		VariableValues: query.Variables,
+		OperationName:  query.OperationName,
+		Context:        context.WithValue(r.Context(), graphqlStateKey{}, state),
+	})
+	if cacheKey != "" && !result.HasErrors() {
+		api.gqlCache.put(cacheKey, result, state.audits)
+	}
+	common.WriteJSON(resp, http.StatusOK, result)
+}
+
+//isGraphQLQuery Whether the operation the request runs is a query. Anything that doesn't parse is left for graphql.Do
+//to report.
+func isGraphQLQuery(query graphqlQuery) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query.Query})
+	if err != nil {
+		return false
+	}
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if query.OperationName == "" || (operation.Name != nil && operation.Name.Value == query.OperationName) {
+			return operation.Operation == ast.OperationTypeQuery
+		}
+	}
+	return false
+}
+
+//graphqlRequest The request a resolver is running for.
+func graphqlRequest(ctx context.Context) *http.Request {
+	return ctx.Value(graphqlStateKey{}).(*graphqlState).r
+}
+
+//graphqlAudited Records action (as a success, resolvers only call this once it's happened) and remembers it for the
+//cached result.
+func (api *Routes) graphqlAudited(ctx context.Context, action string, resources ...string) {
+	state := ctx.Value(graphqlStateKey{}).(*graphqlState)
+	api.user.Audit(state.r, action, audit.OutcomeSuccess, resources...)
+	state.audits = append(state.audits, graphqlAudit{Action: action, Resources: resources})
+}
+
+//graphqlResolver Gives resolve the request it's running for, and turns an error it returns into a graphqlError.
+func graphqlResolver(resolve func(p graphql.ResolveParams, r *http.Request) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		result, err := resolve(p, graphqlRequest(p.Context))
+		if err != nil {
+			return nil, graphqlError{common.ToAPIError(err)}
+		}
+		return result, nil
+	}
+}
+
+//graphqlMutation graphqlResolver for a mutation, which is audited as action with the resources resolve names whether
+//it works or not.
+func (api *Routes) graphqlMutation(action string, resolve func(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		r := graphqlRequest(p.Context)
+		result, resources, err := resolve(p, r)
+		if err == nil {
+			api.user.Audit(r, action, audit.OutcomeSuccess, resources...)
+			return result, nil
+		}
+		apiErr := common.ToAPIError(err)
+		if apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden {
+			api.user.Audit(r, action, audit.OutcomeDenied, resources...)
+		} else {
+			api.user.Audit(r, action, audit.OutcomeFailed, resources...)
+		}
+		return nil, graphqlError{apiErr}
+	}
+}
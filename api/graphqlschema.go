@@ -0,0 +1,570 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+	"go.alargerobot.dev/notebook/notebook"
+)
+
+//graphqlSchema Field names are the JSON names the rest of the API uses. Every field that reads or changes something
+//checks the token's scopes (and key constraints) the same way the matching /api/v2 route does, and the ones matching
+//AccessUser routes turn away API keys.
+func (api *Routes) graphqlSchema() (graphql.Schema, error) {
+	timestamp := graphql.NewScalar(graphql.ScalarConfig{
+		Name:        "Timestamp",
+		Description: "Milliseconds since the epoch, which don't fit in an Int.",
+		Serialize: func(value interface{}) interface{} {
+			if ms, ok := value.(int64); ok {
+				return ms
+			}
+			return nil
+		},
+	})
+	stringList := graphql.NewList(graphql.NewNonNull(graphql.String))
+
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Page",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"notebookID": &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"title":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"tags":       &graphql.Field{Type: stringList, Description: "The IDs of the page's tags."},
+			"creator":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"lastEdited": &graphql.Field{Type: graphql.NewNonNull(timestamp)},
+			"content": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "The page's content, only decrypted when it's asked for.",
+				Resolve:     graphqlResolver(api.graphqlPageContent),
+			},
+		},
+	})
+	pageListType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageList",
+		Fields: graphql.Fields{
+			"pages": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(pageType)))},
+			"next": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The cursor for the next pages, null once there's nothing left.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if list := p.Source.(data.PageList); list.NextCursor != "" {
+						return list.NextCursor, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+	notebookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Notebook",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"pages": &graphql.Field{
+				Type:        graphql.NewNonNull(pageListType),
+				Description: "Every page, unless limit or cursor are given.",
+				Args: graphql.FieldConfigArgument{
+					"sort":   &graphql.ArgumentConfig{Type: graphql.String, Description: `"title" (the default) or "lastEdited".`},
+					"order":  &graphql.ArgumentConfig{Type: graphql.String, Description: `"asc" (the default) or "desc".`},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int},
+					"cursor": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: graphqlResolver(api.graphqlPages),
+			},
+			"pagesWithTags": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(pageType))),
+				Args: graphql.FieldConfigArgument{
+					"tags": &graphql.ArgumentConfig{Type: graphql.NewNonNull(stringList)},
+				},
+				Resolve: graphqlResolver(api.graphqlPagesWithTags),
+			},
+		},
+	})
+	tagType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Tag",
+		Fields: graphql.Fields{
+			"tagId":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"tagValue": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"creator":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	sharedPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SharedPage",
+		Fields: graphql.Fields{
+			"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"owner":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"pageID":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"pageTitle":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"notebookID":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"accessToken":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"allowComments": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	sharedPageContentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SharedPageContent",
+		Fields: graphql.Fields{
+			"title":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"lastEdit":      &graphql.Field{Type: graphql.NewNonNull(timestamp)},
+			"content":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"allowComments": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	id := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}
+	pageArgs := graphql.FieldConfigArgument{
+		"notebookID": id,
+		"title":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		"tags":       &graphql.ArgumentConfig{Type: stringList},
+		"content":    &graphql.ArgumentConfig{Type: graphql.String, Description: "Left out (or empty) when editing keeps the page's content."},
+	}
+	editPageArgs := graphql.FieldConfigArgument{"id": id}
+	for name, arg := range pageArgs {
+		editPageArgs[name] = arg
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"notebooks": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(notebookType))),
+				Resolve: graphqlResolver(api.graphqlNotebooks),
+			},
+			"notebook": &graphql.Field{
+				Type:    notebookType,
+				Args:    graphql.FieldConfigArgument{"id": id},
+				Resolve: graphqlResolver(api.graphqlNotebook),
+			},
+			"page": &graphql.Field{
+				Type:    pageType,
+				Args:    graphql.FieldConfigArgument{"notebookID": id, "id": id},
+				Resolve: graphqlResolver(api.graphqlPage),
+			},
+			"tags": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(tagType))),
+				Resolve: graphqlResolver(api.graphqlTags),
+			},
+			"sharedPages": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(sharedPageType))),
+				Description: "The user's share links. Not for API keys.",
+				Resolve:     graphqlResolver(api.graphqlSharedPages),
+			},
+			"sharedPage": &graphql.Field{
+				Type:        sharedPageContentType,
+				Description: "The page a share link points at.",
+				Args:        graphql.FieldConfigArgument{"token": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve:     graphqlResolver(api.graphqlSharedPage),
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createNotebook": &graphql.Field{
+				Type:    graphql.NewNonNull(notebookType),
+				Args:    graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: api.graphqlMutation("notebook.create", api.graphqlCreateNotebook),
+			},
+			"deleteNotebook": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes the notebook and everything in it. Needs the X-Second-Factor header if the user has two-factor authentication turned on.",
+				Args:        graphql.FieldConfigArgument{"id": id},
+				Resolve:     api.graphqlMutation("notebook.delete", api.graphqlDeleteNotebook),
+			},
+			"createPage": &graphql.Field{
+				Type:    graphql.NewNonNull(pageType),
+				Args:    pageArgs,
+				Resolve: api.graphqlMutation("page.create", api.graphqlCreatePage),
+			},
+			"editPage": &graphql.Field{
+				Type:    graphql.NewNonNull(pageType),
+				Args:    editPageArgs,
+				Resolve: api.graphqlMutation("page.edit", api.graphqlEditPage),
+			},
+			"deletePage": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"notebookID": id, "id": id},
+				Resolve: api.graphqlMutation("page.delete", api.graphqlDeletePage),
+			},
+			"createTag": &graphql.Field{
+				Type:    graphql.NewNonNull(tagType),
+				Args:    graphql.FieldConfigArgument{"value": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: graphqlResolver(api.graphqlCreateTag),
+			},
+			"deleteTag": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"id": id},
+				Resolve: graphqlResolver(api.graphqlDeleteTag),
+			},
+			"sharePage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Shares one of the user's pages and returns the share link's token. Not for API keys.",
+				Args: graphql.FieldConfigArgument{
+					"notebookID":    id,
+					"pageID":        id,
+					"allowComments": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: api.graphqlMutation("page.share", api.graphqlSharePage),
+			},
+			"unsharePage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes the share link with that id (see sharedPages, it isn't the token), false if the user doesn't have one. Not for API keys.",
+				Args:        graphql.FieldConfigArgument{"id": id},
+				Resolve:     api.graphqlMutation("page.unshare", api.graphqlUnsharePage),
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+func (api *Routes) graphqlNotebooks(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err != nil {
+		return nil, err
+	}
+	refs, err := api.notebookSvc.GetNotebooks(p.Context, username)
+	if err != nil {
+		return nil, err
+	}
+	allowed := []data.NotebookReference{}
+	for _, ref := range refs {
+		if api.user.NotebookAllowed(r, ref.ID) {
+			allowed = append(allowed, ref)
+		}
+	}
+	return allowed, nil
+}
+
+func (api *Routes) graphqlNotebook(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err != nil {
+		return nil, err
+	}
+	return api.v2Notebook(r, graphqlString(p, "id"), username)
+}
+
+//graphqlPages Like the pages route, every page unless limit or cursor say otherwise.
+func (api *Routes) graphqlPages(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err != nil {
+		return nil, err
+	}
+	query := data.PageQuery{
+		SortBy:     graphqlString(p, "sort"),
+		Descending: graphqlString(p, "order") == "desc",
+		Cursor:     graphqlString(p, "cursor"),
+	}
+	if limit, ok := p.Args["limit"].(int); ok {
+		if limit <= 0 || limit > maxPageListLimit {
+			return nil, v2Invalid("limit must be between 1 and " + strconv.Itoa(maxPageListLimit))
+		}
+		query.Limit = int64(limit)
+	} else if query.Cursor != "" {
+		query.Limit = defaultPageListLimit
+	}
+
+	pages, err := api.notebookSvc.GetPages(p.Context, p.Source.(data.NotebookReference).ID, username, query)
+	if err != nil {
+		return nil, err
+	}
+	if pages.Pages = api.allowedPages(r, pages.Pages); pages.Pages == nil {
+		pages.Pages = []data.Page{}
+	}
+	return pages, nil
+}
+
+func (api *Routes) graphqlPagesWithTags(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	if _, err := api.v2Access(r, "notebook:read"); err != nil {
+		return nil, err
+	}
+	pages, err := api.data.GetPagesWithTags(p.Context, graphqlStrings(p, "tags"), p.Source.(data.NotebookReference).ID)
+	if err != nil {
+		return nil, err
+	}
+	if pages = api.allowedPages(r, pages); pages == nil {
+		pages = []data.Page{}
+	}
+	return pages, nil
+}
+
+func (api *Routes) graphqlPage(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	pageID, notebookID := graphqlString(p, "id"), graphqlString(p, "notebookID")
+	_, err := api.v2Access(r, "notebook:read")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.notebookSvc.GetPageMetadata(p.Context, pageID, notebookID)
+}
+
+//graphqlPageContent Pages only ever come from fields that have already checked the user can see them.
+func (api *Routes) graphqlPageContent(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	page := p.Source.(data.Page)
+	if _, err := api.v2Access(r, "notebook:read"); err != nil {
+		return nil, err
+	}
+	content, err := api.notebookSvc.ReadPage(p.Context, page.ID, page.NotebookID)
+	if err != nil {
+		return nil, err
+	}
+	api.graphqlAudited(p.Context, "page.read", page.NotebookID, page.ID)
+	return content, nil
+}
+
+func (api *Routes) graphqlTags(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	if _, err := api.v2Access(r, "tags"); err != nil {
+		return nil, err
+	}
+	tags, err := api.data.GetTags(p.Context)
+	if err == nil && tags == nil {
+		tags = []data.PageTag{}
+	}
+	return tags, err
+}
+
+func (api *Routes) graphqlSharedPages(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	username, err := api.graphqlUserOnly(r)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := api.data.GetSharedPages(p.Context, username)
+	if err == nil && pages == nil {
+		pages = []data.SharedPage{}
+	}
+	return pages, err
+}
+
+func (api *Routes) graphqlSharedPage(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	token := graphqlString(p, "token")
+	shared, err := api.data.GetSharedPageInfo(p.Context, token)
+	if err != nil {
+		return nil, common.NewAPIError(http.StatusNotFound, common.ErrCodeNotFound, "no such shared page")
+	}
+	content, err := api.notebookSvc.ReadPage(p.Context, shared.PageID, shared.NotebookID)
+	if err != nil {
+		return nil, err
+	}
+	page, err := api.data.GetPageByID(p.Context, shared.PageID, shared.NotebookID)
+	if err != nil {
+		return nil, err
+	}
+	api.graphqlAudited(p.Context, "sharedpage.read", token)
+	return data.SharedPageContent{Title: page.Title, LastEdit: page.LastEdited, Content: content, AllowComments: shared.AllowComments}, nil
+}
+
+func (api *Routes) graphqlCreateNotebook(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	username, err := api.v2Access(r, "notebook:create")
+	if err == nil && len(api.user.GetConstraints(r).Notebooks) > 0 {
+		err = common.NewAPIError(http.StatusForbidden, common.ErrCodeForbidden, "this key is limited to existing notebooks")
+	}
+	if err == nil && strings.TrimSpace(graphqlString(p, "name")) == "" {
+		err = v2Invalid("name is required")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	ref, err := api.notebookSvc.NewNotebook(p.Context, data.Notebook{
+		Name:  graphqlString(p, "name"),
+		ID:    uuid.New().String(),
+		Owner: username,
+		Pages: []data.Page{},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, []string{ref.ID}, nil
+}
+
+func (api *Routes) graphqlDeleteNotebook(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	notebookID := graphqlString(p, "id")
+	username, err := api.v2Access(r, "notebook:delete")
+	if err == nil {
+		_, err = api.v2Notebook(r, notebookID, username)
+	}
+	if err == nil {
+		err = api.v2SecondFactor(r, username)
+	}
+	if err == nil {
+		err = api.notebookSvc.DeleteNotebook(p.Context, notebookID, username)
+	}
+	return err == nil, []string{notebookID}, err
+}
+
+func (api *Routes) graphqlCreatePage(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	notebookID := graphqlString(p, "notebookID")
+	request := v2PageRequest{Title: graphqlString(p, "title"), Tags: graphqlStrings(p, "tags"), Content: graphqlString(p, "content")}
+	username, err := api.v2Access(r, "notebook:write")
+	if err == nil {
+		_, err = api.v2Notebook(r, notebookID, username)
+	}
+	if err == nil {
+		err = api.v2ValidatePage(r, request, username)
+	}
+	if err != nil {
+		return nil, []string{notebookID}, err
+	}
+
+	page := data.Page{
+		ID:         uuid.New().String(),
+		NotebookID: notebookID,
+		Tags:       request.Tags,
+		Title:      request.Title,
+		Creator:    username,
+		LastEdited: common.UnixTimestampInMS(),
+	}
+	err = api.notebookSvc.NewPage(p.Context, data.NewPageRequest{Metadata: page, Content: request.Content, NotebookID: notebookID})
+	return page, []string{notebookID, page.ID}, err
+}
+
+func (api *Routes) graphqlEditPage(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	pageID, notebookID := graphqlString(p, "id"), graphqlString(p, "notebookID")
+	resources := []string{notebookID, pageID}
+	request := v2PageRequest{Title: graphqlString(p, "title"), Tags: graphqlStrings(p, "tags"), Content: graphqlString(p, "content")}
+	username, err := api.v2Access(r, "notebook:write")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err == nil {
+		err = api.v2ValidatePage(r, request, username)
+	}
+	if err != nil {
+		return nil, resources, err
+	}
+
+	page, err := api.notebookSvc.GetPageMetadata(p.Context, pageID, notebookID)
+	if err != nil {
+		return nil, resources, err
+	}
+	page.Title, page.Tags = request.Title, request.Tags
+	if err := api.notebookSvc.EditPage(p.Context, data.NewPageRequest{Metadata: page, NotebookID: notebookID}, request.Content); err != nil {
+		return nil, resources, err
+	}
+	page, err = api.notebookSvc.GetPageMetadata(p.Context, pageID, notebookID)
+	return page, resources, err
+}
+
+func (api *Routes) graphqlDeletePage(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	pageID, notebookID := graphqlString(p, "id"), graphqlString(p, "notebookID")
+	username, err := api.v2Access(r, "notebook:delete")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err == nil {
+		err = api.notebookSvc.DeletePage(p.Context, pageID, notebookID, username)
+	}
+	return err == nil, []string{notebookID, pageID}, err
+}
+
+//graphqlCreateTag Tags are everyone's, so every user's cached results go out of date.
+func (api *Routes) graphqlCreateTag(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	username, err := api.v2Access(r, "tags")
+	if err == nil && strings.TrimSpace(graphqlString(p, "value")) == "" {
+		err = v2Invalid("value is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	tag, err := api.data.NewTag(p.Context, data.PageTag{TagID: uuid.New().String(), TagValue: graphqlString(p, "value"), Creator: username})
+	if err != nil {
+		return nil, err
+	}
+	api.gqlCache.invalidate(graphqlEveryone)
+	return tag, nil
+}
+
+func (api *Routes) graphqlDeleteTag(p graphql.ResolveParams, r *http.Request) (interface{}, error) {
+	_, err := api.v2Access(r, "tags")
+	if err == nil {
+		err = api.data.DeleteTag(p.Context, graphqlString(p, "id"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	api.gqlCache.invalidate(graphqlEveryone)
+	return true, nil
+}
+
+//graphqlSharePage The sharepage route, except the page's title is looked up rather than given.
+func (api *Routes) graphqlSharePage(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	request := data.SharePageRequest{PageID: graphqlString(p, "pageID"), NotebookID: graphqlString(p, "notebookID")}
+	request.AllowComments, _ = p.Args["allowComments"].(bool)
+	resources := []string{request.NotebookID, request.PageID}
+	username, err := api.graphqlUserOnly(r)
+	if err != nil {
+		return nil, resources, err
+	}
+	if creator, err := api.data.GetPageCreator(p.Context, request.PageID); err != nil || creator != username {
+		return nil, resources, common.NewAPIError(http.StatusNotFound, common.ErrCodeNotFound, "no such page")
+	}
+	page, err := api.notebookSvc.GetPageMetadata(p.Context, request.PageID, request.NotebookID)
+	if err != nil {
+		return nil, resources, err
+	}
+	request.PageTitle = page.Title
+
+	shared, err := api.data.NewSharedPage(p.Context, request, username)
+	if err != nil {
+		return nil, resources, err
+	}
+	api.emitter.Emit(username, notebook.EventPageShared, shared)
+	return shared.AccessToken, resources, nil
+}
+
+func (api *Routes) graphqlUnsharePage(p graphql.ResolveParams, r *http.Request) (interface{}, []string, error) {
+	sharedPageID := graphqlString(p, "id")
+	username, err := api.graphqlUserOnly(r)
+	if err != nil {
+		return nil, []string{sharedPageID}, err
+	}
+	deleted, err := api.data.DeleteSharedPage(p.Context, sharedPageID, username)
+	if errors.Is(err, data.ErrNotFound) {
+		return false, []string{sharedPageID}, nil
+	} else if err != nil {
+		return nil, []string{sharedPageID}, err
+	}
+	if deleted {
+		api.emitter.Emit(username, notebook.EventPageUnshared, map[string]string{"id": sharedPageID})
+	}
+	return deleted, []string{sharedPageID}, nil
+}
+
+//graphqlUserOnly What AccessUser routes check, for the fields that match them.
+func (api *Routes) graphqlUserOnly(r *http.Request) (string, error) {
+	if resp := api.user.NotAnAPIKey(r); resp.Status != "success" {
+		return "", common.APIErrorFromResponse(resp)
+	}
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		return "", common.NewAPIError(http.StatusUnauthorized, common.ErrCodeUnauthorized, "invalid token")
+	}
+	return username, nil
+}
+
+func graphqlString(p graphql.ResolveParams, name string) string {
+	value, _ := p.Args[name].(string)
+	return value
+}
+
+//graphqlStrings nil when the argument wasn't given.
+func graphqlStrings(p graphql.ResolveParams, name string) []string {
+	values, ok := p.Args[name].([]interface{})
+	if !ok {
+		return nil
+	}
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	//jwksRefreshInterval How long a fetched key set is used before it's fetched again, and how long we wait before
+	//trying again when the provider doesn't publish one.
+	jwksRefreshInterval = 10 * time.Minute
+)
+
+//errNoJWKS Returned when a token can't be checked locally (no key set, or no key in it with the token's key ID), in
+//which case the provider has to be asked instead.
+var errNoJWKS = errors.New("no signing key available to verify the token locally")
+
+//jwksVerifier Verifies JWTs against the key set an identity provider publishes, so they can be checked without a
+//round trip.
+type jwksVerifier struct {
+	url       string
+	client    http.Client
+	lock      sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func newJWKSVerifier(url string) *jwksVerifier {
+	return &jwksVerifier{url: url, client: http.Client{Timeout: 2 * time.Second}}
+}
+
+//verify Checks the token's signature and decodes its claims into claims, which are left for the caller to validate.
+//Returns errNoJWKS if the token can't be checked locally.
+func (v *jwksVerifier) verify(ctx context.Context, token string, claims ...interface{}) error {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return err
+	}
+	if len(parsed.Headers) == 0 {
+		return errors.New("token has no signature header")
+	}
+
+	keys := v.key(ctx, parsed.Headers[0].KeyID, false)
+	if len(keys) == 0 {
+		//The provider may have rotated its keys since we last looked.
+		if keys = v.key(ctx, parsed.Headers[0].KeyID, true); len(keys) == 0 {
+			return errNoJWKS
+		}
+	}
+	return parsed.Claims(keys[0].Key, claims...)
+}
+
+//key Returns the keys with the given ID, fetching the key set first if it's stale (or refresh is set and it wasn't
+//fetched just now).
+func (v *jwksVerifier) key(ctx context.Context, kid string, refresh bool) []jose.JSONWebKey {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	stale := time.Since(v.fetchedAt) > jwksRefreshInterval
+	if stale || (refresh && v.keys != nil && time.Since(v.fetchedAt) > time.Minute) {
+		v.fetchedAt = time.Now()
+		if keys, err := v.fetch(ctx); err == nil {
+			v.keys = keys
+		} else if stale {
+			v.keys = nil
+		}
+	}
+	if v.keys == nil {
+		return nil
+	}
+	return v.keys.Key(kid)
+}
+
+func (v *jwksVerifier) fetch(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", v.url, nil)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("no key set published at " + v.url)
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
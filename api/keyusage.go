@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//keyUsageFlushInterval How often recorded API key uses are written out. A key used many times in between only costs
+//one write.
+const keyUsageFlushInterval = 30 * time.Second
+
+//keyUsageRecorder Collects when (and where from) API keys are used, and writes them to the DataStore in batches so
+//every request made with a key doesn't turn into a write.
+type keyUsageRecorder struct {
+	data    data.DataStore
+	lock    sync.Mutex
+	pending map[string]data.APIKeyUsage
+}
+
+func newKeyUsageRecorder(db data.DataStore) *keyUsageRecorder {
+	return &keyUsageRecorder{data: db, pending: make(map[string]data.APIKeyUsage)}
+}
+
+//record Notes a use of the key with the given hash, only the latest use per key is kept until the next flush.
+func (k *keyUsageRecorder) record(hash, ip string) {
+	k.lock.Lock()
+	k.pending[hash] = data.APIKeyUsage{Hash: hash, LastUsedAt: common.UnixTimestampInMS(), LastUsedIP: ip}
+	k.lock.Unlock()
+}
+
+//start Flushes recorded uses every keyUsageFlushInterval.
+func (k *keyUsageRecorder) start() {
+	go func() {
+		ticker := time.NewTicker(keyUsageFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			k.flush(context.Background())
+		}
+	}()
+}
+
+//flush Writes out everything recorded since the last flush. If that fails the uses are dropped, they're only
+//informational and the next use of each key records it again.
+func (k *keyUsageRecorder) flush(ctx context.Context) {
+	k.lock.Lock()
+	if len(k.pending) == 0 {
+		k.lock.Unlock()
+		return
+	}
+	usage := make([]data.APIKeyUsage, 0, len(k.pending))
+	for _, use := range k.pending {
+		usage = append(usage, use)
+	}
+	k.pending = make(map[string]data.APIKeyUsage)
+	k.lock.Unlock()
+
+	k.data.RecordAPIKeyUsage(ctx, usage)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/common"
+)
+
+//localProvider Signs users in with the local accounts kept in the DataStore.
+type localProvider struct {
+	accounts *accounts.ServiceAPI
+}
+
+//ExchangeCode There's no sign in flow to hand back a code, local accounts sign in at /api/ash/accounts/login.
+func (l *localProvider) ExchangeCode(ctx context.Context, code string) (common.APIResponse, error) {
+	return common.CreateAPIResponse("failed", errors.New("sign in at /api/ash/accounts/login instead"), 400), nil
+}
+
+//Verify Checks the session token. Sessions are checked against the account on every use so disabling an account or
+//changing its password ends them straight away.
+func (l *localProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	user, expiry, err := l.accounts.VerifySession(ctx, token)
+	if err != nil {
+		return Identity{}, err
+	}
+	identity := Identity{Username: user.Username, Expiry: expiry, NoCache: true}
+	if user.Group == "root" {
+		identity.Scopes = []string{"notebook", "tags", "admin"}
+	} else {
+		identity.Scopes = append([]string{}, userScopes...)
+	}
+	return identity, nil
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	//defaultOIDCUsernameClaim The subject is the only claim every provider keeps stable and unique for a user, names
+	//like "preferred_username" can usually be changed by the user themselves.
+	defaultOIDCUsernameClaim = "sub"
+	defaultOIDCGroupsClaim   = "groups"
+	defaultOIDCScopeClaim    = "scope"
+)
+
+//oidcProvider Signs users in through any OpenID Connect provider. The provider's endpoints and signing keys are
+//discovered from the issuer, tokens are ID tokens (or JWT access tokens) checked against the provider's keys.
+type oidcProvider struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	audience      string
+	usernameClaim string
+	groupsClaim   string
+	scopeClaim    string
+	adminGroups   map[string]bool
+	scopeMap      map[string][]string
+	http          *http.Client
+	lock          sync.Mutex
+	discovery     *oidcDiscovery
+	jwks          *jwksVerifier
+}
+
+//oidcDiscovery The parts of the issuer's /.well-known/openid-configuration we use.
+type oidcDiscovery struct {
+	Issuer        string `json:"issuer"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+//oidcTokens What the token endpoint hands back for an authorization code.
+type oidcTokens struct {
+	IDToken          string `json:"id_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//oidcTokenResponse The response to /api/ash/auth/token. Token is what gets sent as the bearer token from then on.
+type oidcTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+//newOIDCProvider Configured by the "oidc*" config options. Tokens have to be issued to "oidcAudience", which defaults
+//to the client ID. Everyone gets the usual user scopes unless "oidcScopeMap" is set, in which case each value of the
+//"oidcScopeClaim" claim grants the scopes it maps to. Members of any of the "oidcAdminGroups" (found in the
+//"oidcGroupsClaim" claim) are admins. Usernames come from the subject unless "oidcUsernameClaim" says otherwise, which
+//should only name a claim the provider doesn't let users pick themselves.
+func newOIDCProvider() *oidcProvider {
+	config := common.CurrentConfig
+	p := &oidcProvider{
+		issuer:        strings.TrimSuffix(config.OIDCIssuer, "/"),
+		clientID:      config.OIDCClientID,
+		clientSecret:  config.OIDCClientSecret,
+		redirectURL:   config.OIDCRedirectURL,
+		audience:      config.OIDCAudience,
+		usernameClaim: config.OIDCUsernameClaim,
+		groupsClaim:   config.OIDCGroupsClaim,
+		scopeClaim:    config.OIDCScopeClaim,
+		adminGroups:   make(map[string]bool),
+		scopeMap:      config.OIDCScopeMap,
+		http:          &http.Client{Timeout: time.Second * 5},
+	}
+	if p.audience == "" {
+		p.audience = p.clientID
+	}
+	if p.usernameClaim == "" {
+		p.usernameClaim = defaultOIDCUsernameClaim
+	}
+	if p.groupsClaim == "" {
+		p.groupsClaim = defaultOIDCGroupsClaim
+	}
+	if p.scopeClaim == "" {
+		p.scopeClaim = defaultOIDCScopeClaim
+	}
+	for _, group := range config.OIDCAdminGroups {
+		p.adminGroups[group] = true
+	}
+	return p
+}
+
+//discover Fetches the issuer's configuration the first time it's needed. If that fails it's tried again next time.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", p.issuer+"/.well-known/openid-configuration", nil)
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, common.LogError("discover", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, common.LogError("discover", errors.New("issuer didn't return its configuration"))
+	}
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, common.LogError("discover", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer || discovery.JWKSURI == "" || discovery.TokenEndpoint == "" {
+		return nil, common.LogError("discover", errors.New("issuer's configuration doesn't match the configured issuer"))
+	}
+	p.discovery, p.jwks = &discovery, newJWKSVerifier(discovery.JWKSURI)
+	return p.discovery, nil
+}
+
+//ExchangeCode Trades the code for tokens at the issuer's token endpoint, and hands back the ID token once it's been
+//checked.
+func (p *oidcProvider) ExchangeCode(ctx context.Context, code string) (common.APIResponse, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return common.APIResponse{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	req, _ := http.NewRequestWithContext(ctx, "POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return common.APIResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var tokens oidcTokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return common.APIResponse{}, err
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return common.CreateAPIResponse("failed", errors.New(strings.TrimSpace("code exchange failed: "+tokens.Error+" "+tokens.ErrorDescription)), 401), nil
+	}
+	if _, err := p.Verify(ctx, tokens.IDToken); err != nil {
+		return common.CreateAPIResponse("failed", err, 401), nil
+	}
+	return common.CreateAPIRespFromObject(oidcTokenResponse{
+		Token:        tokens.IDToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	}, nil, 200), nil
+}
+
+//Verify Checks the token's signature against the issuer's keys, that the issuer issued it to us and that it hasn't
+//expired, then maps its claims to a username and scopes.
+func (p *oidcProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var claims jwt.Claims
+	var raw map[string]interface{}
+	if err := p.jwks.verify(ctx, token, &claims, &raw); err == errNoJWKS {
+		return Identity{}, errors.New("token wasn't signed by any of the issuer's keys")
+	} else if err != nil {
+		return Identity{}, err
+	}
+	expected := jwt.Expected{Issuer: discovery.Issuer, Audience: jwt.Audience{p.audience}, Time: time.Now()}
+	if err := claims.Validate(expected); err != nil {
+		return Identity{}, err
+	}
+
+	//No falling back to the subject when a configured claim is missing, one user's subject could be another's name.
+	identity := Identity{Username: claimValue(raw, p.usernameClaim)}
+	if identity.Username == "" {
+		return Identity{}, errors.New("token doesn't say who it belongs to")
+	}
+	if claims.Expiry != nil {
+		identity.Expiry = claims.Expiry.Time()
+	}
+
+	if len(p.scopeMap) == 0 {
+		identity.Scopes = append([]string{}, userScopes...)
+	} else {
+		for _, value := range claimValues(raw, p.scopeClaim) {
+			for _, scope := range p.scopeMap[value] {
+				if !common.Contains(identity.Scopes, scope) {
+					identity.Scopes = append(identity.Scopes, scope)
+				}
+			}
+		}
+	}
+	for _, group := range claimValues(raw, p.groupsClaim) {
+		if p.adminGroups[group] && !common.Contains(identity.Scopes, "admin") {
+			identity.Scopes = append(identity.Scopes, "admin")
+		}
+	}
+	return identity, nil
+}
+
+//claimValue Returns the claim if it's a string.
+func claimValue(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+//claimValues Returns the claim's values, whether it's a list or a space separated string (as "scope" usually is).
+func claimValues(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
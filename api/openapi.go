@@ -0,0 +1,279 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+)
+
+const openAPIVersion = "3.0.3"
+
+//jsonSchema A schema object. They're built by reflecting on the registry's types, so a map is simpler than modelling
+//all of JSON Schema.
+type jsonSchema map[string]interface{}
+
+//openAPIDocument The parts of an OpenAPI 3 document we use.
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+//openAPIInfo ...
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+//openAPIComponents ...
+type openAPIComponents struct {
+	Schemas         map[string]jsonSchema `json:"schemas"`
+	SecuritySchemes map[string]jsonSchema `json:"securitySchemes"`
+}
+
+//openAPIOperation Access and Scope say who can call the route, beyond what the bearer security requirement can.
+type openAPIOperation struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary,omitempty"`
+	Tags        []string               `json:"tags"`
+	Parameters  []openAPIParameter     `json:"parameters,omitempty"`
+	RequestBody *openAPIBody           `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIBody `json:"responses"`
+	Security    []map[string][]string  `json:"security"`
+	Access      string                 `json:"x-access"`
+	Scope       string                 `json:"x-scope,omitempty"`
+}
+
+//openAPIParameter ...
+type openAPIParameter struct {
+	Name     string     `json:"name"`
+	In       string     `json:"in"`
+	Required bool       `json:"required"`
+	Schema   jsonSchema `json:"schema"`
+}
+
+//openAPIBody Both a request body and a response. Required only goes with the former, Description is required on the
+//latter.
+type openAPIBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+//openAPIMediaType Payload is the schema of what's inside the APIResponse under /api/ash, where Schema is always the
+//APIResponse itself.
+type openAPIMediaType struct {
+	Schema  jsonSchema `json:"schema,omitempty"`
+	Payload jsonSchema `json:"x-payload,omitempty"`
+}
+
+func (api *Routes) openapi(resp http.ResponseWriter, r *http.Request) {
+	common.WriteJSON(resp, http.StatusOK, api.openAPISpec())
+}
+
+//openAPISpec Describes every route in the registry. Each schema generated from a Go type says which in its x-go-type, so a
+//client can be generated that uses the same types.
+func (api *Routes) openAPISpec() openAPIDocument {
+	schemas := &schemaBuilder{schemas: make(map[string]jsonSchema)}
+	doc := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title: "notebook",
+			Description: "Routes under /api/ash respond with an APIResponse whose response field is the JSON encoded " +
+				"payload (described by x-payload). Routes under /api/v2 respond with the payload itself, and with an " +
+				"APIErrorBody when they fail.",
+			Version: "2",
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas:         schemas.schemas,
+			SecuritySchemes: map[string]jsonSchema{"bearer": {"type": "http", "scheme": "bearer"}},
+		},
+	}
+	envelope := schemas.schemaFor(reflect.TypeOf(common.APIResponse{}))
+	apiError := schemas.schemaFor(reflect.TypeOf(common.APIErrorBody{}))
+
+	for _, route := range api.registry {
+		v2 := strings.HasPrefix(route.Path, common.APIv2Prefix)
+		op := &openAPIOperation{
+			OperationID: route.Name,
+			Summary:     route.Summary,
+			Tags:        []string{"ash"},
+			Responses:   make(map[string]openAPIBody),
+			Security:    []map[string][]string{},
+			Access:      route.Access,
+			Scope:       route.Scope,
+		}
+		if v2 {
+			op.Tags = []string{"v2"}
+		}
+		if route.Access != AccessPublic {
+			op.Security = append(op.Security, map[string][]string{"bearer": {}})
+		}
+
+		segments := strings.Split(route.Path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+				op.Parameters = append(op.Parameters, openAPIParameter{Name: segment[1:], In: "path", Required: true, Schema: jsonSchema{"type": "string"}})
+			}
+		}
+		for _, name := range route.Query {
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: name, In: "query", Schema: jsonSchema{"type": "string"}})
+		}
+		if route.TokenInQuery {
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: "token", In: "query", Schema: jsonSchema{"type": "string"}})
+		}
+		if route.SecondFactor {
+			op.Parameters = append(op.Parameters, openAPIParameter{Name: SecondFactorHeader, In: "header", Schema: jsonSchema{"type": "string"}})
+		}
+
+		if route.Request != nil {
+			contentType := route.RequestType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op.RequestBody = &openAPIBody{Required: true, Content: map[string]openAPIMediaType{
+				contentType: {Schema: schemas.schemaFor(reflect.TypeOf(route.Request))},
+			}}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := openAPIBody{Description: http.StatusText(status)}
+		var payload jsonSchema
+		if route.Response != nil {
+			payload = schemas.schemaFor(reflect.TypeOf(route.Response))
+		}
+		switch {
+		case route.ResponseType != "":
+			success.Content = map[string]openAPIMediaType{route.ResponseType: {Schema: payload}}
+		case v2 && payload != nil:
+			success.Content = map[string]openAPIMediaType{"application/json": {Schema: payload}}
+		case !v2:
+			success.Content = map[string]openAPIMediaType{"application/json": {Schema: envelope, Payload: payload}}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+		if v2 {
+			op.Responses["default"] = openAPIBody{Description: "An error", Content: map[string]openAPIMediaType{"application/json": {Schema: apiError}}}
+		} else {
+			op.Responses["default"] = openAPIBody{Description: "An error", Content: map[string]openAPIMediaType{"application/json": {Schema: envelope}}}
+		}
+
+		pathKey := strings.Join(segments, "/")
+		if doc.Paths[pathKey] == nil {
+			doc.Paths[pathKey] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[pathKey][strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+//schemaBuilder Turns Go types into schemas. Named structs become components, referred to by $ref.
+type schemaBuilder struct {
+	schemas map[string]jsonSchema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *schemaBuilder) schemaFor(t reflect.Type) jsonSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return jsonSchema{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, exists := b.schemas[name]; !exists {
+			//Claimed before the fields are, so a type that refers to itself doesn't recurse forever.
+			b.schemas[name] = jsonSchema{}
+			schema := b.objectSchema(t)
+			schema["x-go-type"] = t.PkgPath() + "." + t.Name()
+			b.schemas[name] = schema
+		}
+		return jsonSchema{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return b.objectSchema(t)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "format": "byte"}
+		}
+		return jsonSchema{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonSchema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return jsonSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	}
+	//interface{} and anything else that could be any JSON value.
+	return jsonSchema{}
+}
+
+//objectSchema Follows encoding/json's rules: unexported fields and ones tagged "-" are left out, embedded structs'
+//fields are promoted, and fields are required unless they're omitempty. Required says what we always send, requests
+//can leave out anything they like.
+func (b *schemaBuilder) objectSchema(t reflect.Type) jsonSchema {
+	properties := make(map[string]jsonSchema)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, options = tag[:comma], tag[comma:]
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				promoted := b.objectSchema(embedded)
+				for key, value := range promoted["properties"].(map[string]jsonSchema) {
+					properties[key] = value
+				}
+				if promotedRequired, ok := promoted["required"].([]string); ok {
+					required = append(required, promotedRequired...)
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := jsonSchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/common"
+)
+
+const (
+	//ProviderTrinity Users sign in through Trinity (default)
+	ProviderTrinity = "trinity"
+	//ProviderOIDC Users sign in through any OpenID Connect provider, see the "oidc*" config options
+	ProviderOIDC = "oidc"
+	//ProviderLocal Users sign in with a username and password, accounts are kept in the DataStore
+	ProviderLocal = "local"
+)
+
+//AuthProvider Who users sign in with. Exchanges the code the sign in flow hands back for a token, and later checks
+//those tokens when they're presented as a bearer token.
+type AuthProvider interface {
+	//ExchangeCode Trades an authorization code for a token, returned as the response to /api/ash/auth/token.
+	ExchangeCode(ctx context.Context, code string) (common.APIResponse, error)
+	//Verify Checks the token is valid and returns who it belongs to.
+	Verify(ctx context.Context, token string) (Identity, error)
+}
+
+//Identity Who a verified token belongs to and what they're allowed to do. Expiry is zero if the token doesn't expire.
+type Identity struct {
+	Username string
+	Scopes   []string
+	Expiry   time.Time
+	//NoCache Set when every use of the token has to be checked, so a change (like disabling the account) takes effect
+	//straight away.
+	NoCache bool
+}
+
+//userScopes What every signed in user gets. Admins get "admin" on top.
+var userScopes = []string{"notebook", "tags", "admin:apikey", "admin:webhook"}
+
+//NewAuthProvider Returns the provider selected by the "authProvider" config option. Anything other than "oidc" or
+//"local" gets Trinity.
+func NewAuthProvider(accountSvc *accounts.ServiceAPI) AuthProvider {
+	switch common.CurrentConfig.AuthProvider {
+	case ProviderOIDC:
+		return newOIDCProvider()
+	case ProviderLocal:
+		return &localProvider{accounts: accountSvc}
+	default:
+		return newTrinityProvider()
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//rateLimitFallbackEntries How many counters are kept in process while the cache can't be reached.
+const rateLimitFallbackEntries = 10000
+
+//defaultRateLimits Applied unless the "rateLimits" config option has an entry for the route. The tight ones are
+//where a guess can be checked: share links, passwords, codes and second factors.
+var defaultRateLimits = map[string]common.RateLimit{
+	"*":                             {PerIP: 600, PerUser: 1200, PerKey: 600},
+	"/api/ash/auth/token":           {PerIP: 30},
+	"/api/ash/accounts/login":       {PerIP: 10},
+	"/api/ash/accounts/password":    {PerIP: 10, PerUser: 5},
+	"/api/ash/sharing/:id":          {PerIP: 20},
+	"/api/ash/sharing/:id/comments": {PerIP: 60},
+	"/api/ash/sharing/comments/:id": {PerIP: 10},
+	"/api/ash/user/2fa/confirm":     {PerIP: 30, PerUser: 5},
+	"/api/ash/user/2fa/disable":     {PerIP: 30, PerUser: 5},
+	"/api/ash/user/apikey/new":      {PerIP: 60, PerUser: 10},
+	"/api/ash/user/apikey/rotate":   {PerIP: 60, PerUser: 10},
+	"/api/ash/user/apikey":          {PerIP: 60, PerUser: 10},
+	"/api/ash/notebook/:nbid/burn":  {PerIP: 60, PerUser: 10, PerKey: 10},
+	"/api/ash/audit/export":         {PerIP: 10, PerUser: 5},
+	"/api/ash/audit/verify":         {PerIP: 10, PerUser: 5},
+}
+
+//newRateLimiter Counts in the cache, falling back to counting in process if the cache is down.
+func newRateLimiter(cache data.CacheService, user *Auth) *common.RateLimiter {
+	limits := make(map[string]common.RateLimit, len(defaultRateLimits))
+	for route, limit := range defaultRateLimits {
+		limits[route] = limit
+	}
+	for route, limit := range common.CurrentConfig.RateLimits {
+		limits[route] = limit
+	}
+	return common.NewRateLimiter(cache, data.NewMemoryCache(rateLimitFallbackEntries), limits, user.RateLimitIdentity)
+}
+
+//RateLimitIdentity Requests made with an API key count against the key, everything else against the user.
+func (u *Auth) RateLimitIdentity(r *http.Request) (string, string) {
+	access, err := u.GetAccessLevelFromToken(r)
+	if err != nil {
+		return "", ""
+	}
+	if access.TokenType == "API" {
+		return common.RateLimitKey, access.KeyID
+	}
+	return common.RateLimitUser, access.Username
+}
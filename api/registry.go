@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go.alargerobot.dev/notebook/common"
+)
+
+//Who can call a route.
+const (
+	//AccessPublic Anyone, no token needed.
+	AccessPublic = "public"
+	//AccessToken Any valid token, a user's or an API key.
+	AccessToken = "token"
+	//AccessUser Only a user's own token, API keys are turned away.
+	AccessUser = "user"
+)
+
+//Route A route in the registry. Everything the OpenAPI document says about the API (and so anything generated from
+//it) comes from these, so a route can't be served without being described.
+type Route struct {
+	//Name The operation's ID, which is the name of the handler.
+	Name    string
+	Method  string
+	Path    string
+	Summary string
+	Access  string
+	//Scope The scope the token needs, if any. Checked by the handler.
+	Scope string
+	//Query The query params the route reads.
+	Query []string
+	//SecondFactor The route wants a second factor code in the X-Second-Factor header if the user has two-factor
+	//authentication turned on.
+	SecondFactor bool
+	//TokenInQuery The token can be given as the "token" query param, for clients (like EventSource) that can't set
+	//headers.
+	TokenInQuery bool
+	//Request A value of the type the body is decoded into, nil if the route doesn't read a body.
+	Request interface{}
+	//RequestType The body's content type, JSON unless set.
+	RequestType string
+	//Response A value of the type the route responds with (wrapped in an APIResponse under /api/ash), nil if there's
+	//nothing to it.
+	Response interface{}
+	//ResponseType The response's content type, when it isn't JSON.
+	ResponseType string
+	//Status What the route responds with when it succeeds, 200 unless set.
+	Status int
+}
+
+//handle Registers handler for the route, behind the validator its Access calls for and the rate limiter, and adds it
+//to the registry. /api/v2 routes only answer route.Method, /api/ash routes answer every method (with a 405 from
+//RequestWrapper for the wrong one) like they always have.
+func (api *Routes) handle(route Route, handler func(http.ResponseWriter, *http.Request)) {
+	validator := common.Nothing
+	switch route.Access {
+	case AccessToken:
+		validator = api.user.AnyTokenProvided
+	case AccessUser:
+		validator = api.user.NotAnAPIKey
+	}
+
+	var wrapped http.Handler
+	v2 := strings.HasPrefix(route.Path, common.APIv2Prefix)
+	if v2 {
+		wrapped = common.JSONRequestWrapper(validator, handler)
+	} else {
+		wrapped = common.RequestWrapper(validator, route.Method, handler)
+	}
+	if route.TokenInQuery {
+		wrapped = api.tokenFromQuery(wrapped)
+	}
+	wrapped = api.limiter.Limit(route.Path, wrapped)
+
+	if v2 {
+		api.router.Add(route.Method, route.Path, wrapped.ServeHTTP)
+	} else {
+		api.router.Handle(route.Path, wrapped)
+	}
+	api.registry = append(api.registry, route)
+}
+
+//Registry Every route, in the order they were registered.
+func (api *Routes) Registry() []Route {
+	return append([]Route{}, api.registry...)
+}
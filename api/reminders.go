@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+func (api *Routes) initReminderRoutes() {
+	api.handle(Route{Name: "reminders", Method: "GET", Path: "/api/ash/reminders", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The user's reminders", Response: []data.Reminder{}}, api.reminders)
+	api.handle(Route{Name: "newreminder", Method: "POST", Path: "/api/ash/reminders/new", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Sets a reminder on a page", Request: data.NewReminderRequest{}, Response: data.Reminder{}}, api.newreminder)
+	api.handle(Route{Name: "snoozereminder", Method: "PUT", Path: "/api/ash/reminders/:id/snooze", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Puts a reminder off", Request: data.SnoozeReminderRequest{}, Response: data.Reminder{}}, api.snoozereminder)
+	api.handle(Route{Name: "cancelreminder", Method: "DELETE", Path: "/api/ash/reminders/:id", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Cancels a reminder"}, api.cancelreminder)
+}
+
+func (api *Routes) reminders(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:read") {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			reminders, err := api.reminderSvc.GetReminders(r.Context(), username)
+			common.WriteResponse(resp, 500, reminders, err)
+		} else {
+			common.WriteFailureResponse(err, resp, "reminders", 400)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "reminders", 401)
+	}
+}
+func (api *Routes) newreminder(resp http.ResponseWriter, r *http.Request) {
+	var request data.NewReminderRequest
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "newreminder", 401)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "newreminder", 400)
+		return
+	}
+	if allowed, err := api.isPageAllowed(r, request.PageID, request.NotebookID); !allowed {
+		api.writeAccessDenied(resp, err, "newreminder")
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		reminder, err := api.reminderSvc.NewReminder(r.Context(), request, username)
+		common.WriteResponse(resp, 400, reminder, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "newreminder", 400)
+	}
+}
+func (api *Routes) snoozereminder(resp http.ResponseWriter, r *http.Request) {
+	var request data.SnoozeReminderRequest
+	if api.user.HasPermission(r, "notebook:write") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "snoozereminder", 401)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "snoozereminder", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		reminder, err := api.reminderSvc.SnoozeReminder(r.Context(), vestigo.Param(r, "id"), username, request)
+		common.WriteResponse(resp, 400, reminder, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "snoozereminder", 400)
+	}
+}
+func (api *Routes) cancelreminder(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:write") {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			common.WriteResponse(resp, 400, nil, api.reminderSvc.CancelReminder(r.Context(), vestigo.Param(r, "id"), username))
+		} else {
+			common.WriteFailureResponse(err, resp, "cancelreminder", 400)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "cancelreminder", 401)
+	}
+}
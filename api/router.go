@@ -1,103 +1,183 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
 	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/audit"
+	"go.alargerobot.dev/notebook/collab"
 	"go.alargerobot.dev/notebook/common"
 	"go.alargerobot.dev/notebook/crypto"
 	"go.alargerobot.dev/notebook/data"
 	"go.alargerobot.dev/notebook/notebook"
+	"go.alargerobot.dev/notebook/reminder"
+	"go.alargerobot.dev/notebook/stream"
+	"go.alargerobot.dev/notebook/webhook"
 )
 
+const (
+	defaultPageListLimit = 50
+	maxPageListLimit     = 200
+
+	defaultAPIKeyGraceHours = 24
+	maxAPIKeyGraceHours     = 24 * 7
+)
+
+//pageForm The parts of the multipart form newpage and editpage read. Metadata is a JSON encoded NewPageRequest.
+type pageForm struct {
+	Metadata data.NewPageRequest `json:"metadata"`
+	Content  string              `json:"content"`
+}
+
 //Routes ...
 type Routes struct {
 	http        *http.Client
 	user        *Auth
-	data        *data.DataStore
+	data        data.DataStore
 	router      *vestigo.Router
 	vaultClient *crypto.VaultKMS
 	notebookSvc *notebook.ServiceAPI
+	reminderSvc *reminder.ServiceAPI
+	webhooks    *webhook.Dispatcher
+	hub         *stream.Hub
+	emitter     notebook.EventEmitter
+	collab      *collab.Manager
+	accounts    *accounts.ServiceAPI
+	auditLog    *audit.Log
+	limiter     *common.RateLimiter
+	registry    []Route
+	gqlCache    *graphqlCache
+	schema      graphql.Schema
 }
 
 //NewAPIRouter ...
-func NewAPIRouter(dataStore *data.DataStore, routes *vestigo.Router, dev bool, vaultClient *crypto.VaultKMS) *Routes {
+func NewAPIRouter(dataStore data.DataStore, routes *vestigo.Router, dev bool, vaultClient *crypto.VaultKMS, reminders *reminder.ServiceAPI, webhooks *webhook.Dispatcher, hub *stream.Hub, cache data.CacheService, auditLog *audit.Log) *Routes {
+	gqlCache := &graphqlCache{cache: cache}
+	events := notebook.Emitters{webhooks, hub, gqlCache}
+	accountSvc := accounts.NewAccountService(dataStore, vaultClient)
 	api := &Routes{
 		router:      routes,
 		data:        dataStore,
 		vaultClient: vaultClient,
-		user:        NewUserService(dataStore, vaultClient),
+		user:        NewUserService(dataStore, vaultClient, cache, accountSvc, auditLog),
+		accounts:    accountSvc,
+		auditLog:    auditLog,
 		http:        &http.Client{Timeout: time.Second * 2},
-		notebookSvc: notebook.NewNBServiceAPI(dataStore, vaultClient),
+		notebookSvc: notebook.NewNBServiceAPI(dataStore, vaultClient, events),
+		reminderSvc: reminders,
+		webhooks:    webhooks,
+		hub:         hub,
+		emitter:     events,
+		gqlCache:    gqlCache,
 	}
+	api.limiter = newRateLimiter(cache, api.user)
+	api.collab = collab.NewManager(api.notebookSvc, events)
+	api.collab.Start()
 	api.InitAPIRoutes()
 	return api
 }
 
 //InitAPIRoutes ...
 func (api *Routes) InitAPIRoutes() {
-	api.router.Handle("/api/ash/auth/token", common.RequestWrapper(common.Nothing, "GET", api.authcode))
+	api.handle(Route{Name: "authcode", Method: "GET", Path: "/api/ash/auth/token", Access: AccessPublic, Query: []string{"code"},
+		Summary: "Trades the code the sign in flow hands back for a token", Response: oidcTokenResponse{}}, api.authcode)
+	api.handle(Route{Name: "health", Method: "GET", Path: "/api/ash/health", Access: AccessPublic,
+		Summary: "The state of each dependency's circuit breaker", Response: map[string]common.BreakerState{}}, api.health)
+	api.handle(Route{Name: "cachestats", Method: "GET", Path: "/api/ash/health/cache", Access: AccessPublic,
+		Summary: "The read-through cache's hit and miss counters", Response: map[string]data.CacheStats{}}, api.cachestats)
+	api.handle(Route{Name: "openapi", Method: "GET", Path: "/api/openapi.json", Access: AccessPublic,
+		Summary: "This document", Response: openAPIDocument{}}, api.openapi)
 
-	api.router.Handle("/api/ash/user/apikeys", common.RequestWrapper(api.user.NotAnAPIKey, "GET", api.apikeys))
-	api.router.Handle("/api/ash/user/apikey/new", common.RequestWrapper(api.user.NotAnAPIKey, "POST", api.newapikey))
-	api.router.Handle("/api/ash/user/apikey", common.RequestWrapper(api.user.NotAnAPIKey, "DELETE", api.deleteapikey))
+	api.handle(Route{Name: "apikeys", Method: "GET", Path: "/api/ash/user/apikeys", Access: AccessUser, Scope: "admin:apikey",
+		Summary: "The user's API keys", Response: []data.UserAPIKey{}}, api.apikeys)
+	api.handle(Route{Name: "newapikey", Method: "POST", Path: "/api/ash/user/apikey/new", Access: AccessUser, Scope: "admin:apikey", SecondFactor: true,
+		Summary: "Creates an API key, the key itself is only ever returned here", Request: data.NewAPIKeyRequest{}, Response: ""}, api.audited("apikey.create", api.newapikey))
+	api.handle(Route{Name: "deleteapikey", Method: "DELETE", Path: "/api/ash/user/apikey", Access: AccessUser, Scope: "admin:apikey", SecondFactor: true,
+		Summary: "Deletes one of the user's API keys", Request: data.DeleteAPIKeyRequest{}}, api.audited("apikey.delete", api.deleteapikey))
+	api.handle(Route{Name: "rotateapikey", Method: "POST", Path: "/api/ash/user/apikey/rotate", Access: AccessUser, Scope: "admin:apikey", SecondFactor: true,
+		Summary: "Replaces one of the user's API keys, the old one keeps working for the grace period", Request: data.RotateAPIKeyRequest{}, Response: ""}, api.audited("apikey.rotate", api.rotateapikey))
 
-	api.router.Handle("/api/ash/notebooks", common.RequestWrapper(api.user.AnyTokenProvided, "GET", api.notebooks))
+	api.handle(Route{Name: "notebooks", Method: "GET", Path: "/api/ash/notebooks", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The user's notebooks", Response: []data.NotebookReference{}}, api.notebooks)
 
-	api.router.Handle("/api/ash/notebook/new", common.RequestWrapper(api.user.AnyTokenProvided, "POST", api.newnotebook))
-	api.router.Handle("/api/ash/notebook/:nbid", common.RequestWrapper(api.user.AnyTokenProvided, "GET", api.pages))
-	api.router.Handle("/api/ash/notebook/:nbid/burn", common.RequestWrapper(api.user.AnyTokenProvided, "DELETE", api.deletenotebook))
-	api.router.Handle("/api/ash/notebook/page", common.RequestWrapper(api.user.AnyTokenProvided, "POST", api.newpage))
-	api.router.Handle("/api/ash/notebook/:nbid/page/:id", common.RequestWrapper(api.user.AnyTokenProvided, "GET", api.pagemetadata))
-	api.router.Handle("/api/ash/notebook/:nbid/ripout/:id", common.RequestWrapper(api.user.AnyTokenProvided, "DELETE", api.ripout))
-	api.router.Handle("/api/ash/notebook/:nbid/pagecontent/:id", common.RequestWrapper(api.user.AnyTokenProvided, "GET", api.page))
-	api.router.Handle("/api/ash/notebook/:nbid/withtags", common.RequestWrapper(api.user.AnyTokenProvided, "POST", api.setfilter))
-	api.router.Handle("/api/ash/notebook/editpage", common.RequestWrapper(api.user.AnyTokenProvided, "POST", api.editpage))
+	api.handle(Route{Name: "newnotebook", Method: "POST", Path: "/api/ash/notebook/new", Access: AccessToken, Scope: "notebook:create",
+		Summary: "Creates a notebook, the body is its name", Request: "", RequestType: "text/plain", Response: data.NotebookReference{}}, api.audited("notebook.create", api.newnotebook))
+	api.handle(Route{Name: "pages", Method: "GET", Path: "/api/ash/notebook/:nbid", Access: AccessToken, Scope: "notebook:read", Query: []string{"sort", "order", "limit", "cursor"},
+		Summary: "The notebook's pages, a PageList when limit or cursor is given", Response: []data.Page{}}, api.pages)
+	api.handle(Route{Name: "deletenotebook", Method: "DELETE", Path: "/api/ash/notebook/:nbid/burn", Access: AccessToken, Scope: "notebook:delete", SecondFactor: true,
+		Summary: "Deletes the notebook and everything in it"}, api.audited("notebook.delete", api.deletenotebook, "nbid"))
+	api.handle(Route{Name: "newpage", Method: "POST", Path: "/api/ash/notebook/page", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Creates a page, the metadata part is a NewPageRequest and the content part is the page's content", Request: pageForm{}, RequestType: "multipart/form-data", Response: data.Page{}}, api.audited("page.create", api.newpage))
+	api.handle(Route{Name: "pagemetadata", Method: "GET", Path: "/api/ash/notebook/:nbid/page/:id", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The page's metadata", Response: data.Page{}}, api.pagemetadata)
+	api.handle(Route{Name: "ripout", Method: "DELETE", Path: "/api/ash/notebook/:nbid/ripout/:id", Access: AccessToken, Scope: "notebook:delete",
+		Summary: "Deletes the page"}, api.audited("page.delete", api.ripout, "nbid", "id"))
+	api.handle(Route{Name: "page", Method: "GET", Path: "/api/ash/notebook/:nbid/pagecontent/:id", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The page's content", Response: ""}, api.audited("page.read", api.page, "nbid", "id"))
+	api.handle(Route{Name: "setfilter", Method: "POST", Path: "/api/ash/notebook/:nbid/withtags", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The notebook's pages with all of the given tags", Request: []string{}, Response: []data.Page{}}, api.setfilter)
+	api.handle(Route{Name: "editpage", Method: "POST", Path: "/api/ash/notebook/editpage", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Updates a page, its content is only replaced if the content part isn't empty", Request: pageForm{}, RequestType: "multipart/form-data", Response: ""}, api.audited("page.edit", api.editpage))
 
-	api.router.Handle("/api/ash/tags", common.RequestWrapper(api.user.AnyTokenProvided, "GET", api.gettags))
-	api.router.Handle("/api/ash/tags/new", common.RequestWrapper(api.user.AnyTokenProvided, "POST", api.newtag))
-	api.router.Handle("/api/ash/tags/delete/:id", common.RequestWrapper(api.user.AnyTokenProvided, "DELETE", api.deletetag))
+	api.handle(Route{Name: "gettags", Method: "GET", Path: "/api/ash/tags", Access: AccessToken, Scope: "tags",
+		Summary: "Every tag", Response: []data.PageTag{}}, api.gettags)
+	api.handle(Route{Name: "newtag", Method: "POST", Path: "/api/ash/tags/new", Access: AccessToken, Scope: "tags",
+		Summary: "Creates a tag, the body is its value", Request: "", RequestType: "text/plain", Response: data.PageTag{}}, api.newtag)
+	api.handle(Route{Name: "deletetag", Method: "DELETE", Path: "/api/ash/tags/delete/:id", Access: AccessToken, Scope: "tags",
+		Summary: "Deletes the tag"}, api.deletetag)
 
-	api.router.Handle("/api/ash/sharing/share", common.RequestWrapper(api.user.NotAnAPIKey, "POST", api.sharepage))
-	api.router.Handle("/api/ash/sharing/unshare/:id", common.RequestWrapper(api.user.NotAnAPIKey, "DELETE", api.unsharepage))
-	api.router.Handle("/api/ash/sharing/:id", common.RequestWrapper(common.Nothing, "GET", api.getsharedpage))
-	api.router.Handle("/api/ash/sharing/allshared", common.RequestWrapper(api.user.NotAnAPIKey, "GET", api.getsharedpages))
+	api.handle(Route{Name: "sharepage", Method: "POST", Path: "/api/ash/sharing/share", Access: AccessUser,
+		Summary: "Shares a page, responds with the share link's token", Request: data.SharePageRequest{}, Response: ""}, api.audited("page.share", api.sharepage))
+	api.handle(Route{Name: "unsharepage", Method: "DELETE", Path: "/api/ash/sharing/unshare/:id", Access: AccessUser,
+		Summary: "Deletes a share link", Response: false}, api.audited("page.unshare", api.unsharepage, "id"))
+	api.handle(Route{Name: "getsharedpage", Method: "GET", Path: "/api/ash/sharing/:id", Access: AccessPublic,
+		Summary: "The page a share link points at", Response: data.SharedPageContent{}}, api.audited("sharedpage.read", api.getsharedpage, "id"))
+	api.handle(Route{Name: "getsharedpages", Method: "GET", Path: "/api/ash/sharing/allshared", Access: AccessUser,
+		Summary: "The user's share links", Response: []data.SharedPage{}}, api.getsharedpages)
 
+	api.initReminderRoutes()
+	api.initWebhookRoutes()
+	api.initStreamRoutes()
+	api.initCollabRoutes()
+	api.initAccountRoutes()
+	api.initTwoFactorRoutes()
+	api.initCommentRoutes()
+	api.initAuditRoutes()
+	api.initV2Routes()
+	api.initGraphQLRoutes()
 }
 
-func (api *Routes) authcode(resp http.ResponseWriter, r *http.Request) {
-	var serviceResp common.APIResponse
-
-	code := r.URL.Query().Get("code")
-	req, _ := http.NewRequest("GET", common.BaseAPIURL+"/trinity/token", nil)
+//health Reports the state of each dependency's circuit breaker, with a 503 if any of them is open.
+func (api *Routes) health(resp http.ResponseWriter, r *http.Request) {
+	states := common.BreakerStates()
+	health := common.CreateAPIRespFromObject(states, nil, 0)
+	for _, state := range states {
+		if state == common.BreakerOpen {
+			health.Status, health.HttpStatusCode = "failed", http.StatusServiceUnavailable
+		}
+	}
+	common.WriteAPIResponseStruct(resp, health)
+}
 
-	q := req.URL.Query()
-	q.Set("sid", common.CurrentConfig.TrinitySID)
-	q.Set("skey", common.CurrentConfig.TrinitySKey)
-	q.Set("code", code)
-	req.URL.RawQuery = q.Encode()
-	if httpResp, err := api.http.Do(req); err == nil {
-		if body, err := ioutil.ReadAll(httpResp.Body); err != nil {
-			common.WriteFailureResponse(err, resp, "authcode", 500)
-		} else {
-			if e := json.Unmarshal(body, &serviceResp); e != nil {
-				common.WriteFailureResponse(e, resp, "authcode", 500)
-				return
-			}
-			if serviceResp.Status == "failed" {
-				serviceResp.HttpStatusCode = 500
-			} else {
-				serviceResp.HttpStatusCode = 200
-			}
-			common.WriteAPIResponseStruct(resp, serviceResp)
+//cachestats Reports the read-through cache's hit/miss counters, for tuning.
+func (api *Routes) cachestats(resp http.ResponseWriter, r *http.Request) {
+	common.WriteAPIResponseStruct(resp, common.CreateAPIRespFromObject(data.ReadCacheStats(), nil, 0))
+}
 
-		}
+func (api *Routes) authcode(resp http.ResponseWriter, r *http.Request) {
+	if serviceResp, err := api.user.provider.ExchangeCode(r.Context(), r.URL.Query().Get("code")); err == nil {
+		common.WriteAPIResponseStruct(resp, serviceResp)
 	} else {
 		common.WriteFailureResponse(err, resp, "authcode", 500)
 	}
@@ -105,7 +185,7 @@ func (api *Routes) authcode(resp http.ResponseWriter, r *http.Request) {
 func (api *Routes) apikeys(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "admin:apikey") {
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
-			keys, err := api.data.GetAPIKeys(username)
+			keys, err := api.data.GetAPIKeys(r.Context(), username)
 			if err != nil {
 				common.WriteFailureResponse(err, resp, "apikeys", 400)
 			} else {
@@ -131,15 +211,35 @@ func (api *Routes) newapikey(resp http.ResponseWriter, r *http.Request) {
 			common.WriteResponse(resp, 400, nil, errors.New("An api key with 0 scopes is useless"))
 			return
 		}
+		for _, scope := range keyDetails.Scopes {
+			if !api.user.knownScopes[scope] {
+				common.WriteResponse(resp, 400, nil, errors.New("unknown scope "+scope))
+				return
+			}
+		}
 
 		if keyDetails.Description == "" {
 			common.WriteResponse(resp, 400, nil, errors.New("A description is required."))
 			return
 		}
 
+		if expiresAt, err := apiKeyExpiry(keyDetails.ExpiresInDays); err == nil {
+			keyDetails.ExpiresAt = expiresAt
+		} else {
+			common.WriteResponse(resp, 400, nil, err)
+			return
+		}
+
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			if !api.secondFactor(resp, r, username, "newapikey") {
+				return
+			}
+			if err := api.checkKeyConstraints(r.Context(), keyDetails.Constraints, username); err != nil {
+				common.WriteResponse(resp, 400, nil, err)
+				return
+			}
 			keyDetails.Creator = username
-			if newKeyResp, err := api.data.NewAPIKey(keyDetails); err == nil {
+			if newKeyResp, err := api.data.NewAPIKey(r.Context(), keyDetails); err == nil {
 				common.WriteResponse(resp, 500, newKeyResp, err)
 			} else {
 				common.WriteFailureResponse(err, resp, "newapikey", 500)
@@ -159,11 +259,12 @@ func (api *Routes) deleteapikey(resp http.ResponseWriter, r *http.Request) {
 			common.WriteFailureResponse(err, resp, "deleteapikey", 500)
 			return
 		}
+		auditResources(r, delReq.ID)
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
 			if username != string(delReq.Creator) {
 				common.WriteFailureResponse(errors.New("forbidden"), resp, "deleteapikey", 403)
-			} else {
-				common.WriteResponse(resp, 400, nil, api.data.DeleteAPIKey(delReq.ID))
+			} else if api.secondFactor(resp, r, username, "deleteapikey") {
+				common.WriteResponse(resp, 400, nil, api.data.DeleteAPIKey(r.Context(), delReq.ID))
 			}
 		} else {
 			common.WriteFailureResponse(err, resp, "deleteapikey", 500)
@@ -172,10 +273,94 @@ func (api *Routes) deleteapikey(resp http.ResponseWriter, r *http.Request) {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "deleteapikey", 401)
 	}
 }
+//rotateapikey Issues a replacement for one of the user's keys. The old key keeps working for the grace period, so
+//whatever uses it can be moved over to the new one.
+func (api *Routes) rotateapikey(resp http.ResponseWriter, r *http.Request) {
+	var rotateReq data.RotateAPIKeyRequest
+	if api.user.HasPermission(r, "admin:apikey") {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &rotateReq); err != nil {
+			common.WriteFailureResponse(err, resp, "rotateapikey", 400)
+			return
+		}
+		auditResources(r, rotateReq.ID)
+		if rotateReq.GraceHours < 0 || rotateReq.GraceHours > maxAPIKeyGraceHours {
+			common.WriteResponse(resp, 400, nil, fmt.Errorf("the grace period has to be between 0 and %d hours", maxAPIKeyGraceHours))
+			return
+		} else if rotateReq.GraceHours == 0 {
+			rotateReq.GraceHours = defaultAPIKeyGraceHours
+		}
+		expiresAt, err := apiKeyExpiry(rotateReq.ExpiresInDays)
+		if err != nil {
+			common.WriteResponse(resp, 400, nil, err)
+			return
+		}
+
+		if username, err := api.user.GetUsernameFromToken(r); err != nil {
+			common.WriteFailureResponse(err, resp, "rotateapikey", 500)
+		} else if api.secondFactor(resp, r, username, "rotateapikey") {
+			graceUntil := time.Now().Add(time.Duration(rotateReq.GraceHours)*time.Hour).UnixNano() / int64(time.Millisecond)
+			newKey, err := api.data.RotateAPIKey(r.Context(), rotateReq.ID, username, data.NewAPIKeyRequest{ExpiresAt: expiresAt}, graceUntil)
+			common.WriteResponse(resp, 400, newKey, err)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "rotateapikey", 401)
+	}
+}
+
+//checkKeyConstraints Keys can only be limited to notebooks and tags that belong to whoever's creating them.
+func (api *Routes) checkKeyConstraints(ctx context.Context, constraints data.APIKeyConstraints, username string) error {
+	if len(constraints.Notebooks) > 0 {
+		refs, err := api.notebookSvc.GetNotebooks(ctx, username)
+		if err != nil {
+			return err
+		}
+		owned := make(map[string]bool, len(refs))
+		for _, ref := range refs {
+			owned[ref.ID] = true
+		}
+		for _, id := range constraints.Notebooks {
+			if !owned[id] {
+				return errors.New("unknown notebook " + id)
+			}
+		}
+	}
+	if len(constraints.Tags) > 0 {
+		if valid, err := api.data.IsValidTagID(ctx, constraints.Tags, username); err != nil {
+			return err
+		} else if !valid {
+			return errors.New("one or more of the specified tags is invalid")
+		}
+	}
+	return nil
+}
+
+//apiKeyExpiry When a key that lasts days should expire, 0 if it never should. With the "apiKeyMaxDays" config option
+//set every key has to expire, within that many days (which is also the default).
+func apiKeyExpiry(days int) (int64, error) {
+	maxDays := common.CurrentConfig.APIKeyMaxDays
+	if days < 0 || (maxDays > 0 && days > maxDays) {
+		return 0, fmt.Errorf("api keys can last at most %d days", maxDays)
+	} else if days == 0 && maxDays > 0 {
+		days = maxDays
+	} else if days == 0 {
+		return 0, nil
+	}
+	return time.Now().AddDate(0, 0, days).UnixNano() / int64(time.Millisecond), nil
+}
 func (api *Routes) notebooks(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "notebook:read") {
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
-			names, err := api.notebookSvc.GetNotebooks(username)
+			names, err := api.notebookSvc.GetNotebooks(r.Context(), username)
+			if constraints := api.user.GetConstraints(r); len(constraints.Notebooks) > 0 {
+				allowed := []data.NotebookReference{}
+				for _, ref := range names {
+					if constraints.AllowsNotebook(ref.ID) {
+						allowed = append(allowed, ref)
+					}
+				}
+				names = allowed
+			}
 			common.WriteResponse(resp, 400, names, err)
 		} else {
 			common.WriteFailureResponse(err, resp, "notebooks", 500)
@@ -185,7 +370,7 @@ func (api *Routes) notebooks(resp http.ResponseWriter, r *http.Request) {
 	}
 }
 func (api *Routes) newnotebook(resp http.ResponseWriter, r *http.Request) {
-	if api.user.HasPermission(r, "notebook:create") {
+	if api.user.HasPermission(r, "notebook:create") && len(api.user.GetConstraints(r).Notebooks) == 0 {
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
 			notebookName, err := ioutil.ReadAll(r.Body)
 			if err != nil {
@@ -195,12 +380,13 @@ func (api *Routes) newnotebook(resp http.ResponseWriter, r *http.Request) {
 			if string(notebookName) == "" {
 				common.WriteFailureResponse(errors.New("you can't have a nameless notebook"), resp, "newnotebook", 400)
 			} else {
-				ref, err := api.notebookSvc.NewNotebook(data.Notebook{
+				ref, err := api.notebookSvc.NewNotebook(r.Context(), data.Notebook{
 					Name:  string(notebookName),
 					ID:    strings.TrimSpace(uuid.New().String()),
 					Owner: strings.TrimSpace(username),
 					Pages: []data.Page{},
 				})
+				auditResources(r, ref.ID)
 				common.WriteResponse(resp, 400, ref, err)
 			}
 		} else {
@@ -212,21 +398,35 @@ func (api *Routes) newnotebook(resp http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Routes) pages(resp http.ResponseWriter, r *http.Request) {
-	if api.user.HasPermission(r, "notebook:read") {
+	if api.user.HasPermission(r, "notebook:read") && api.user.NotebookAllowed(r, vestigo.Param(r, "nbid")) {
 		username, err := api.user.GetUsernameFromToken(r)
 		if err != nil {
 			common.WriteResponse(resp, 400, nil, err)
 		} else {
-			pages, err := api.notebookSvc.GetPages(vestigo.Param(r, "nbid"), username)
-			common.WriteResponse(resp, 400, pages, err)
+			query, paged, err := pageQueryFromRequest(r)
+			if err != nil {
+				common.WriteResponse(resp, 400, nil, err)
+				return
+			}
+			pages, err := api.notebookSvc.GetPages(r.Context(), vestigo.Param(r, "nbid"), username, query)
+			pages.Pages = api.allowedPages(r, pages.Pages)
+			if paged {
+				common.WriteResponse(resp, 400, pages, err)
+			} else {
+				common.WriteResponse(resp, 400, pages.Pages, err)
+			}
 		}
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "pages", 401)
 	}
 }
 func (api *Routes) deletenotebook(resp http.ResponseWriter, r *http.Request) {
-	if api.user.HasPermission(r, "notebook:delete") {
-		common.WriteResponse(resp, 400, nil, api.notebookSvc.DeleteNotebook(vestigo.Param(r, "nbid")))
+	if api.user.HasPermission(r, "notebook:delete") && api.user.NotebookAllowed(r, vestigo.Param(r, "nbid")) {
+		if username, err := api.user.GetUsernameFromToken(r); err != nil {
+			common.WriteFailureResponse(err, resp, "deletenotebook", 400)
+		} else if api.secondFactor(resp, r, username, "deletenotebook") {
+			common.WriteResponse(resp, 400, nil, api.notebookSvc.DeleteNotebook(r.Context(), vestigo.Param(r, "nbid"), username))
+		}
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "deletenotebook", 401)
 	}
@@ -246,6 +446,10 @@ func (api *Routes) newpage(resp http.ResponseWriter, r *http.Request) {
 				return
 			}
 			newPage.Content = pageContent
+			if !api.user.NotebookAllowed(r, newPage.NotebookID) {
+				common.WriteFailureResponse(errors.New("not authorized"), resp, "newpage", 401)
+				return
+			}
 		} else {
 			common.WriteFailureResponse(err, resp, "newpage", 500)
 			return
@@ -254,8 +458,9 @@ func (api *Routes) newpage(resp http.ResponseWriter, r *http.Request) {
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
 			newPage.Metadata.Creator = username
 			newPage.Metadata.ID = uuid.New().String()
+			auditResources(r, newPage.NotebookID, newPage.Metadata.ID)
 			newPage.Metadata.LastEdited = common.UnixTimestampInMS()
-			common.WriteResponse(resp, 400, newPage.Metadata, api.notebookSvc.NewPage(newPage))
+			common.WriteResponse(resp, 400, newPage.Metadata, api.notebookSvc.NewPage(r.Context(), newPage))
 		} else {
 			common.WriteFailureResponse(err, resp, "newpage", 400)
 		}
@@ -266,8 +471,8 @@ func (api *Routes) newpage(resp http.ResponseWriter, r *http.Request) {
 }
 func (api *Routes) pagemetadata(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "notebook:read") {
-		if allowed, err := api.isAccessAllowed(r, vestigo.Param(r, "id")); allowed {
-			page, err := api.notebookSvc.GetPageMetadata(vestigo.Param(r, "id"), vestigo.Param(r, "nbid"))
+		if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); allowed {
+			page, err := api.notebookSvc.GetPageMetadata(r.Context(), vestigo.Param(r, "id"), vestigo.Param(r, "nbid"))
 			common.WriteResponse(resp, 400, page, err)
 		} else {
 			if err != nil {
@@ -281,16 +486,20 @@ func (api *Routes) pagemetadata(resp http.ResponseWriter, r *http.Request) {
 	}
 }
 func (api *Routes) ripout(resp http.ResponseWriter, r *http.Request) {
-	if api.user.HasPermission(r, "notebook:delete") {
-		common.WriteResponse(resp, 400, nil, api.notebookSvc.DeletePage(vestigo.Param(r, "id"), vestigo.Param(r, "nbid")))
+	if api.user.HasPermission(r, "notebook:delete") && api.user.NotebookAllowed(r, vestigo.Param(r, "nbid")) {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			common.WriteResponse(resp, 400, nil, api.notebookSvc.DeletePage(r.Context(), vestigo.Param(r, "id"), vestigo.Param(r, "nbid"), username))
+		} else {
+			common.WriteFailureResponse(err, resp, "ripout", 400)
+		}
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "ripout", 401)
 	}
 }
 func (api *Routes) page(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "notebook:read") {
-		if allowed, err := api.isAccessAllowed(r, vestigo.Param(r, "id")); allowed {
-			page, err := api.notebookSvc.ReadPage(vestigo.Param(r, "id"), vestigo.Param(r, "nbid"))
+		if allowed, err := api.isPageAllowed(r, vestigo.Param(r, "id"), vestigo.Param(r, "nbid")); allowed {
+			page, err := api.notebookSvc.ReadPage(r.Context(), vestigo.Param(r, "id"), vestigo.Param(r, "nbid"))
 			common.WriteResponse(resp, 400, page, err)
 		} else {
 			if err != nil {
@@ -305,14 +514,14 @@ func (api *Routes) page(resp http.ResponseWriter, r *http.Request) {
 }
 func (api *Routes) setfilter(resp http.ResponseWriter, r *http.Request) {
 	var tagList []string
-	if api.user.HasPermission(r, "notebook:read") {
+	if api.user.HasPermission(r, "notebook:read") && api.user.NotebookAllowed(r, vestigo.Param(r, "nbid")) {
 		body, _ := ioutil.ReadAll(r.Body)
 		if err := json.Unmarshal(body, &tagList); err != nil {
 			common.WriteFailureResponse(err, resp, "newpage", 500)
 			return
 		}
-		pages, err := api.data.GetPagesWithTags(tagList, vestigo.Param(r, "nbid"))
-		common.WriteResponse(resp, 500, pages, err)
+		pages, err := api.data.GetPagesWithTags(r.Context(), tagList, vestigo.Param(r, "nbid"))
+		common.WriteResponse(resp, 500, api.allowedPages(r, pages), err)
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "pagemetadata", 401)
 	}
@@ -334,7 +543,8 @@ func (api *Routes) editpage(resp http.ResponseWriter, r *http.Request) {
 	}
 	var pageMD data.NewPageRequest
 	json.Unmarshal([]byte(meta), &pageMD)
-	if allowed, err := api.isAccessAllowed(r, pageMD.Metadata.ID); !allowed {
+	auditResources(r, pageMD.NotebookID, pageMD.Metadata.ID)
+	if allowed, err := api.isPageAllowed(r, pageMD.Metadata.ID, pageMD.NotebookID); !allowed {
 		if err != nil {
 			common.WriteFailureResponse(err, resp, "editpage", 500)
 			return
@@ -346,15 +556,11 @@ func (api *Routes) editpage(resp http.ResponseWriter, r *http.Request) {
 
 	if username, err := api.user.GetUsernameFromToken(r); err == nil {
 		pageMD.Metadata.Creator = username
-		if err := api.notebookSvc.EditPageMD(pageMD); err != nil {
+		if err := api.notebookSvc.EditPage(r.Context(), pageMD, content); err != nil {
 			common.WriteFailureResponse(err, resp, "editpage", 500)
 			return
 		}
-		if content != "" {
-			common.WriteResponse(resp, 400, nil, api.notebookSvc.EditPageContent(content, pageMD.Metadata.ID, pageMD.NotebookID))
-		} else {
-			common.WriteResponse(resp, 400, "success", nil)
-		}
+		common.WriteResponse(resp, 400, "success", nil)
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "editpage", 401)
 		return
@@ -362,7 +568,7 @@ func (api *Routes) editpage(resp http.ResponseWriter, r *http.Request) {
 }
 func (api *Routes) gettags(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "tags") {
-		tags, err := api.data.GetTags()
+		tags, err := api.data.GetTags(r.Context())
 		common.WriteResponse(resp, 400, tags, err)
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "newtag", 401)
@@ -372,7 +578,10 @@ func (api *Routes) newtag(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "tags") {
 		body, _ := ioutil.ReadAll(r.Body)
 		if username, err := api.user.GetUsernameFromToken(r); err == nil {
-			newTag, err := api.data.NewTag(data.PageTag{TagID: uuid.New().String(), TagValue: string(body), Creator: username})
+			newTag, err := api.data.NewTag(r.Context(), data.PageTag{TagID: uuid.New().String(), TagValue: string(body), Creator: username})
+			if err == nil {
+				api.gqlCache.invalidate(graphqlEveryone)
+			}
 			common.WriteResponse(resp, 400, newTag, err)
 		} else {
 			common.WriteFailureResponse(err, resp, "newpage", 400)
@@ -383,7 +592,11 @@ func (api *Routes) newtag(resp http.ResponseWriter, r *http.Request) {
 }
 func (api *Routes) deletetag(resp http.ResponseWriter, r *http.Request) {
 	if api.user.HasPermission(r, "tags") {
-		common.WriteResponse(resp, 400, nil, api.data.DeleteTag(vestigo.Param(r, "id")))
+		err := api.data.DeleteTag(r.Context(), vestigo.Param(r, "id"))
+		if err == nil {
+			api.gqlCache.invalidate(graphqlEveryone)
+		}
+		common.WriteResponse(resp, 400, nil, err)
 	} else {
 		common.WriteFailureResponse(errors.New("not authorized"), resp, "newtag", 401)
 	}
@@ -393,13 +606,17 @@ func (api *Routes) sharepage(resp http.ResponseWriter, r *http.Request) {
 	if username, err := api.user.GetUsernameFromToken(r); err == nil {
 		body, _ := ioutil.ReadAll(r.Body)
 		if err := json.Unmarshal(body, &request); err == nil {
+			auditResources(r, request.NotebookID, request.PageID)
 			if request.PageTitle == "" {
 				common.WriteResponse(resp, 400, nil, common.LogError("", errors.New("this page definitely has a title, what is it?")))
 				return
 			}
-			if creator, err := api.data.GetPageCreator(request.PageID); err == nil {
+			if creator, err := api.data.GetPageCreator(r.Context(), request.PageID); err == nil {
 				if creator == username {
-					spmd, err := api.data.NewSharedPage(request, username)
+					spmd, err := api.data.NewSharedPage(r.Context(), request, username)
+					if err == nil {
+						api.emitter.Emit(username, notebook.EventPageShared, spmd)
+					}
 					common.WriteResponse(resp, 400, spmd.AccessToken, err)
 				} else {
 					common.WriteResponse(resp, 401, nil, common.LogError("", errors.New("not authorized")))
@@ -420,26 +637,30 @@ func (api *Routes) unsharepage(resp http.ResponseWriter, r *http.Request) {
 		common.WriteResponse(resp, 400, nil, err)
 		return
 	}
-	result, err := api.data.DeleteSharedPage(vestigo.Param(r, "id"), username)
+	result, err := api.data.DeleteSharedPage(r.Context(), vestigo.Param(r, "id"), username)
+	if result {
+		api.emitter.Emit(username, notebook.EventPageUnshared, map[string]string{"id": vestigo.Param(r, "id")})
+	}
 	common.WriteResponse(resp, 400, result, err)
 }
 func (api *Routes) getsharedpage(resp http.ResponseWriter, r *http.Request) {
-	var pageResp = make(map[string]interface{}, 1)
 	pageToken := vestigo.Param(r, "id")
 	if pageToken == "" {
 		common.WriteResponse(resp, 400, nil, errors.New("page token not specified"))
 	} else {
-		if sharedPageMD, err := api.data.GetSharedPageInfo(pageToken); err == nil {
-			if pageContent, err := api.notebookSvc.ReadPage(sharedPageMD.PageID, sharedPageMD.NotebookID); err == nil {
-				pageMD, err := api.data.GetPageByID(sharedPageMD.PageID, sharedPageMD.NotebookID)
+		if sharedPageMD, err := api.data.GetSharedPageInfo(r.Context(), pageToken); err == nil {
+			if pageContent, err := api.notebookSvc.ReadPage(r.Context(), sharedPageMD.PageID, sharedPageMD.NotebookID); err == nil {
+				pageMD, err := api.data.GetPageByID(r.Context(), sharedPageMD.PageID, sharedPageMD.NotebookID)
 				if err != nil {
 					common.WriteResponse(resp, 400, nil, err)
 					return
 				}
-				pageResp["title"] = pageMD.Title
-				pageResp["lastEdit"] = pageMD.LastEdited
-				pageResp["content"] = pageContent
-				common.WriteResponse(resp, 400, pageResp, nil)
+				common.WriteResponse(resp, 400, data.SharedPageContent{
+					Title:         pageMD.Title,
+					LastEdit:      pageMD.LastEdited,
+					Content:       pageContent,
+					AllowComments: sharedPageMD.AllowComments,
+				}, nil)
 			} else {
 				common.WriteResponse(resp, 500, nil, err)
 			}
@@ -454,7 +675,7 @@ func (api *Routes) getsharedpages(resp http.ResponseWriter, r *http.Request) {
 		common.WriteResponse(resp, 400, nil, err)
 		return
 	}
-	pages, err := api.data.GetSharedPages(username)
+	pages, err := api.data.GetSharedPages(r.Context(), username)
 	if err != nil {
 		common.WriteResponse(resp, 400, nil, err)
 		return
@@ -463,7 +684,7 @@ func (api *Routes) getsharedpages(resp http.ResponseWriter, r *http.Request) {
 }
 func (api *Routes) isAccessAllowed(r *http.Request, pageID string) (bool, error) {
 	if username, err := api.user.GetUsernameFromToken(r); err == nil {
-		if creator, err := api.data.GetPageCreator(pageID); err == nil {
+		if creator, err := api.data.GetPageCreator(r.Context(), pageID); err == nil {
 			if creator == username {
 				return true, nil
 			} else {
@@ -478,3 +699,54 @@ func (api *Routes) isAccessAllowed(r *http.Request, pageID string) (bool, error)
 		return false, err
 	}
 }
+
+//isPageAllowed isAccessAllowed, plus the constraints of the request's key (if it was made with one). The page's tags are
+//only looked up if the key is limited to tagged pages.
+func (api *Routes) isPageAllowed(r *http.Request, pageID, notebookID string) (bool, error) {
+	if allowed, err := api.isAccessAllowed(r, pageID); !allowed || err != nil {
+		return allowed, err
+	}
+	constraints := api.user.GetConstraints(r)
+	if !constraints.AllowsNotebook(notebookID) {
+		return false, nil
+	} else if len(constraints.Tags) == 0 {
+		return true, nil
+	}
+	page, err := api.notebookSvc.GetPageMetadata(r.Context(), pageID, notebookID)
+	if err != nil {
+		return false, err
+	}
+	return constraints.AllowsPage(page), nil
+}
+
+//allowedPages Drops the pages the request's key isn't allowed to see.
+func (api *Routes) allowedPages(r *http.Request, pages []data.Page) []data.Page {
+	constraints := api.user.GetConstraints(r)
+	if len(constraints.Notebooks) == 0 && len(constraints.Tags) == 0 {
+		return pages
+	}
+	allowed := []data.Page{}
+	for _, page := range pages {
+		if constraints.AllowsPage(page) {
+			allowed = append(allowed, page)
+		}
+	}
+	return allowed
+}
+
+//pageQueryFromRequest Reads the sort, order, limit and cursor query params. paged is false when neither limit nor
+//cursor were given, in which case the route keeps returning a plain array of every page like it always has.
+func pageQueryFromRequest(r *http.Request) (query data.PageQuery, paged bool, err error) {
+	params := r.URL.Query()
+	query.SortBy = params.Get("sort")
+	query.Descending = params.Get("order") == "desc"
+	query.Cursor = params.Get("cursor")
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil || query.Limit <= 0 || query.Limit > maxPageListLimit {
+			return query, true, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageListLimit))
+		}
+	} else if query.Cursor != "" {
+		query.Limit = defaultPageListLimit
+	}
+	return query, query.Limit > 0, nil
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+	"go.alargerobot.dev/notebook/notebook"
+	"go.alargerobot.dev/notebook/stream"
+)
+
+const streamHeartbeat = 25 * time.Second
+
+func (api *Routes) initStreamRoutes() {
+	api.handle(Route{Name: "eventstream", Method: "GET", Path: "/api/ash/events", Access: AccessToken, Scope: "notebook:read", TokenInQuery: true,
+		Summary: "Server-sent events for changes to the user's notebooks", ResponseType: "text/event-stream"}, api.eventstream)
+}
+
+//tokenFromQuery EventSource can't set an Authorization header, so let stream routes take the token as a query param.
+func (api *Routes) tokenFromQuery(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if token := request.URL.Query().Get("token"); token != "" && request.Header.Get("Authorization") == "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+func (api *Routes) eventstream(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "notebook:read") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "eventstream", 401)
+		return
+	}
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		common.WriteFailureResponse(err, resp, "eventstream", 400)
+		return
+	}
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		common.WriteFailureResponse(errors.New("streaming unsupported"), resp, "eventstream", 500)
+		return
+	}
+
+	events, unsubscribe := api.hub.Subscribe(username)
+	defer unsubscribe()
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	fmt.Fprint(resp, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(resp, ": keepalive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			if !api.streamEventAllowed(r, event) {
+				continue
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				common.LogError("", err)
+				continue
+			}
+			fmt.Fprintf(resp, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Event, payload)
+			flusher.Flush()
+		}
+	}
+}
+
+//streamEventAllowed Keys limited to some notebooks, or to tagged pages, only hear about what they could read through
+//the page routes. Events that don't say enough to tell (like a share link being deleted) are left out for them.
+func (api *Routes) streamEventAllowed(r *http.Request, event stream.Event) bool {
+	constraints := api.user.GetConstraints(r)
+	if len(constraints.Notebooks) == 0 && len(constraints.Tags) == 0 {
+		return true
+	}
+	var payload struct {
+		ID         string   `json:"id"`
+		NotebookID string   `json:"notebookID"`
+		PageID     string   `json:"pageID"`
+		Tags       []string `json:"tags"`
+	}
+	if err := json.Unmarshal(event.Data, &payload); err != nil {
+		return false
+	}
+
+	switch event.Event {
+	case notebook.EventNotebookCreated, notebook.EventNotebookDeleted:
+		return api.user.NotebookAllowed(r, payload.ID)
+	case notebook.EventPageCreated, notebook.EventPageEdited:
+		return api.user.PageAllowed(r, data.Page{ID: payload.ID, NotebookID: payload.NotebookID, Tags: payload.Tags})
+	case notebook.EventPageContent:
+		return api.streamPageAllowed(r, payload.ID, payload.NotebookID)
+	case notebook.EventPageShared:
+		return api.streamPageAllowed(r, payload.PageID, payload.NotebookID)
+	case notebook.EventPageDeleted:
+		//The page (and so its tags) is gone, only keys that aren't limited to tags can be told.
+		return !constraints.ReadOnly() && api.user.NotebookAllowed(r, payload.NotebookID)
+	}
+	return false
+}
+
+//streamPageAllowed For events that don't carry the page's tags.
+func (api *Routes) streamPageAllowed(r *http.Request, pageID, notebookID string) bool {
+	if !api.user.NotebookAllowed(r, notebookID) {
+		return false
+	}
+	page, err := api.notebookSvc.GetPageMetadata(r.Context(), pageID, notebookID)
+	return err == nil && api.user.PageAllowed(r, page)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+//trinityClaims The claims Trinity puts in the JWTs it issues.
+type trinityClaims struct {
+	jwt.Claims
+	Username string `json:"username"`
+	Group    string `json:"group"`
+}
+
+//trinityProvider Signs users in through the in-house Trinity service.
+type trinityProvider struct {
+	http     *http.Client
+	jwks     *jwksVerifier
+	issuer   string
+	audience string
+}
+
+//newTrinityProvider Checks tokens against the key set at the "jwksURL" config option, or Trinity's default location
+//if that isn't set. Tokens have to be issued by "trinityIssuer" (Trinity's own address by default) for
+//"trinityAudience" (our service id by default).
+func newTrinityProvider() *trinityProvider {
+	config := common.CurrentConfig
+	url := config.TrinityJWKSURL
+	if url == "" {
+		url = common.BaseAPIURL + "/trinity/.well-known/jwks.json"
+	}
+	provider := &trinityProvider{
+		http:     &http.Client{Timeout: time.Second * 2},
+		jwks:     newJWKSVerifier(url),
+		issuer:   config.TrinityIssuer,
+		audience: config.TrinityAudience,
+	}
+	if provider.issuer == "" {
+		provider.issuer = common.BaseAuthURL
+	}
+	if provider.audience == "" {
+		provider.audience = config.TrinitySID
+	}
+	return provider
+}
+
+//ExchangeCode ...
+func (t *trinityProvider) ExchangeCode(ctx context.Context, code string) (common.APIResponse, error) {
+	var serviceResp common.APIResponse
+	req, _ := http.NewRequestWithContext(ctx, "GET", common.BaseAPIURL+"/trinity/token", nil)
+
+	q := req.URL.Query()
+	q.Set("sid", common.CurrentConfig.TrinitySID)
+	q.Set("skey", common.CurrentConfig.TrinitySKey)
+	q.Set("code", code)
+	req.URL.RawQuery = q.Encode()
+	httpResp, err := t.http.Do(req)
+	if err != nil {
+		return serviceResp, err
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return serviceResp, err
+	}
+	if err := json.Unmarshal(body, &serviceResp); err != nil {
+		return serviceResp, err
+	}
+	if serviceResp.Status == "failed" {
+		serviceResp.HttpStatusCode = 500
+	} else {
+		serviceResp.HttpStatusCode = 200
+	}
+	return serviceResp, nil
+}
+
+//Verify Checks the token locally against Trinity's key set if it publishes one, otherwise asks Trinity. Tokens checked
+//locally have to say who issued them, who for and when they expire.
+func (t *trinityProvider) Verify(ctx context.Context, token string) (Identity, error) {
+	var claims trinityClaims
+	err := t.jwks.verify(ctx, token, &claims)
+	if err == nil {
+		err = claims.Validate(jwt.Expected{Issuer: t.issuer, Audience: jwt.Audience{t.audience}, Time: time.Now()})
+		if err == nil && claims.Expiry == nil {
+			err = errors.New("token doesn't expire")
+		}
+	} else if err == errNoJWKS {
+		claims, err = t.getUser(ctx, token)
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Username: claims.Username}
+	if identity.Username == "" {
+		identity.Username = claims.Subject
+	}
+	if claims.Group == "root" {
+		identity.Scopes = []string{"notebook", "tags", "admin"}
+	} else {
+		identity.Scopes = append([]string{}, userScopes...)
+	}
+	if claims.Expiry != nil {
+		identity.Expiry = claims.Expiry.Time()
+	}
+	return identity, nil
+}
+
+//getUser Asks Trinity who the token belongs to. Trinity's answer vouches for the token, so its expiry is taken from the
+//token as is.
+func (t *trinityProvider) getUser(ctx context.Context, token string) (trinityClaims, error) {
+	var user data.User
+	var serviceResp common.APIResponse
+	var claims trinityClaims
+	req, _ := http.NewRequestWithContext(ctx, "GET", common.BaseAPIURL+"/trinity/user", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	httpResp, err := t.http.Do(req)
+	if err != nil {
+		return claims, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != 200 {
+		return claims, errors.New("invalid token")
+	}
+	if body, err := ioutil.ReadAll(httpResp.Body); err == nil {
+		json.Unmarshal(body, &serviceResp)
+		json.Unmarshal([]byte(serviceResp.Response), &user)
+	} else {
+		return claims, common.LogError("apiResp", err)
+	}
+
+	if parsed, err := jwt.ParseSigned(token); err == nil {
+		parsed.UnsafeClaimsWithoutVerification(&claims)
+	}
+	claims.Username, claims.Group = user.Username, user.Group
+	return claims, nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//SecondFactorHeader Where routes that need a second factor expect the user's TOTP (or recovery) code.
+const SecondFactorHeader = "X-Second-Factor"
+
+//initTwoFactorRoutes Two-factor authentication is keyed by username, so it works whichever provider users sign in with.
+func (api *Routes) initTwoFactorRoutes() {
+	api.handle(Route{Name: "twofactorstatus", Method: "GET", Path: "/api/ash/user/2fa", Access: AccessUser,
+		Summary: "Whether the user has two-factor authentication turned on", Response: accounts.TwoFactorStatus{}}, api.twofactorstatus)
+	api.handle(Route{Name: "enrolltwofactor", Method: "POST", Path: "/api/ash/user/2fa/enroll", Access: AccessUser,
+		Summary: "Starts setting up two-factor authentication", Response: accounts.TwoFactorEnrollment{}}, api.audited("2fa.enroll", api.enrolltwofactor))
+	api.handle(Route{Name: "confirmtwofactor", Method: "POST", Path: "/api/ash/user/2fa/confirm", Access: AccessUser,
+		Summary: "Turns two-factor authentication on, responds with the recovery codes", Request: data.TwoFactorCodeRequest{}, Response: []string{}}, api.audited("2fa.confirm", api.confirmtwofactor))
+	api.handle(Route{Name: "disabletwofactor", Method: "POST", Path: "/api/ash/user/2fa/disable", Access: AccessUser,
+		Summary: "Turns two-factor authentication off", Request: data.TwoFactorCodeRequest{}}, api.audited("2fa.disable", api.disabletwofactor))
+}
+
+func (api *Routes) twofactorstatus(resp http.ResponseWriter, r *http.Request) {
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		status, err := api.accounts.TwoFactorStatus(r.Context(), username)
+		common.WriteResponse(resp, 500, status, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "twofactorstatus", 401)
+	}
+}
+func (api *Routes) enrolltwofactor(resp http.ResponseWriter, r *http.Request) {
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		enrollment, err := api.accounts.EnrollTwoFactor(r.Context(), username)
+		common.WriteResponse(resp, 400, enrollment, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "enrolltwofactor", 401)
+	}
+}
+func (api *Routes) confirmtwofactor(resp http.ResponseWriter, r *http.Request) {
+	var request data.TwoFactorCodeRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "confirmtwofactor", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		codes, err := api.accounts.ConfirmTwoFactor(r.Context(), username, request.Code)
+		common.WriteResponse(resp, 400, codes, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "confirmtwofactor", 401)
+	}
+}
+func (api *Routes) disabletwofactor(resp http.ResponseWriter, r *http.Request) {
+	var request data.TwoFactorCodeRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "disabletwofactor", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		common.WriteResponse(resp, 401, nil, api.accounts.DisableTwoFactor(r.Context(), username, request.Code))
+	} else {
+		common.WriteFailureResponse(err, resp, "disabletwofactor", 401)
+	}
+}
+
+//secondFactor Checks the code in the X-Second-Factor header for routes that a stolen token shouldn't be enough for.
+//Writes a 401 and returns false if it's missing or wrong, users without two-factor authentication always pass.
+func (api *Routes) secondFactor(resp http.ResponseWriter, r *http.Request, username, funcName string) bool {
+	if err := api.accounts.CheckSecondFactor(r.Context(), username, r.Header.Get(SecondFactorHeader)); err != nil {
+		common.WriteFailureResponse(err, resp, funcName, 401)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,461 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/accounts"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//maxV2BodyBytes The largest request body /api/v2 will read, the same as /api/ash allows for a page.
+const maxV2BodyBytes = 128 * 1024
+
+//v2Page A single page as /api/v2 returns it, its metadata and content together.
+type v2Page struct {
+	data.Page
+	Content string `json:"content"`
+}
+
+//v2PageRequest Creates or replaces a page. Leaving content out (or empty) when replacing one keeps its content.
+type v2PageRequest struct {
+	Title   string   `json:"title"`
+	Tags    []string `json:"tags"`
+	Content string   `json:"content"`
+}
+
+//v2NotebookRequest ...
+type v2NotebookRequest struct {
+	Name string `json:"name"`
+}
+
+//v2NotebookList ...
+type v2NotebookList struct {
+	Notebooks []data.NotebookReference `json:"notebooks"`
+}
+
+//v2TagRequest ...
+type v2TagRequest struct {
+	Value string `json:"value"`
+}
+
+//v2TagList ...
+type v2TagList struct {
+	Tags []data.PageTag `json:"tags"`
+}
+
+//initV2Routes /api/v2 is resource oriented: each route is registered for the methods it supports, bodies are plain
+//JSON both ways and failures are an APIError with a status that means what it says. /api/ash stays as it is for the UI.
+func (api *Routes) initV2Routes() {
+	vestigo.CustomNotFoundHandlerFunc(v2NotFound)
+	vestigo.CustomMethodNotAllowedHandlerFunc(v2MethodNotAllowed)
+
+	api.handle(Route{Name: "v2notebooks", Method: "GET", Path: "/api/v2/notebooks", Access: AccessToken, Scope: "notebook:read",
+		Summary: "The user's notebooks", Response: v2NotebookList{}}, api.v2notebooks)
+	api.handle(Route{Name: "v2newnotebook", Method: "POST", Path: "/api/v2/notebooks", Access: AccessToken, Scope: "notebook:create",
+		Summary: "Creates a notebook", Request: v2NotebookRequest{}, Response: data.NotebookReference{}, Status: http.StatusCreated}, api.audited("notebook.create", api.v2newnotebook))
+	api.handle(Route{Name: "v2notebook", Method: "GET", Path: "/api/v2/notebooks/:nbid", Access: AccessToken, Scope: "notebook:read",
+		Summary: "A notebook", Response: data.NotebookReference{}}, api.v2notebook)
+	api.handle(Route{Name: "v2deletenotebook", Method: "DELETE", Path: "/api/v2/notebooks/:nbid", Access: AccessToken, Scope: "notebook:delete", SecondFactor: true,
+		Summary: "Deletes the notebook and everything in it", Status: http.StatusNoContent}, api.audited("notebook.delete", api.v2deletenotebook, "nbid"))
+
+	api.handle(Route{Name: "v2pages", Method: "GET", Path: "/api/v2/notebooks/:nbid/pages", Access: AccessToken, Scope: "notebook:read", Query: []string{"sort", "order", "limit", "cursor"},
+		Summary: "The notebook's pages, a page (so to speak) at a time", Response: data.PageList{}}, api.v2pages)
+	api.handle(Route{Name: "v2newpage", Method: "POST", Path: "/api/v2/notebooks/:nbid/pages", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Creates a page", Request: v2PageRequest{}, Response: v2Page{}, Status: http.StatusCreated}, api.audited("page.create", api.v2newpage, "nbid"))
+	api.handle(Route{Name: "v2page", Method: "GET", Path: "/api/v2/notebooks/:nbid/pages/:id", Access: AccessToken, Scope: "notebook:read",
+		Summary: "A page and its content", Response: v2Page{}}, api.audited("page.read", api.v2page, "nbid", "id"))
+	api.handle(Route{Name: "v2editpage", Method: "PUT", Path: "/api/v2/notebooks/:nbid/pages/:id", Access: AccessToken, Scope: "notebook:write",
+		Summary: "Replaces a page's title and tags, and its content if any is given", Request: v2PageRequest{}, Response: data.Page{}}, api.audited("page.edit", api.v2editpage, "nbid", "id"))
+	api.handle(Route{Name: "v2deletepage", Method: "DELETE", Path: "/api/v2/notebooks/:nbid/pages/:id", Access: AccessToken, Scope: "notebook:delete",
+		Summary: "Deletes a page", Status: http.StatusNoContent}, api.audited("page.delete", api.v2deletepage, "nbid", "id"))
+
+	api.handle(Route{Name: "v2tags", Method: "GET", Path: "/api/v2/tags", Access: AccessToken, Scope: "tags",
+		Summary: "Every tag", Response: v2TagList{}}, api.v2tags)
+	api.handle(Route{Name: "v2newtag", Method: "POST", Path: "/api/v2/tags", Access: AccessToken, Scope: "tags",
+		Summary: "Creates a tag", Request: v2TagRequest{}, Response: data.PageTag{}, Status: http.StatusCreated}, api.v2newtag)
+	api.handle(Route{Name: "v2deletetag", Method: "DELETE", Path: "/api/v2/tags/:id", Access: AccessToken, Scope: "tags",
+		Summary: "Deletes a tag", Status: http.StatusNoContent}, api.v2deletetag)
+}
+
+func (api *Routes) v2notebooks(resp http.ResponseWriter, r *http.Request) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	refs, err := api.notebookSvc.GetNotebooks(r.Context(), username)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	list := v2NotebookList{Notebooks: []data.NotebookReference{}}
+	for _, ref := range refs {
+		if api.user.NotebookAllowed(r, ref.ID) {
+			list.Notebooks = append(list.Notebooks, ref)
+		}
+	}
+	common.WriteJSON(resp, http.StatusOK, list)
+}
+
+func (api *Routes) v2newnotebook(resp http.ResponseWriter, r *http.Request) {
+	var request v2NotebookRequest
+	username, err := api.v2Access(r, "notebook:create")
+	if err == nil && len(api.user.GetConstraints(r).Notebooks) > 0 {
+		err = common.NewAPIError(http.StatusForbidden, common.ErrCodeForbidden, "this key is limited to existing notebooks")
+	}
+	if err == nil {
+		err = decodeV2Body(resp, r, &request)
+	}
+	if err == nil && strings.TrimSpace(request.Name) == "" {
+		err = v2Invalid("name is required")
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+
+	ref, err := api.notebookSvc.NewNotebook(r.Context(), data.Notebook{
+		Name:  request.Name,
+		ID:    uuid.New().String(),
+		Owner: username,
+		Pages: []data.Page{},
+	})
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	auditResources(r, ref.ID)
+	resp.Header().Set("Location", "/api/v2/notebooks/"+ref.ID)
+	common.WriteJSON(resp, http.StatusCreated, ref)
+}
+
+func (api *Routes) v2notebook(resp http.ResponseWriter, r *http.Request) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	ref, err := api.v2Notebook(r, vestigo.Param(r, "nbid"), username)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	common.WriteJSON(resp, http.StatusOK, ref)
+}
+
+func (api *Routes) v2deletenotebook(resp http.ResponseWriter, r *http.Request) {
+	username, err := api.v2Access(r, "notebook:delete")
+	if err == nil {
+		_, err = api.v2Notebook(r, vestigo.Param(r, "nbid"), username)
+	}
+	if err == nil {
+		err = api.v2SecondFactor(r, username)
+	}
+	if err == nil {
+		err = api.notebookSvc.DeleteNotebook(r.Context(), vestigo.Param(r, "nbid"), username)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	common.WriteJSON(resp, http.StatusNoContent, nil)
+}
+
+//v2pages Always paged, defaultPageListLimit at a time unless limit says otherwise.
+func (api *Routes) v2pages(resp http.ResponseWriter, r *http.Request) {
+	username, err := api.v2Access(r, "notebook:read")
+	if err == nil {
+		_, err = api.v2Notebook(r, vestigo.Param(r, "nbid"), username)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	query, paged, err := pageQueryFromRequest(r)
+	if err != nil {
+		common.WriteAPIError(resp, v2Invalid(err.Error()))
+		return
+	} else if !paged {
+		query.Limit = defaultPageListLimit
+	}
+
+	pages, err := api.notebookSvc.GetPages(r.Context(), vestigo.Param(r, "nbid"), username, query)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	pages.Pages = api.allowedPages(r, pages.Pages)
+	if pages.Pages == nil {
+		pages.Pages = []data.Page{}
+	}
+	common.WriteJSON(resp, http.StatusOK, pages)
+}
+
+func (api *Routes) v2newpage(resp http.ResponseWriter, r *http.Request) {
+	var request v2PageRequest
+	notebookID := vestigo.Param(r, "nbid")
+	username, err := api.v2Access(r, "notebook:write")
+	if err == nil {
+		_, err = api.v2Notebook(r, notebookID, username)
+	}
+	if err == nil {
+		err = decodeV2Body(resp, r, &request)
+	}
+	if err == nil {
+		err = api.v2ValidatePage(r, request, username)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+
+	page := data.Page{
+		ID:         uuid.New().String(),
+		NotebookID: notebookID,
+		Tags:       request.Tags,
+		Title:      request.Title,
+		Creator:    username,
+		LastEdited: common.UnixTimestampInMS(),
+	}
+	auditResources(r, page.ID)
+	if err := api.notebookSvc.NewPage(r.Context(), data.NewPageRequest{Metadata: page, Content: request.Content, NotebookID: notebookID}); err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	resp.Header().Set("Location", "/api/v2/notebooks/"+notebookID+"/pages/"+page.ID)
+	common.WriteJSON(resp, http.StatusCreated, v2Page{Page: page, Content: request.Content})
+}
+
+func (api *Routes) v2page(resp http.ResponseWriter, r *http.Request) {
+	pageID, notebookID := vestigo.Param(r, "id"), vestigo.Param(r, "nbid")
+	_, err := api.v2Access(r, "notebook:read")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+
+	page, err := api.notebookSvc.GetPageMetadata(r.Context(), pageID, notebookID)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	content, err := api.notebookSvc.ReadPage(r.Context(), pageID, notebookID)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	common.WriteJSON(resp, http.StatusOK, v2Page{Page: page, Content: content})
+}
+
+//v2editpage Replaces the page's title and tags, and its content if any is given.
+func (api *Routes) v2editpage(resp http.ResponseWriter, r *http.Request) {
+	var request v2PageRequest
+	pageID, notebookID := vestigo.Param(r, "id"), vestigo.Param(r, "nbid")
+	username, err := api.v2Access(r, "notebook:write")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err == nil {
+		err = decodeV2Body(resp, r, &request)
+	}
+	if err == nil {
+		err = api.v2ValidatePage(r, request, username)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+
+	page, err := api.notebookSvc.GetPageMetadata(r.Context(), pageID, notebookID)
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	page.Title, page.Tags = request.Title, request.Tags
+	if err := api.notebookSvc.EditPage(r.Context(), data.NewPageRequest{Metadata: page, NotebookID: notebookID}, request.Content); err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	if page, err = api.notebookSvc.GetPageMetadata(r.Context(), pageID, notebookID); err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	common.WriteJSON(resp, http.StatusOK, page)
+}
+
+func (api *Routes) v2deletepage(resp http.ResponseWriter, r *http.Request) {
+	pageID, notebookID := vestigo.Param(r, "id"), vestigo.Param(r, "nbid")
+	username, err := api.v2Access(r, "notebook:delete")
+	if err == nil {
+		err = api.v2PageAllowed(r, pageID, notebookID)
+	}
+	if err == nil {
+		err = api.notebookSvc.DeletePage(r.Context(), pageID, notebookID, username)
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	common.WriteJSON(resp, http.StatusNoContent, nil)
+}
+
+func (api *Routes) v2tags(resp http.ResponseWriter, r *http.Request) {
+	if _, err := api.v2Access(r, "tags"); err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	tags, err := api.data.GetTags(r.Context())
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	if tags == nil {
+		tags = []data.PageTag{}
+	}
+	common.WriteJSON(resp, http.StatusOK, v2TagList{Tags: tags})
+}
+
+func (api *Routes) v2newtag(resp http.ResponseWriter, r *http.Request) {
+	var request v2TagRequest
+	username, err := api.v2Access(r, "tags")
+	if err == nil {
+		err = decodeV2Body(resp, r, &request)
+	}
+	if err == nil && strings.TrimSpace(request.Value) == "" {
+		err = v2Invalid("value is required")
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	tag, err := api.data.NewTag(r.Context(), data.PageTag{TagID: uuid.New().String(), TagValue: request.Value, Creator: username})
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	api.gqlCache.invalidate(graphqlEveryone)
+	common.WriteJSON(resp, http.StatusCreated, tag)
+}
+
+func (api *Routes) v2deletetag(resp http.ResponseWriter, r *http.Request) {
+	_, err := api.v2Access(r, "tags")
+	if err == nil {
+		err = api.data.DeleteTag(r.Context(), vestigo.Param(r, "id"))
+	}
+	if err != nil {
+		common.WriteAPIError(resp, err)
+		return
+	}
+	api.gqlCache.invalidate(graphqlEveryone)
+	common.WriteJSON(resp, http.StatusNoContent, nil)
+}
+
+//v2Access Returns who the request is from, or a 403 if their token doesn't have scope. The validator has already
+//turned away requests without a valid token.
+func (api *Routes) v2Access(r *http.Request, scope string) (string, error) {
+	username, err := api.user.GetUsernameFromToken(r)
+	if err != nil {
+		return "", common.NewAPIError(http.StatusUnauthorized, common.ErrCodeUnauthorized, "invalid token")
+	}
+	if !api.user.HasPermission(r, scope) {
+		return "", common.NewAPIError(http.StatusForbidden, common.ErrCodeForbidden, "this token doesn't allow "+scope)
+	}
+	return username, nil
+}
+
+//v2Notebook Returns the notebook if it's one of the user's and the request's key (if any) is allowed to use it,
+//otherwise a 404 so it doesn't give away which notebooks exist.
+func (api *Routes) v2Notebook(r *http.Request, notebookID, username string) (data.NotebookReference, error) {
+	if api.user.NotebookAllowed(r, notebookID) {
+		refs, err := api.notebookSvc.GetNotebooks(r.Context(), username)
+		if err != nil {
+			return data.NotebookReference{}, err
+		}
+		for _, ref := range refs {
+			if ref.ID == notebookID {
+				return ref, nil
+			}
+		}
+	}
+	return data.NotebookReference{}, common.NewAPIError(http.StatusNotFound, common.ErrCodeNotFound, "no such notebook")
+}
+
+//v2PageAllowed isPageAllowed, with a 404 for pages that don't exist and pages the request isn't allowed to see alike.
+func (api *Routes) v2PageAllowed(r *http.Request, pageID, notebookID string) error {
+	allowed, err := api.isPageAllowed(r, pageID, notebookID)
+	if err != nil && common.StatusForError(err, 0) != 0 {
+		return err
+	} else if !allowed {
+		return common.NewAPIError(http.StatusNotFound, common.ErrCodeNotFound, "no such page")
+	}
+	return nil
+}
+
+//v2ValidatePage Pages need a title, and their tags have to be ones the user made.
+func (api *Routes) v2ValidatePage(r *http.Request, request v2PageRequest, username string) error {
+	if strings.TrimSpace(request.Title) == "" {
+		return v2Invalid("title is required")
+	}
+	if len(request.Tags) > 0 {
+		if valid, err := api.data.IsValidTagID(r.Context(), request.Tags, username); err != nil {
+			return err
+		} else if !valid {
+			return v2Invalid("one or more of the specified tags is invalid")
+		}
+	}
+	return nil
+}
+
+//v2SecondFactor api.secondFactor for /api/v2.
+func (api *Routes) v2SecondFactor(r *http.Request, username string) error {
+	err := api.accounts.CheckSecondFactor(r.Context(), username, r.Header.Get(SecondFactorHeader))
+	if errors.Is(err, accounts.ErrSecondFactorRequired) {
+		return common.NewAPIError(http.StatusUnauthorized, common.ErrCodeSecondFactorRequired, err.Error())
+	} else if err != nil && common.StatusForError(err, 0) == 0 {
+		return common.NewAPIError(http.StatusUnauthorized, common.ErrCodeUnauthorized, err.Error())
+	}
+	return err
+}
+
+//decodeV2Body Decodes the JSON request body into v.
+func decodeV2Body(resp http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(resp, r.Body, maxV2BodyBytes)).Decode(v); err != nil {
+		return common.NewAPIError(http.StatusBadRequest, common.ErrCodeBadRequest, "request body isn't valid JSON: "+err.Error())
+	}
+	return nil
+}
+
+func v2Invalid(message string) error {
+	return common.NewAPIError(http.StatusUnprocessableEntity, common.ErrCodeValidation, message)
+}
+
+//v2NotFound Answers requests for routes that don't exist, as an APIError under /api/v2.
+func v2NotFound(resp http.ResponseWriter, r *http.Request) {
+	if common.IsAPIv2(r) {
+		common.WriteAPIError(resp, common.NewAPIError(http.StatusNotFound, common.ErrCodeNotFound, "no such route"))
+		return
+	}
+	http.NotFound(resp, r)
+}
+
+//v2MethodNotAllowed Answers requests with a method the route doesn't support, as an APIError under /api/v2.
+func v2MethodNotAllowed(allowed string) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, r *http.Request) {
+		resp.Header().Add("Allow", allowed)
+		if common.IsAPIv2(r) {
+			common.WriteAPIError(resp, common.NewAPIError(http.StatusMethodNotAllowed, common.ErrCodeMethodNotAllowed, r.Method+" isn't allowed here, use "+allowed))
+			return
+		}
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		resp.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+func (api *Routes) initWebhookRoutes() {
+	api.handle(Route{Name: "listwebhooks", Method: "GET", Path: "/api/ash/webhooks", Access: AccessToken, Scope: "admin:webhook",
+		Summary: "The user's webhooks", Response: []data.WebhookSubscription{}}, api.listwebhooks)
+	api.handle(Route{Name: "newwebhook", Method: "POST", Path: "/api/ash/webhooks/new", Access: AccessToken, Scope: "admin:webhook",
+		Summary: "Subscribes a URL to events, the signing secret is only ever returned here", Request: data.NewWebhookRequest{}, Response: data.NewWebhookResponse{}}, api.newwebhook)
+	api.handle(Route{Name: "deletewebhook", Method: "DELETE", Path: "/api/ash/webhooks/:id", Access: AccessToken, Scope: "admin:webhook",
+		Summary: "Deletes a webhook"}, api.deletewebhook)
+	api.handle(Route{Name: "webhookdeliveries", Method: "GET", Path: "/api/ash/webhooks/:id/deliveries", Access: AccessToken, Scope: "admin:webhook",
+		Summary: "The webhook's most recent deliveries", Response: []data.WebhookDelivery{}}, api.webhookdeliveries)
+}
+
+func (api *Routes) listwebhooks(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin:webhook") {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			hooks, err := api.data.GetWebhooks(r.Context(), username)
+			common.WriteResponse(resp, 500, hooks, err)
+		} else {
+			common.WriteFailureResponse(err, resp, "listwebhooks", 400)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "listwebhooks", 401)
+	}
+}
+func (api *Routes) newwebhook(resp http.ResponseWriter, r *http.Request) {
+	var request data.NewWebhookRequest
+	if api.user.HasPermission(r, "admin:webhook") == false {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "newwebhook", 401)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil {
+		common.WriteFailureResponse(err, resp, "newwebhook", 400)
+		return
+	}
+	if username, err := api.user.GetUsernameFromToken(r); err == nil {
+		hook, err := api.webhooks.Subscribe(r.Context(), request, username)
+		common.WriteResponse(resp, 400, hook, err)
+	} else {
+		common.WriteFailureResponse(err, resp, "newwebhook", 400)
+	}
+}
+func (api *Routes) deletewebhook(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin:webhook") {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			common.WriteResponse(resp, 400, nil, api.data.DeleteWebhook(r.Context(), vestigo.Param(r, "id"), username))
+		} else {
+			common.WriteFailureResponse(err, resp, "deletewebhook", 400)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "deletewebhook", 401)
+	}
+}
+func (api *Routes) webhookdeliveries(resp http.ResponseWriter, r *http.Request) {
+	if api.user.HasPermission(r, "admin:webhook") {
+		if username, err := api.user.GetUsernameFromToken(r); err == nil {
+			deliveries, err := api.data.GetWebhookDeliveries(r.Context(), vestigo.Param(r, "id"), username, 100)
+			common.WriteResponse(resp, 500, deliveries, err)
+		} else {
+			common.WriteFailureResponse(err, resp, "webhookdeliveries", 400)
+		}
+	} else {
+		common.WriteFailureResponse(errors.New("not authorized"), resp, "webhookdeliveries", 401)
+	}
+}
@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/crypto"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const (
+	//OutcomeSuccess ...
+	OutcomeSuccess = "success"
+	//OutcomeDenied The caller wasn't allowed to do it.
+	OutcomeDenied = "denied"
+	//OutcomeFailed The caller was allowed to, but it didn't work.
+	OutcomeFailed = "failed"
+
+	queueSize = 1024
+	pageSize  = 500
+
+	//hmacKeyPath Where in Vault's KV store the key entries are hashed with is kept, away from the entries themselves.
+	hmacKeyPath = "audit/hmac"
+)
+
+//Log The audit log. Entries are recorded in the background, one at a time so each chains onto the last, and can't be
+//changed or removed once they're written.
+type Log struct {
+	data    data.DataStore
+	vault   *crypto.VaultKMS
+	entries chan data.AuditEntry
+	keyLock sync.Mutex
+	key     []byte
+}
+
+//Verification The result of checking the chain. BrokenAt is the Seq of the first entry that doesn't check out.
+//HeadSeq and HeadHash are the last entry's, record them somewhere other than the database and pass them back to
+//Verify later to find out if the log's been cut short (or rewritten) since.
+type Verification struct {
+	Entries  int64  `json:"entries"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	HeadSeq  int64  `json:"headSeq"`
+	HeadHash string `json:"headHash,omitempty"`
+}
+
+//NewAuditLog ...
+func NewAuditLog(db data.DataStore, vault *crypto.VaultKMS) *Log {
+	return &Log{data: db, vault: vault, entries: make(chan data.AuditEntry, queueSize)}
+}
+
+//Start Starts writing out recorded entries.
+func (l *Log) Start() {
+	go func() {
+		for entry := range l.entries {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+			key, err := l.hmacKey(ctx)
+			if err == nil {
+				_, err = l.data.AppendAuditEntry(ctx, entry, key)
+			}
+			cancel()
+			if err != nil {
+				//Still leaves a trace of it somewhere.
+				encoded, _ := json.Marshal(entry)
+				common.LogError("audit", err)
+				common.LogWarn("audit", "dropped", string(encoded))
+			}
+		}
+	}()
+}
+
+//Record Queues the entry to be written. It only blocks if the queue is full, entries are never dropped to keep up.
+func (l *Log) Record(entry data.AuditEntry) {
+	if entry.Timestamp == 0 {
+		entry.Timestamp = common.UnixTimestampInMS()
+	}
+	l.entries <- entry
+}
+
+//Query ...
+func (l *Log) Query(ctx context.Context, query data.AuditQuery) ([]data.AuditEntry, error) {
+	return l.data.GetAuditEntries(ctx, query)
+}
+
+//Export Writes every entry matching query (ignoring its Limit) to w as JSON lines.
+func (l *Log) Export(ctx context.Context, query data.AuditQuery, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	query.Limit = pageSize
+	for {
+		entries, err := l.data.GetAuditEntries(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		if int64(len(entries)) < query.Limit {
+			return nil
+		}
+		query.AfterSeq = entries[len(entries)-1].Seq
+	}
+}
+
+//Verify Walks the whole log checking every entry's hash and that it follows on from the one before. If a head
+//recorded earlier is passed in (a zero seq skips this) the entry at seq has to still be there with that hash.
+func (l *Log) Verify(ctx context.Context, seq int64, hash string) (Verification, error) {
+	var result Verification
+	key, err := l.hmacKey(ctx)
+	if err != nil {
+		return result, err
+	}
+	var prev data.AuditEntry
+	query := data.AuditQuery{Limit: pageSize}
+	for {
+		entries, err := l.data.GetAuditEntries(ctx, query)
+		if err != nil {
+			return result, err
+		}
+		for _, entry := range entries {
+			result.Entries++
+			if reason := brokenLink(prev, entry, key); reason != "" {
+				result.BrokenAt, result.Reason = entry.Seq, reason
+				return result, nil
+			} else if entry.Seq == seq && entry.Hash != hash {
+				result.BrokenAt, result.Reason = entry.Seq, "doesn't match the head recorded earlier"
+				return result, nil
+			}
+			prev = entry
+		}
+		if int64(len(entries)) < query.Limit {
+			result.HeadSeq, result.HeadHash = prev.Seq, prev.Hash
+			if seq > prev.Seq {
+				result.BrokenAt, result.Reason = prev.Seq+1, "the log ends before the head recorded earlier"
+				return result, nil
+			}
+			result.Valid = true
+			return result, nil
+		}
+		query.AfterSeq = prev.Seq
+	}
+}
+
+//hmacKey The key entries are hashed with, from Vault. The first time the log's used there isn't one yet, so it's
+//generated and stored.
+func (l *Log) hmacKey(ctx context.Context) ([]byte, error) {
+	l.keyLock.Lock()
+	defer l.keyLock.Unlock()
+	if l.key != nil {
+		return l.key, nil
+	}
+
+	stored, err := l.vault.ReadKeyFromKV(ctx, hmacKeyPath)
+	if err == crypto.ErrKeyNotFound {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := l.vault.WriteKeyToKVStorage(ctx, hex.EncodeToString(key), hmacKeyPath); err != nil {
+			return nil, err
+		}
+		//Read it back, in case another instance stored one at the same time.
+		stored, err = l.vault.ReadKeyFromKV(ctx, hmacKeyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(stored)
+	if err != nil {
+		return nil, err
+	}
+	l.key = key
+	return key, nil
+}
+
+//brokenLink Why entry doesn't follow on from prev, or nothing if it does.
+func brokenLink(prev, entry data.AuditEntry, key []byte) string {
+	if entry.Seq != prev.Seq+1 {
+		return "expected entry " + strconv.FormatInt(prev.Seq+1, 10) + ", it's missing"
+	} else if entry.PrevHash != prev.Hash {
+		return "doesn't chain onto the entry before it"
+	} else if entry.Hash != data.AuditHash(entry, key) {
+		return "has been changed since it was written"
+	}
+	return ""
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+)
+
+const (
+	defaultTimeout        = 30 * time.Second
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 200 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+)
+
+//Credentials What the client signs its requests with. Both API keys and JWTs are sent as the bearer token.
+type Credentials interface {
+	Token(ctx context.Context) (string, error)
+}
+
+//APIKey ...
+type APIKey string
+
+//Token ...
+func (key APIKey) Token(ctx context.Context) (string, error) {
+	return string(key), nil
+}
+
+//JWT A token from the auth provider (or a local account's session token).
+type JWT string
+
+//Token ...
+func (token JWT) Token(ctx context.Context) (string, error) {
+	return string(token), nil
+}
+
+//TokenFunc Credentials worked out for every request, like a JWT that gets refreshed before it expires.
+type TokenFunc func(ctx context.Context) (string, error)
+
+//Token ...
+func (fn TokenFunc) Token(ctx context.Context) (string, error) {
+	return fn(ctx)
+}
+
+//Error A request the API turned down (or failed). RetryAfter is how long a 429 said to wait.
+type Error struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+//Client Talks to the /api/ash routes, taking care of the APIResponse wrapping and the multipart page format. Requests
+//that are safe to repeat (anything other than a POST) are retried by Retry when they fail because of the network, a
+//429 or an unavailable server. HTTP and Retry can be changed before the client is used.
+type Client struct {
+	HTTP        *http.Client
+	Retry       common.RetryPolicy
+	baseURL     string
+	credentials Credentials
+}
+
+type secondFactorKey struct{}
+
+//NewClient baseURL is where the API is served, like "https://notebook.example.com". credentials can be nil for the
+//routes that don't need a token (like SharedPage).
+func NewClient(baseURL string, credentials Credentials) *Client {
+	return &Client{
+		HTTP: &http.Client{Timeout: defaultTimeout},
+		Retry: common.RetryPolicy{
+			MaxAttempts: defaultRetryAttempts,
+			BaseDelay:   defaultRetryBaseDelay,
+			MaxDelay:    defaultRetryMaxDelay,
+		},
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		credentials: credentials,
+	}
+}
+
+//WithSecondFactor Sends code as the second factor with requests made with the returned context. Deleting notebooks and
+//creating, rotating or deleting API keys need one when the user has two-factor authentication turned on.
+func WithSecondFactor(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, secondFactorKey{}, code)
+}
+
+//do Sends the request and decodes the payload inside the APIResponse into out (unless out is nil).
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body []byte, out interface{}) error {
+	policy := c.Retry
+	policy.Retryable = func(err error) bool {
+		return method != "POST" && isRetryable(err)
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+
+	var payload string
+	err := policy.Do(ctx, nil, func() (err error) {
+		payload, err = c.send(ctx, method, path, query, contentType, body)
+		return err
+	})
+	if err != nil || out == nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(payload), out); err != nil {
+		return errors.New("couldn't decode the response: " + err.Error())
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (string, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.credentials != nil {
+		token, err := c.credentials.Token(ctx)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if code, ok := ctx.Value(secondFactorKey{}).(string); ok && code != "" {
+		req.Header.Set("X-Second-Factor", code)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var apiResp common.APIResponse
+	decodeErr := json.Unmarshal(raw, &apiResp)
+	if resp.StatusCode >= 400 || apiResp.Status == "failed" {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: apiResp.Response}
+		if decodeErr != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(raw))
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return "", apiErr
+	} else if decodeErr != nil {
+		return "", errors.New("couldn't decode the response: " + decodeErr.Error())
+	}
+	return apiResp.Response, nil
+}
+
+//isRetryable Network trouble, being rate limited and the server (or something in front of it) being unavailable are
+//worth another go, anything else will just fail again.
+func isRetryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+//doJSON do, with in encoded as the JSON body.
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, nil, "application/json", body, out)
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"go.alargerobot.dev/notebook/data"
+)
+
+//SharePage Creates a share link for the page and returns its token. Only the page's creator can share it.
+func (c *Client) SharePage(ctx context.Context, request data.SharePageRequest) (string, error) {
+	var token string
+	return token, c.doJSON(ctx, "POST", "/api/ash/sharing/share", request, &token)
+}
+
+//UnsharePage Deletes the share link. False if there wasn't one of the user's with that token.
+func (c *Client) UnsharePage(ctx context.Context, token string) (bool, error) {
+	var deleted bool
+	return deleted, c.do(ctx, "DELETE", "/api/ash/sharing/unshare/"+url.PathEscape(token), nil, "", nil, &deleted)
+}
+
+//SharedPages The user's share links.
+func (c *Client) SharedPages(ctx context.Context) ([]data.SharedPage, error) {
+	var pages []data.SharedPage
+	return pages, c.do(ctx, "GET", "/api/ash/sharing/allshared", nil, "", nil, &pages)
+}
+
+//SharedPage What the share link shows. Doesn't need credentials.
+func (c *Client) SharedPage(ctx context.Context, token string) (data.SharedPageContent, error) {
+	var page data.SharedPageContent
+	return page, c.do(ctx, "GET", "/api/ash/sharing/"+url.PathEscape(token), nil, "", nil, &page)
+}
+
+//APIKeys The user's API keys. Their hashes are left out.
+func (c *Client) APIKeys(ctx context.Context) ([]data.UserAPIKey, error) {
+	var keys []data.UserAPIKey
+	return keys, c.do(ctx, "GET", "/api/ash/user/apikeys", nil, "", nil, &keys)
+}
+
+//NewAPIKey Returns the new key, which can't be looked up again later. API keys can't manage API keys, so the client
+//has to be using the user's JWT. See WithSecondFactor.
+func (c *Client) NewAPIKey(ctx context.Context, request data.NewAPIKeyRequest) (string, error) {
+	var key string
+	return key, c.doJSON(ctx, "POST", "/api/ash/user/apikey/new", request, &key)
+}
+
+//RotateAPIKey Returns the key replacing the one with request.ID, which keeps working for request.GraceHours. See
+//WithSecondFactor.
+func (c *Client) RotateAPIKey(ctx context.Context, request data.RotateAPIKeyRequest) (string, error) {
+	var key string
+	return key, c.doJSON(ctx, "POST", "/api/ash/user/apikey/rotate", request, &key)
+}
+
+//DeleteAPIKey request.Creator has to be the user the client's signed in as. See WithSecondFactor.
+func (c *Client) DeleteAPIKey(ctx context.Context, request data.DeleteAPIKeyRequest) error {
+	return c.doJSON(ctx, "DELETE", "/api/ash/user/apikey", request, nil)
+}
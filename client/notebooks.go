@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+
+	"go.alargerobot.dev/notebook/data"
+)
+
+//Notebooks The user's notebooks.
+func (c *Client) Notebooks(ctx context.Context) ([]data.NotebookReference, error) {
+	var notebooks []data.NotebookReference
+	return notebooks, c.do(ctx, "GET", "/api/ash/notebooks", nil, "", nil, &notebooks)
+}
+
+//NewNotebook ...
+func (c *Client) NewNotebook(ctx context.Context, name string) (data.NotebookReference, error) {
+	var notebook data.NotebookReference
+	return notebook, c.do(ctx, "POST", "/api/ash/notebook/new", nil, "text/plain", []byte(name), &notebook)
+}
+
+//DeleteNotebook Deletes the notebook and everything in it. See WithSecondFactor.
+func (c *Client) DeleteNotebook(ctx context.Context, notebookID string) error {
+	return c.do(ctx, "DELETE", "/api/ash/notebook/"+url.PathEscape(notebookID)+"/burn", nil, "", nil, nil)
+}
+
+//Pages The notebook's pages. With a zero query every page comes back at once, otherwise it's one page (so to speak) of
+//them at a time, with NextCursor set while there are more.
+func (c *Client) Pages(ctx context.Context, notebookID string, query data.PageQuery) (data.PageList, error) {
+	var list data.PageList
+	params := url.Values{}
+	if query.SortBy != "" {
+		params.Set("sort", query.SortBy)
+	}
+	if query.Descending {
+		params.Set("order", "desc")
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.FormatInt(query.Limit, 10))
+	}
+	if query.Cursor != "" {
+		params.Set("cursor", query.Cursor)
+	}
+
+	path := "/api/ash/notebook/" + url.PathEscape(notebookID)
+	if query.Limit == 0 && query.Cursor == "" {
+		//Without limit or cursor the route responds with a plain array, like it always has.
+		return list, c.do(ctx, "GET", path, params, "", nil, &list.Pages)
+	}
+	return list, c.do(ctx, "GET", path, params, "", nil, &list)
+}
+
+//PagesWithTags The notebook's pages that have all of tags.
+func (c *Client) PagesWithTags(ctx context.Context, notebookID string, tags []string) ([]data.Page, error) {
+	var pages []data.Page
+	return pages, c.doJSON(ctx, "POST", "/api/ash/notebook/"+url.PathEscape(notebookID)+"/withtags", tags, &pages)
+}
+
+//Page The page's metadata.
+func (c *Client) Page(ctx context.Context, notebookID, pageID string) (data.Page, error) {
+	var page data.Page
+	return page, c.do(ctx, "GET", "/api/ash/notebook/"+url.PathEscape(notebookID)+"/page/"+url.PathEscape(pageID), nil, "", nil, &page)
+}
+
+//PageContent The page's (decrypted) content.
+func (c *Client) PageContent(ctx context.Context, notebookID, pageID string) (string, error) {
+	var content string
+	return content, c.do(ctx, "GET", "/api/ash/notebook/"+url.PathEscape(notebookID)+"/pagecontent/"+url.PathEscape(pageID), nil, "", nil, &content)
+}
+
+//NewPage Creates a page in the notebook. Only the page's title and tags are used, the rest is filled in by the API and
+//comes back in the page returned.
+func (c *Client) NewPage(ctx context.Context, notebookID string, page data.Page, content string) (data.Page, error) {
+	var created data.Page
+	contentType, body, err := pageForm(data.NewPageRequest{Metadata: page, NotebookID: notebookID}, content)
+	if err != nil {
+		return created, err
+	}
+	return created, c.do(ctx, "POST", "/api/ash/notebook/page", nil, contentType, body, &created)
+}
+
+//EditPage Replaces the page's metadata, and its content unless content is empty.
+func (c *Client) EditPage(ctx context.Context, notebookID string, page data.Page, content string) error {
+	contentType, body, err := pageForm(data.NewPageRequest{Metadata: page, NotebookID: notebookID}, content)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, "POST", "/api/ash/notebook/editpage", nil, contentType, body, nil)
+}
+
+//DeletePage ...
+func (c *Client) DeletePage(ctx context.Context, notebookID, pageID string) error {
+	return c.do(ctx, "DELETE", "/api/ash/notebook/"+url.PathEscape(notebookID)+"/ripout/"+url.PathEscape(pageID), nil, "", nil, nil)
+}
+
+//Tags Every tag.
+func (c *Client) Tags(ctx context.Context) ([]data.PageTag, error) {
+	var tags []data.PageTag
+	return tags, c.do(ctx, "GET", "/api/ash/tags", nil, "", nil, &tags)
+}
+
+//NewTag ...
+func (c *Client) NewTag(ctx context.Context, value string) (data.PageTag, error) {
+	var tag data.PageTag
+	return tag, c.do(ctx, "POST", "/api/ash/tags/new", nil, "text/plain", []byte(value), &tag)
+}
+
+//DeleteTag ...
+func (c *Client) DeleteTag(ctx context.Context, tagID string) error {
+	return c.do(ctx, "DELETE", "/api/ash/tags/delete/"+url.PathEscape(tagID), nil, "", nil, nil)
+}
+
+//pageForm The multipart form newpage and editpage read: the request as JSON in the metadata part, and the content.
+func pageForm(request data.NewPageRequest, content string) (string, []byte, error) {
+	metadata, err := json.Marshal(request)
+	if err != nil {
+		return "", nil, err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("metadata", string(metadata)); err != nil {
+		return "", nil, err
+	}
+	if err := form.WriteField("content", content); err != nil {
+		return "", nil, err
+	}
+	if err := form.Close(); err != nil {
+		return "", nil, err
+	}
+	return form.FormDataContentType(), body.Bytes(), nil
+}
@@ -1,22 +1,33 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/husobee/vestigo"
+	"go.alargerobot.dev/notebook/accounts"
 	"go.alargerobot.dev/notebook/api"
+	"go.alargerobot.dev/notebook/audit"
 	"go.alargerobot.dev/notebook/common"
 	"go.alargerobot.dev/notebook/crypto"
 	"go.alargerobot.dev/notebook/data"
+	"go.alargerobot.dev/notebook/reminder"
+	"go.alargerobot.dev/notebook/stream"
+	"go.alargerobot.dev/notebook/webhook"
 )
 
 func main() {
 	local := flag.Bool("local", false, "")
 	dev := flag.Bool("devmode", false, "")
 	ppid := flag.Int("ppid", -1, "")
+	migrate := flag.Bool("migrate", false, "apply pending schema migrations and exit")
+	dryRun := flag.Bool("dryrun", false, "with -migrate, print the pending migrations without applying them")
+	addUser := flag.String("adduser", "", "create a local account with the password in $NOTEBOOK_PASSWORD and exit")
+	admin := flag.Bool("admin", false, "with -adduser, make the account an admin")
 	flag.Parse()
 
 	common.CommonProcessInit(*dev, true)
@@ -31,13 +42,56 @@ func main() {
 	router := vestigo.NewRouter()
 	router.SetGlobalCors(&vestigo.CorsAccessControl{
 		AllowMethods: []string{"GET", "POST", "DELETE", "OPTIONS", "PUT"},
-		AllowHeaders: []string{"Authorization", "Cache-Control", "X-Requested-With", "Content-Type"},
-		AllowOrigin:  []string{"https://notebook" + common.BaseURL, "http://notebookdev" + common.BaseURL, "http://192.168.1.12:4200", "http://localhost:4200"},
+		AllowHeaders: []string{"Authorization", "Cache-Control", "X-Requested-With", "Content-Type", api.SecondFactorHeader},
+		AllowOrigin:  common.AllowedOrigins(),
 	})
 
 	kms := crypto.NewVaultKMS(*dev)
 
-	api.NewAPIRouter(data.NewDataStore(kms), router, *dev, kms)
+	cache, err := data.NewCacheService()
+	if err != nil {
+		//The cache reconnects once Redis is reachable, until then every instance just misses it.
+		common.LogWarn("error", err, "starting without a cache connection")
+	}
+	dataStore := data.NewDataStore(kms, cache)
+	if *migrate {
+		plan, err := data.Migrate(dataStore, *dryRun)
+		for _, step := range plan {
+			fmt.Println(step)
+		}
+		if err != nil {
+			common.LogError("", err)
+			os.Exit(1)
+		}
+		return
+	} else if !common.CurrentConfig.ManualMigrations {
+		if _, err := data.Migrate(dataStore, false); err != nil {
+			common.LogError("", err)
+			os.Exit(1)
+		}
+	}
+	if *addUser != "" {
+		request := data.NewUserRequest{Username: *addUser, Password: os.Getenv("NOTEBOOK_PASSWORD")}
+		if *admin {
+			request.Group = "root"
+		}
+		if err := accounts.NewAccountService(dataStore, kms).Register(context.Background(), request); err != nil {
+			common.LogError("", err)
+			os.Exit(1)
+		}
+		fmt.Println("created account", *addUser)
+		return
+	}
+	reminders := reminder.NewReminderService(dataStore)
+	reminders.StartScheduler()
+	webhooks := webhook.NewDispatcher(dataStore, kms)
+	webhooks.Start()
+	hub := stream.NewHub(cache)
+	hub.Start()
+	auditLog := audit.NewAuditLog(dataStore, kms)
+	auditLog.Start()
+
+	api.NewAPIRouter(dataStore, router, *dev, kms, reminders, webhooks, hub, cache, auditLog)
 
 	if err := http.ListenAndServe("localhost:1013", router); err != nil {
 		common.LogError("", err)
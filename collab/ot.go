@@ -0,0 +1,233 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+//maxOperationLength The longest document an operation can apply to. Component counts come from clients, bounding
+//their total keeps BaseLen (and TargetLen) from overflowing into something that passes Apply's length check.
+const maxOperationLength = 1 << 30
+
+type opKind uint8
+
+const (
+	opRetain opKind = iota
+	opInsert
+	opDelete
+)
+
+type op struct {
+	kind opKind
+	n    int
+	text string
+}
+
+//TextOperation A sequence of retain/insert/delete components that turns a document of BaseLen characters into one of
+//TargetLen characters. Encoded in JSON the same way ot.js does it: positive ints retain, negative ints delete and
+//strings insert. Lengths are counted in unicode code points.
+type TextOperation struct {
+	ops       []op
+	BaseLen   int
+	TargetLen int
+}
+
+//Retain ...
+func (o *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.BaseLen += n
+	o.TargetLen += n
+	if last := o.last(); last != nil && last.kind == opRetain {
+		last.n += n
+	} else {
+		o.ops = append(o.ops, op{kind: opRetain, n: n})
+	}
+	return o
+}
+
+//Insert ...
+func (o *TextOperation) Insert(text string) *TextOperation {
+	if text == "" {
+		return o
+	}
+	n := utf8.RuneCountInString(text)
+	o.TargetLen += n
+	last := o.last()
+	if last != nil && last.kind == opInsert {
+		last.text += text
+		last.n += n
+	} else if last != nil && last.kind == opDelete {
+		// Keep inserts ahead of deletes so equivalent operations always have the same form.
+		if len(o.ops) > 1 && o.ops[len(o.ops)-2].kind == opInsert {
+			o.ops[len(o.ops)-2].text += text
+			o.ops[len(o.ops)-2].n += n
+		} else {
+			o.ops = append(o.ops, *last)
+			o.ops[len(o.ops)-2] = op{kind: opInsert, n: n, text: text}
+		}
+	} else {
+		o.ops = append(o.ops, op{kind: opInsert, n: n, text: text})
+	}
+	return o
+}
+
+//Delete ...
+func (o *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.BaseLen += n
+	if last := o.last(); last != nil && last.kind == opDelete {
+		last.n += n
+	} else {
+		o.ops = append(o.ops, op{kind: opDelete, n: n})
+	}
+	return o
+}
+
+//IsNoop ...
+func (o *TextOperation) IsNoop() bool {
+	return len(o.ops) == 0 || (len(o.ops) == 1 && o.ops[0].kind == opRetain)
+}
+
+//Apply Applies the operation to doc, returning the new document.
+func (o *TextOperation) Apply(doc []rune) ([]rune, error) {
+	if len(doc) != o.BaseLen {
+		return nil, errors.New("operation's base length doesn't match the document length")
+	}
+	result := make([]rune, 0, o.TargetLen)
+	pos := 0
+	for _, c := range o.ops {
+		if c.kind != opInsert && c.n > len(doc)-pos {
+			return nil, errors.New("operation goes past the end of the document")
+		}
+		switch c.kind {
+		case opRetain:
+			result = append(result, doc[pos:pos+c.n]...)
+			pos += c.n
+		case opInsert:
+			result = append(result, []rune(c.text)...)
+		case opDelete:
+			pos += c.n
+		}
+	}
+	return result, nil
+}
+
+//Transform Takes two operations a and b that apply to the same document and produces a' and b' such that
+//apply(apply(doc, a), b') == apply(apply(doc, b), a'). When both insert at the same spot, a's insert goes first.
+func Transform(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.BaseLen != b.BaseLen {
+		return nil, nil, errors.New("both operations have to have the same base length")
+	}
+	aPrime, bPrime := &TextOperation{}, &TextOperation{}
+	opsA, opsB := append([]op(nil), a.ops...), append([]op(nil), b.ops...)
+	var i, j int
+	var opA, opB *op
+	next := func(ops []op, idx *int) *op {
+		if *idx < len(ops) {
+			*idx++
+			return &ops[*idx-1]
+		}
+		return nil
+	}
+	opA, opB = next(opsA, &i), next(opsB, &j)
+
+	for opA != nil || opB != nil {
+		if opA != nil && opA.kind == opInsert {
+			aPrime.Insert(opA.text)
+			bPrime.Retain(opA.n)
+			opA = next(opsA, &i)
+			continue
+		}
+		if opB != nil && opB.kind == opInsert {
+			aPrime.Retain(opB.n)
+			bPrime.Insert(opB.text)
+			opB = next(opsB, &j)
+			continue
+		}
+		if opA == nil || opB == nil {
+			return nil, nil, errors.New("operations don't cover the same document")
+		}
+
+		n := opA.n
+		if opB.n < n {
+			n = opB.n
+		}
+		switch {
+		case opA.kind == opRetain && opB.kind == opRetain:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case opA.kind == opDelete && opB.kind == opRetain:
+			aPrime.Delete(n)
+		case opA.kind == opRetain && opB.kind == opDelete:
+			bPrime.Delete(n)
+		}
+		// Delete vs delete: both sides already removed the same text, so neither needs to do anything.
+
+		opA.n -= n
+		opB.n -= n
+		if opA.n == 0 {
+			opA = next(opsA, &i)
+		}
+		if opB.n == 0 {
+			opB = next(opsB, &j)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+//MarshalJSON ...
+func (o *TextOperation) MarshalJSON() ([]byte, error) {
+	components := make([]interface{}, len(o.ops))
+	for i, c := range o.ops {
+		switch c.kind {
+		case opRetain:
+			components[i] = c.n
+		case opInsert:
+			components[i] = c.text
+		case opDelete:
+			components[i] = -c.n
+		}
+	}
+	return json.Marshal(components)
+}
+
+//UnmarshalJSON Rejects operations on documents longer than maxOperationLength.
+func (o *TextOperation) UnmarshalJSON(raw []byte) error {
+	var components []interface{}
+	if err := json.Unmarshal(raw, &components); err != nil {
+		return err
+	}
+	*o = TextOperation{}
+	for _, c := range components {
+		switch value := c.(type) {
+		case float64:
+			if value == 0 || value != float64(int64(value)) {
+				return errors.New("invalid operation component")
+			} else if value > float64(maxOperationLength-o.BaseLen) || -value > float64(maxOperationLength-o.BaseLen) {
+				return errors.New("operation is longer than any document can be")
+			}
+			if value > 0 {
+				o.Retain(int(value))
+			} else {
+				o.Delete(int(-value))
+			}
+		case string:
+			o.Insert(value)
+		default:
+			return errors.New("invalid operation component")
+		}
+	}
+	return nil
+}
+
+func (o *TextOperation) last() *op {
+	if len(o.ops) == 0 {
+		return nil
+	}
+	return &o.ops[len(o.ops)-1]
+}
@@ -0,0 +1,107 @@
+package collab
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustOp(t *testing.T, raw string) *TextOperation {
+	t.Helper()
+	var o TextOperation
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		t.Fatalf("unmarshal %s: %v", raw, err)
+	}
+	return &o
+}
+
+func TestTransformConverges(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		a, b string
+	}{
+		{"inserts at different spots", "hello", `["X",5]`, `[5,"Y"]`},
+		{"inserts at the same spot", "hello", `[2,"ab",3]`, `[2,"cd",3]`},
+		{"insert inside a delete", "hello world", `[3,-5,3]`, `[5,"!",6]`},
+		{"overlapping deletes", "abcdefgh", `[1,-4,3]`, `[3,-4,1]`},
+		{"same delete", "abcdef", `[2,-2,2]`, `[2,-2,2]`},
+		{"delete everything vs insert", "abc", `[-3]`, `[1,"z",2]`},
+		{"multibyte", "héllo wörld", `[1,-1,"e",9]`, `[7,-1,"o",3]`},
+		{"empty document", "", `["a"]`, `["b"]`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, b := mustOp(t, c.a), mustOp(t, c.b)
+			aPrime, bPrime, err := Transform(a, b)
+			if err != nil {
+				t.Fatalf("transform: %v", err)
+			}
+			doc := []rune(c.doc)
+			afterA, err := a.Apply(doc)
+			if err != nil {
+				t.Fatalf("apply a: %v", err)
+			}
+			left, err := bPrime.Apply(afterA)
+			if err != nil {
+				t.Fatalf("apply b': %v", err)
+			}
+			afterB, err := b.Apply(doc)
+			if err != nil {
+				t.Fatalf("apply b: %v", err)
+			}
+			right, err := aPrime.Apply(afterB)
+			if err != nil {
+				t.Fatalf("apply a': %v", err)
+			}
+			if string(left) != string(right) {
+				t.Fatalf("diverged: %q vs %q", string(left), string(right))
+			}
+		})
+	}
+}
+
+func TestTransformRejectsDifferentBases(t *testing.T) {
+	if _, _, err := Transform(mustOp(t, `[3]`), mustOp(t, `[4]`)); err == nil {
+		t.Fatal("expected an error for operations on different documents")
+	}
+}
+
+func TestUnmarshalRejectsMalformedOperations(t *testing.T) {
+	cases := map[string]string{
+		"zero":             `[0]`,
+		"fraction":         `[1.5]`,
+		"object":           `[{"retain":1}]`,
+		"not a list":       `{"ops":[1]}`,
+		"too long":         `[2147483648]`,
+		"too long delete":  `[-2147483648]`,
+		"overflowing sum":  `[4611686018427387904,"x",4611686018427387904,"x",4611686018427387904,"x",4611686018427387904]`,
+		"accumulates past": `[1073741824,-1]`,
+	}
+	for name, raw := range cases {
+		t.Run(name, func(t *testing.T) {
+			var o TextOperation
+			if err := json.Unmarshal([]byte(raw), &o); err == nil {
+				t.Fatalf("expected %s to be rejected, got BaseLen %d", raw, o.BaseLen)
+			}
+		})
+	}
+}
+
+func TestApplyRejectsWrongLength(t *testing.T) {
+	for _, raw := range []string{`[3]`, `[5,-1]`, `["x",-2]`} {
+		if _, err := mustOp(t, raw).Apply([]rune("abcd")); err == nil {
+			t.Fatalf("expected %s not to apply to a 4 character document", raw)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	raw := `[2,"ab",-3,1]`
+	encoded, err := json.Marshal(mustOp(t, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != raw {
+		t.Fatalf("got %s, want %s", encoded, raw)
+	}
+}
@@ -0,0 +1,339 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/notebook"
+)
+
+const (
+	snapshotInterval = 30 * time.Second
+	maxHistory       = 500
+	maxMessageSize   = 1024 * 1024
+	pingInterval     = 30 * time.Second
+	pongTimeout      = 60 * time.Second
+	writeTimeout     = 10 * time.Second
+	sendBuffer       = 64
+)
+
+//PageStore Where sessions load page content from and save snapshots to. Satisfied by notebook.ServiceAPI.
+type PageStore interface {
+	ReadPage(ctx context.Context, pageID, notebookID string) (string, error)
+	EditPageContent(ctx context.Context, content, pageID, notebookID string) error
+}
+
+//Message Everything sent over a session's websocket in either direction is one of these.
+type Message struct {
+	Type     string          `json:"type"`
+	ClientID string          `json:"clientID,omitempty"`
+	Revision int             `json:"revision"`
+	Ops      *TextOperation  `json:"ops,omitempty"`
+	Content  string          `json:"content,omitempty"`
+	Cursor   json.RawMessage `json:"cursor,omitempty"`
+	Users    []Participant   `json:"users,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+//Participant ...
+type Participant struct {
+	ClientID string `json:"clientID"`
+	Username string `json:"username"`
+}
+
+//Session One shared document. Clients send operations against the revision they last saw, the session transforms them
+//against everything that's happened since, applies them and broadcasts the result.
+//Sessions only exist on the instance that the participants are connected to.
+type Session struct {
+	lock        sync.Mutex
+	pageID      string
+	notebookID  string
+	owner       string
+	doc         []rune
+	revision    int
+	history     []*TextOperation
+	historyBase int
+	dirty       bool
+	clients     map[*client]bool
+}
+
+type client struct {
+	id       string
+	username string
+	conn     *websocket.Conn
+	send     chan Message
+}
+
+//Manager Keeps track of the open session for each page, and periodically snapshots them back into page storage.
+type Manager struct {
+	lock     sync.Mutex
+	pages    PageStore
+	events   notebook.EventEmitter
+	sessions map[string]*Session
+	stop     chan bool
+}
+
+//NewManager ...
+func NewManager(pages PageStore, events notebook.EventEmitter) *Manager {
+	return &Manager{
+		pages:    pages,
+		events:   events,
+		sessions: make(map[string]*Session),
+		stop:     make(chan bool),
+	}
+}
+
+//Start Starts the snapshot timer.
+func (m *Manager) Start() {
+	go func() {
+		ticker := time.NewTicker(snapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.lock.Lock()
+				sessions := make([]*Session, 0, len(m.sessions))
+				for _, s := range m.sessions {
+					sessions = append(sessions, s)
+				}
+				m.lock.Unlock()
+				for _, s := range sessions {
+					m.snapshot(s)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+//Stop ...
+func (m *Manager) Stop() {
+	close(m.stop)
+}
+
+//Serve Joins conn to the session for the given page (opening one if needed) and blocks until the client goes away.
+func (m *Manager) Serve(conn *websocket.Conn, pageID, notebookID, username string) {
+	c := &client{id: uuid.New().String(), username: username, conn: conn, send: make(chan Message, sendBuffer)}
+	session, err := m.join(pageID, notebookID, c)
+	if err != nil {
+		conn.WriteJSON(Message{Type: "error", Error: err.Error()})
+		conn.Close()
+		return
+	}
+
+	go c.writeLoop()
+	c.readLoop(session)
+
+	session.lock.Lock()
+	delete(session.clients, c)
+	close(c.send)
+	remaining := len(session.clients)
+	session.broadcast(Message{Type: "presence", Users: session.participants()}, nil)
+	session.lock.Unlock()
+
+	if remaining == 0 {
+		m.leave(session)
+	}
+}
+
+//join Adds c to the page's session and queues up its initial copy of the document. Done under the manager's lock so
+//a session that's being torn down by leave can't pick up new clients.
+func (m *Manager) join(pageID, notebookID string, c *client) (*Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if session, exists := m.sessions[pageID]; exists {
+		if session.notebookID != notebookID {
+			return nil, errors.New("page isn't in that notebook")
+		}
+		session.lock.Lock()
+		session.add(c)
+		session.lock.Unlock()
+		return session, nil
+	}
+	content, err := m.pages.ReadPage(context.Background(), pageID, notebookID)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		pageID:     pageID,
+		notebookID: notebookID,
+		owner:      c.username,
+		doc:        []rune(content),
+		clients:    make(map[*client]bool),
+	}
+	session.add(c)
+	m.sessions[pageID] = session
+	return session, nil
+}
+
+func (m *Manager) leave(session *Session) {
+	m.snapshot(session)
+	m.lock.Lock()
+	session.lock.Lock()
+	if len(session.clients) == 0 && m.sessions[session.pageID] == session {
+		delete(m.sessions, session.pageID)
+	}
+	session.lock.Unlock()
+	m.lock.Unlock()
+}
+
+func (m *Manager) snapshot(session *Session) {
+	session.lock.Lock()
+	if !session.dirty {
+		session.lock.Unlock()
+		return
+	}
+	content := string(session.doc)
+	session.dirty = false
+	session.lock.Unlock()
+
+	if err := m.pages.EditPageContent(context.Background(), content, session.pageID, session.notebookID); err != nil {
+		common.LogError(session.pageID, err)
+		session.lock.Lock()
+		session.dirty = true
+		session.lock.Unlock()
+		return
+	}
+	m.events.Emit(session.owner, notebook.EventPageContent, map[string]string{"id": session.pageID, "notebookID": session.notebookID})
+}
+
+//apply Brings an operation made against revision up to date, applies it and returns the transformed operation.
+//Must be called with the session lock held.
+func (s *Session) apply(revision int, operation *TextOperation) (*TextOperation, error) {
+	if revision < s.historyBase || revision > s.revision {
+		return nil, errors.New("revision is out of range, resync required")
+	}
+	for _, concurrent := range s.history[revision-s.historyBase:] {
+		var err error
+		if operation, _, err = Transform(operation, concurrent); err != nil {
+			return nil, err
+		}
+	}
+	doc, err := operation.Apply(s.doc)
+	if err != nil {
+		return nil, err
+	}
+	s.doc = doc
+	s.revision++
+	s.dirty = true
+	s.history = append(s.history, operation)
+	if len(s.history) > maxHistory {
+		trim := len(s.history) - maxHistory
+		s.history = append([]*TextOperation(nil), s.history[trim:]...)
+		s.historyBase += trim
+	}
+	return operation, nil
+}
+
+//add Must be called with the session lock held.
+func (s *Session) add(c *client) {
+	s.clients[c] = true
+	c.send <- Message{Type: "init", ClientID: c.id, Revision: s.revision, Content: string(s.doc), Users: s.participants()}
+	s.broadcast(Message{Type: "presence", Users: s.participants()}, c)
+}
+
+//broadcast Must be called with the session lock held.
+func (s *Session) broadcast(msg Message, except *client) {
+	for c := range s.clients {
+		if c == except {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			// Too far behind to catch up, drop them. They'll get a fresh copy of the doc when they reconnect.
+			c.conn.Close()
+		}
+	}
+}
+
+//participants Must be called with the session lock held.
+func (s *Session) participants() []Participant {
+	users := make([]Participant, 0, len(s.clients))
+	for c := range s.clients {
+		users = append(users, Participant{ClientID: c.id, Username: c.username})
+	}
+	return users
+}
+
+func (c *client) readLoop(session *Session) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	for {
+		var msg Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		c.handle(session, msg)
+	}
+}
+
+//handle Takes the session lock for a single message. The unlock is deferred so that a message that panics doesn't
+//leave the session, and every later join of the page, stuck waiting for it.
+func (c *client) handle(session *Session, msg Message) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	switch msg.Type {
+	case "op":
+		if msg.Ops == nil {
+			c.trySend(Message{Type: "error", Error: "missing ops"})
+			break
+		}
+		if applied, err := session.apply(msg.Revision, msg.Ops); err == nil {
+			c.trySend(Message{Type: "ack", Revision: session.revision})
+			session.broadcast(Message{Type: "op", ClientID: c.id, Revision: session.revision, Ops: applied}, c)
+		} else {
+			c.trySend(Message{Type: "error", Error: err.Error(), Revision: session.revision})
+		}
+	case "cursor":
+		session.broadcast(Message{Type: "cursor", ClientID: c.id, Revision: session.revision, Cursor: msg.Cursor}, c)
+	case "resync":
+		c.trySend(Message{Type: "init", ClientID: c.id, Revision: session.revision, Content: string(session.doc), Users: session.participants()})
+	default:
+		c.trySend(Message{Type: "error", Error: "unknown message type"})
+	}
+}
+
+func (c *client) trySend(msg Message) {
+	select {
+	case c.send <- msg:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
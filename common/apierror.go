@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//APIv2Prefix Routes under it respond with plain JSON bodies and APIError errors rather than APIResponse.
+const APIv2Prefix = "/api/v2/"
+
+//The codes an APIError can have. Clients are expected to switch on these, not on the message.
+const (
+	ErrCodeBadRequest           = "bad_request"
+	ErrCodeValidation           = "validation_failed"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeSecondFactorRequired = "second_factor_required"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeMethodNotAllowed     = "method_not_allowed"
+	ErrCodeConflict             = "conflict"
+	ErrCodeTooManyRequests      = "too_many_requests"
+	ErrCodeInternal             = "internal"
+	ErrCodeUnavailable          = "unavailable"
+	ErrCodeTimeout              = "timeout"
+)
+
+//APIError An error as /api/v2 returns it, inside an APIErrorBody.
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//APIErrorBody ...
+type APIErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+//NewAPIError ...
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+//WriteJSON Writes body as is with the given status. A nil body (like for a 204) writes nothing.
+func WriteJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writeCommonHeaders(writer)
+	writer.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(writer).Encode(body)
+	}
+}
+
+//WriteAPIError Writes err as an APIError, see ToAPIError.
+func WriteAPIError(writer http.ResponseWriter, err error) {
+	apiErr := ToAPIError(err)
+	WriteJSON(writer, apiErr.Status, APIErrorBody{Error: *apiErr})
+}
+
+//ToAPIError Missing and conflicting resources (ErrNotFound and ErrConflict) keep their message, which only says what
+//the client asked for. Other errors that aren't an APIError already are logged and reported as internal errors (or as
+//timeouts and unavailable dependencies, see StatusForError) without their message, which may say more about our
+//internals than the client needs to know.
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	status := StatusForError(err, http.StatusInternalServerError)
+	if status == http.StatusNotFound || status == http.StatusConflict {
+		return NewAPIError(status, codeForStatus(status), err.Error())
+	}
+	LogError("", err)
+	switch status {
+	case http.StatusGatewayTimeout:
+		return NewAPIError(status, ErrCodeTimeout, "a dependency timed out, try again")
+	case http.StatusServiceUnavailable:
+		return NewAPIError(status, ErrCodeUnavailable, "a dependency is unavailable, try again later")
+	default:
+		return NewAPIError(status, ErrCodeInternal, "internal error")
+	}
+}
+
+//APIErrorFromResponse Turns a failed APIResponse (like a validator's) into an APIError.
+func APIErrorFromResponse(resp APIResponse) *APIError {
+	return NewAPIError(resp.HttpStatusCode, codeForStatus(resp.HttpStatusCode), resp.Response)
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusTooManyRequests:
+		return ErrCodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	case http.StatusGatewayTimeout:
+		return ErrCodeTimeout
+	}
+	return ErrCodeInternal
+}
+
+//JSONRequestWrapper RequestWrapper for /api/v2 routes. The method is left to the router (which answers a 405 with the
+//allowed methods), and a failed validation is written as an APIError.
+func JSONRequestWrapper(validator func(*http.Request) APIResponse, handler func(http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		request = request.WithContext(context.WithValue(request.Context(), requestMemoKey{}, &requestMemo{values: make(map[string]memoized)}))
+		if resp := validator(request); resp.Status != "success" {
+			WriteAPIError(writer, APIErrorFromResponse(resp))
+		} else if identityAllowed(writer, request) {
+			handler(writer, request)
+		}
+	})
+}
+
+//IsAPIv2 Whether the request is for an /api/v2 route.
+func IsAPIv2(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, APIv2Prefix)
+}
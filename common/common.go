@@ -1,15 +1,18 @@
 package common
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"os"
@@ -38,6 +41,78 @@ type LocalAppConfig struct {
 	VaultAppRoleID      string `json:"vaultRole"`
 	VaultBaseAuthToken  string `json:"baseToken"`
 	FireBaseEndpointURL string `json:"firebaseEndpoint"`
+	SMTPServerAddr      string `json:"smtpServer"`
+	SMTPUsername        string `json:"smtpUser"`
+	SMTPPassword        string `json:"smtpPass"`
+	SMTPFromAddr        string `json:"smtpFrom"`
+	ReminderPollSeconds int    `json:"reminderPollInterval"`
+	StorageBackend      string `json:"storage"`
+	EmbeddedDBPath      string `json:"embeddedDBPath"`
+	ManualMigrations    bool   `json:"manualMigrations"`
+	DBTimeoutMS         int    `json:"dbTimeout"`
+	VaultTimeoutMS      int    `json:"vaultTimeout"`
+	RetryAttempts       int    `json:"retryAttempts"`
+	RetryBaseDelayMS    int    `json:"retryBaseDelay"`
+	RetryMaxDelayMS     int    `json:"retryMaxDelay"`
+	BreakerThreshold    int    `json:"breakerThreshold"`
+	BreakerCooldownMS   int    `json:"breakerCooldown"`
+	CacheBackend        string `json:"cache"`
+	CacheMaxEntries     int    `json:"cacheMaxEntries"`
+	RedisAddr           string `json:"redisAddr"`
+	RedisPassword       string `json:"redisPassword"`
+	RedisDB             int    `json:"redisDB"`
+	RedisPoolSize       int    `json:"redisPoolSize"`
+	TrinityJWKSURL      string `json:"jwksURL"`
+	TrinityIssuer       string `json:"trinityIssuer"`
+	TrinityAudience     string `json:"trinityAudience"`
+	AuthProvider        string `json:"authProvider"`
+	SessionKey          string `json:"sessionKey"`
+	SessionHours        int    `json:"sessionHours"`
+	APIKeyMaxDays       int    `json:"apiKeyMaxDays"`
+	//RateLimits Keyed by route (as registered, like "/api/ash/sharing/:id") or "*", replacing the built in limits for
+	//that route
+	RateLimits map[string]RateLimit `json:"rateLimits"`
+	//TrustedProxies Addresses (or CIDR ranges) of the reverse proxies in front of the service, the only peers whose
+	//X-Forwarded-For and X-Real-IP headers are believed
+	TrustedProxies []string `json:"trustedProxies"`
+
+	//OpenID Connect, used when AuthProvider is "oidc"
+	OIDCIssuer        string              `json:"oidcIssuer"`
+	OIDCClientID      string              `json:"oidcClientID"`
+	OIDCClientSecret  string              `json:"oidcClientSecret"`
+	OIDCRedirectURL   string              `json:"oidcRedirectURL"`
+	OIDCAudience      string              `json:"oidcAudience"`
+	OIDCUsernameClaim string              `json:"oidcUsernameClaim"`
+	OIDCGroupsClaim   string              `json:"oidcGroupsClaim"`
+	OIDCAdminGroups   []string            `json:"oidcAdminGroups"`
+	OIDCScopeClaim    string              `json:"oidcScopeClaim"`
+	OIDCScopeMap      map[string][]string `json:"oidcScopeMap"`
+}
+
+var (
+	//ErrTimeout A dependency (Mongo, Vault) didn't answer before the operation's deadline. Responds with a 504.
+	ErrTimeout = errors.New("timed out")
+	//ErrUnavailable A dependency couldn't be reached, or the request was abandoned before it was. Responds with a 503.
+	ErrUnavailable = errors.New("unavailable")
+	//ErrNotFound What was asked for doesn't exist (or isn't the caller's). Responds with a 404.
+	ErrNotFound = errors.New("not found")
+	//ErrConflict What was asked for clashes with something that already exists. Responds with a 409.
+	ErrConflict = errors.New("conflict")
+)
+
+//UpstreamError Says which dependency an ErrTimeout or ErrUnavailable came from.
+type UpstreamError struct {
+	Service string
+	Err     error
+}
+
+func (e UpstreamError) Error() string {
+	return e.Service + " " + e.Err.Error()
+}
+
+//Unwrap ...
+func (e UpstreamError) Unwrap() error {
+	return e.Err
 }
 
 var (
@@ -84,14 +159,14 @@ func CreateAPIRespFromObject(response interface{}, err error, failureCode int) A
 //WriteFailureResponse ..
 func WriteFailureResponse(err error, resp http.ResponseWriter, functionName string, status int) {
 	LogError("", err)
-	WriteAPIResponseStruct(resp, CreateAPIResponse("failed", err, status))
+	WriteAPIResponseStruct(resp, CreateAPIResponse("failed", err, StatusForError(err, status)))
 }
 
 //WriteResponse ...
 func WriteResponse(respWriter http.ResponseWriter, failureCode int, resp interface{}, err error) {
 	if err != nil {
 		LogError("", err)
-		WriteAPIResponseStruct(respWriter, CreateAPIResponse("failed", err, failureCode))
+		WriteAPIResponseStruct(respWriter, CreateAPIResponse("failed", err, StatusForError(err, failureCode)))
 	} else {
 		if resp == nil {
 			WriteAPIResponseStruct(respWriter, CreateAPIResponse("success", nil, 200))
@@ -104,7 +179,45 @@ func WriteResponse(respWriter http.ResponseWriter, failureCode int, resp interfa
 //CreateFailureResponse ...
 func CreateFailureResponse(err error, functionName string, status int) APIResponse {
 	LogError("", err)
-	return CreateAPIResponse("failed", err, status)
+	return CreateAPIResponse("failed", err, StatusForError(err, status))
+}
+
+//StatusForError Timeouts and unavailable dependencies get 504 and 503, missing and conflicting resources 404 and 409,
+//anything else gets the status the handler picked.
+func StatusForError(err error, status int) int {
+	if errors.Is(err, ErrTimeout) {
+		return http.StatusGatewayTimeout
+	} else if errors.Is(err, ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	} else if errors.Is(err, ErrNotFound) {
+		return http.StatusNotFound
+	} else if errors.Is(err, ErrConflict) {
+		return http.StatusConflict
+	}
+	return status
+}
+
+//WithTimeout Bounds a single call to a dependency by timeoutMS (or fallback if that isn't configured).
+func WithTimeout(ctx context.Context, timeoutMS int, fallback time.Duration) (context.Context, context.CancelFunc) {
+	timeout := fallback
+	if timeoutMS > 0 {
+		timeout = time.Duration(timeoutMS) * time.Millisecond
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+//ContextError Turns err into an UpstreamError if it happened because ctx ran out or was cancelled (ie. the client went
+//away), otherwise returns it as is.
+func ContextError(service string, ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return UpstreamError{Service: service, Err: ErrTimeout}
+	} else if ctx.Err() == context.Canceled || errors.Is(err, context.Canceled) {
+		return UpstreamError{Service: service, Err: ErrUnavailable}
+	}
+	return err
 }
 
 //CreateFailureResponseWithFields ...
@@ -120,7 +233,9 @@ func ValidateRequest(validator func(*http.Request) APIResponse, handler func(htt
 			WriteAPIResponseStruct(writer, CreateAPIResponse("", errors.New("request body empty"), 400))
 		} else {
 			if resp := validator(request); resp.Status == "success" {
-				handler(writer, request)
+				if identityAllowed(writer, request) {
+					handler(writer, request)
+				}
 			} else {
 				WriteAPIResponseStruct(writer, resp)
 			}
@@ -168,6 +283,7 @@ func BasicAuthRequired(validator func(string, string) (bool, error), handler fun
 //RequestWrapper ...
 func RequestWrapper(validator func(*http.Request) APIResponse, validMethod string, handler func(http.ResponseWriter, *http.Request)) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		request = request.WithContext(context.WithValue(request.Context(), requestMemoKey{}, &requestMemo{values: make(map[string]memoized)}))
 		if (validMethod != "") && (request.Method != validMethod) {
 			WriteAPIResponseStruct(writer, APIResponse{
 				Status:         "failed",
@@ -176,7 +292,9 @@ func RequestWrapper(validator func(*http.Request) APIResponse, validMethod strin
 			})
 		} else {
 			if resp := validator(request); resp.Status == "success" {
-				handler(writer, request)
+				if identityAllowed(writer, request) {
+					handler(writer, request)
+				}
 			} else {
 				WriteAPIResponseStruct(writer, resp)
 			}
@@ -184,6 +302,40 @@ func RequestWrapper(validator func(*http.Request) APIResponse, validMethod strin
 	})
 }
 
+type requestMemoKey struct{}
+
+type requestMemo struct {
+	lock   sync.Mutex
+	values map[string]memoized
+}
+
+type memoized struct {
+	value interface{}
+	err   error
+}
+
+//Memoize Returns what compute returned the first time it was called with key while handling r, so the validator and
+//the handler (or several helpers in the handler) can share work like resolving the caller's token. Requests that
+//didn't come through RequestWrapper aren't memoized, compute is called every time.
+func Memoize(r *http.Request, key string, compute func() (interface{}, error)) (interface{}, error) {
+	memo, ok := r.Context().Value(requestMemoKey{}).(*requestMemo)
+	if !ok {
+		return compute()
+	}
+	memo.lock.Lock()
+	result, done := memo.values[key]
+	memo.lock.Unlock()
+	if done {
+		return result.value, result.err
+	}
+
+	value, err := compute()
+	memo.lock.Lock()
+	memo.values[key] = memoized{value: value, err: err}
+	memo.lock.Unlock()
+	return value, err
+}
+
 //ValidateRequestMethod ...
 func ValidateRequestMethod(r *http.Request, validMethod string, writer http.ResponseWriter) bool {
 	if r.Method != validMethod {
@@ -197,6 +349,49 @@ func ValidateRequestMethod(r *http.Request, validMethod string, writer http.Resp
 		return true
 	}
 }
+
+//ClientIP Where the request came from: the peer's address, unless the peer is one of the TrustedProxies. Then it's
+//the right-most X-Forwarded-For hop that isn't a trusted proxy (the ones left of it could have been made up by the
+//client), or X-Real-IP if there's no such hop.
+func ClientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			if hop := strings.TrimSpace(hops[i]); hop != "" && !isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" && net.ParseIP(ip) != nil {
+		return ip
+	}
+	return peer
+}
+
+//isTrustedProxy Whether ip is (or is in) one of the TrustedProxies, which are addresses or CIDR ranges.
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range CurrentConfig.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
 func writeCommonHeaders(writer http.ResponseWriter) {
 	writer.Header().Add("Content-Type", "application/json")
 }
@@ -312,6 +507,11 @@ func CommonProcessInit(dev, loadConfig bool) {
 	}
 }
 
+//AllowedOrigins Origins the UI is allowed to make requests from.
+func AllowedOrigins() []string {
+	return []string{"https://notebook" + BaseURL, "http://notebookdev" + BaseURL, "http://192.168.1.12:4200", "http://localhost:4200"}
+}
+
 //UnsetVaultToken ...
 func UnsetVaultToken() {
 	CurrentConfig.VaultBaseAuthToken = ""
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+//ErrDisallowedAddress A user supplied URL points (or resolves) somewhere we won't send requests to, like loopback or
+//the private network Vault and Mongo live on.
+var ErrDisallowedAddress = errors.New("url resolves to an address that isn't allowed")
+
+//disallowedNetworks Everything that isn't the public internet: loopback, private, link-local (cloud metadata lives at
+//169.254.169.254), shared, multicast and reserved ranges.
+var disallowedNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
+
+//OutboundAddressAllowed Whether requests to URLs users gave us may go to ip.
+func OutboundAddressAllowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range disallowedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//NewOutboundHTTPClient An http.Client for URLs users gave us (webhooks, reminder targets). The address is checked on
+//every connection, after DNS resolution, so a name that resolves (or later re-resolves) to an internal address is
+//refused too. Redirects aren't followed and proxies from the environment aren't used, either could get around that.
+func NewOutboundHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !OutboundAddressAllowed(net.ParseIP(host)) {
+				return ErrDisallowedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//ValidateOutboundURL Checks a URL a user wants us to send requests to up front, so they're told when it's created
+//rather than finding out from failed deliveries. It has to be http(s) with a host that only resolves to allowed
+//addresses. NewOutboundHTTPClient checks again when it connects, as DNS can change in the meantime.
+func ValidateOutboundURL(ctx context.Context, raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return errors.New("url must be an absolute http(s) url")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil {
+		return errors.New("url host could not be resolved")
+	}
+	for _, addr := range addrs {
+		if !OutboundAddressAllowed(addr.IP) {
+			return ErrDisallowedAddress
+		}
+	}
+	return nil
+}
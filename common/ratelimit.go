@@ -0,0 +1,133 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	//RateLimitUser The request counts against the user whose token it was made with.
+	RateLimitUser = "user"
+	//RateLimitKey The request counts against the API key it was made with.
+	RateLimitKey = "key"
+
+	defaultRateLimitWindow = 60
+)
+
+//RateLimit How many requests a route allows each IP, user and API key per Window seconds. 0 means no limit.
+type RateLimit struct {
+	PerIP   int `json:"perIP"`
+	PerUser int `json:"perUser"`
+	PerKey  int `json:"perKey"`
+	Window  int `json:"window"`
+}
+
+//RateCounter Where request counts are kept, the cache is one.
+type RateCounter interface {
+	Increment(key, field string, expiresIn int) (int64, error)
+}
+
+//RateLimiter Counts requests in fixed windows per route. The IP bucket is checked before RequestWrapper's validator
+//runs, so guessing at tokens (or share links) is limited without costing a lookup per guess. The user and API key
+//buckets are checked once the validator has passed.
+type RateLimiter struct {
+	counter  RateCounter
+	fallback RateCounter
+	limits   map[string]RateLimit
+	identify func(*http.Request) (bucket, id string)
+}
+
+type rateLimitContextKey struct{}
+
+//limitedRoute What RequestWrapper needs to check the user and API key buckets.
+type limitedRoute struct {
+	limiter *RateLimiter
+	route   string
+	limit   RateLimit
+}
+
+//NewRateLimiter limits are keyed by route, with "*" for every route that doesn't have its own. fallback is used
+//whenever counter fails (like when Redis is down), so limits stay in force per instance. identify says which bucket
+//(RateLimitUser or RateLimitKey) a validated request counts against.
+func NewRateLimiter(counter, fallback RateCounter, limits map[string]RateLimit, identify func(*http.Request) (string, string)) *RateLimiter {
+	return &RateLimiter{counter: counter, fallback: fallback, limits: limits, identify: identify}
+}
+
+//Limit Rate limits handler with the limits for route.
+func (l *RateLimiter) Limit(route string, handler http.Handler) http.Handler {
+	limit, exists := l.limits[route]
+	if !exists {
+		limit = l.limits["*"]
+	}
+	if limit.Window <= 0 {
+		limit.Window = defaultRateLimitWindow
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if retryAfter, allowed := l.allow(route, "ip", ClientIP(request), limit.PerIP, limit.Window); !allowed {
+			writeTooManyRequests(writer, request, retryAfter)
+			return
+		}
+		if limit.PerUser > 0 || limit.PerKey > 0 {
+			request = request.WithContext(context.WithValue(request.Context(), rateLimitContextKey{}, &limitedRoute{limiter: l, route: route, limit: limit}))
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+//identityAllowed Checks the user or API key bucket of the route the request's for, writing a 429 if it's full.
+func identityAllowed(writer http.ResponseWriter, request *http.Request) bool {
+	if limited, ok := request.Context().Value(rateLimitContextKey{}).(*limitedRoute); ok {
+		if retryAfter, allowed := limited.checkIdentity(request); !allowed {
+			writeTooManyRequests(writer, request, retryAfter)
+			return false
+		}
+	}
+	return true
+}
+
+//checkIdentity Checks the user or API key bucket, once the request's been validated.
+func (route *limitedRoute) checkIdentity(request *http.Request) (int64, bool) {
+	bucket, id := route.limiter.identify(request)
+	if id == "" {
+		return 0, true
+	}
+	max := route.limit.PerUser
+	if bucket == RateLimitKey {
+		max = route.limit.PerKey
+	}
+	return route.limiter.allow(route.route, bucket, id, max, route.limit.Window)
+}
+
+//allow Counts the request against the bucket. Returns false, and how many seconds until the window resets, once
+//there have been more than max in this window.
+func (l *RateLimiter) allow(route, bucket, id string, max, window int) (int64, bool) {
+	if max <= 0 {
+		return 0, true
+	}
+	now := time.Now().Unix()
+	start := now - now%int64(window)
+	field := route + ":" + bucket + ":" + id + ":" + strconv.FormatInt(start, 10)
+	count, err := l.counter.Increment("ratelimit", field, window)
+	if err != nil {
+		if count, err = l.fallback.Increment("ratelimit", field, window); err != nil {
+			LogError("ratelimit", err)
+			return 0, true
+		}
+	}
+	if count > int64(max) {
+		return start + int64(window) - now, false
+	}
+	return 0, true
+}
+
+func writeTooManyRequests(writer http.ResponseWriter, request *http.Request, retryAfter int64) {
+	writer.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	if IsAPIv2(request) {
+		WriteAPIError(writer, NewAPIError(http.StatusTooManyRequests, ErrCodeTooManyRequests, "too many requests, try again later"))
+		return
+	}
+	WriteAPIResponseStruct(writer, CreateAPIResponse("failed", errors.New("too many requests, try again later"), http.StatusTooManyRequests))
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryAttempts    = 3
+	defaultRetryBaseDelay   = 50 * time.Millisecond
+	defaultRetryMaxDelay    = 2 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+//BreakerState ...
+type BreakerState string
+
+//The states a CircuitBreaker can be in.
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+//RetryPolicy How calls to a dependency (Mongo, Redis, Vault) get retried. Waits between attempts back off exponentially
+//from BaseDelay up to MaxDelay, with full jitter so a burst of failed calls doesn't all come back at once.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	//Retryable Whether err is the dependency's fault (network trouble, a write conflict, expired credentials) rather than
+	//the request's. Only those are retried, and only those count against the circuit breaker.
+	Retryable func(err error) bool
+}
+
+//NewRetryPolicy Returns a policy using the "retryAttempts", "retryBaseDelay" and "retryMaxDelay" config options.
+func NewRetryPolicy(retryable func(err error) bool) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: defaultRetryAttempts,
+		BaseDelay:   defaultRetryBaseDelay,
+		MaxDelay:    defaultRetryMaxDelay,
+		Retryable:   retryable,
+	}
+	if CurrentConfig.RetryAttempts > 0 {
+		policy.MaxAttempts = CurrentConfig.RetryAttempts
+	}
+	if CurrentConfig.RetryBaseDelayMS > 0 {
+		policy.BaseDelay = time.Duration(CurrentConfig.RetryBaseDelayMS) * time.Millisecond
+	}
+	if CurrentConfig.RetryMaxDelayMS > 0 {
+		policy.MaxDelay = time.Duration(CurrentConfig.RetryMaxDelayMS) * time.Millisecond
+	}
+	return policy
+}
+
+//Do Runs fn until it succeeds, fails with an error that isn't retryable, runs out of attempts or ctx is done. If
+//breaker is open fn isn't run at all and the breaker's error is returned instead.
+func (policy RetryPolicy) Do(ctx context.Context, breaker *CircuitBreaker, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if breaker != nil {
+			if err := breaker.Allow(); err != nil {
+				return err
+			}
+		}
+		err := fn()
+		failed := err != nil && (policy.Retryable(err) || ctx.Err() == context.DeadlineExceeded)
+		if breaker != nil {
+			breaker.Record(!failed)
+		}
+		if !failed || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay << uint(attempt-1)
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+//CircuitBreaker Stops calling a dependency once it's failed enough times in a row, failing fast with ErrUnavailable
+//instead of tying up handlers waiting on it. After the cooldown a single call is let through to see if it's back, if
+//that works the breaker closes again, otherwise it goes back to being open.
+type CircuitBreaker struct {
+	Name      string
+	threshold int
+	cooldown  time.Duration
+	lock      sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+var (
+	breakersLock sync.Mutex
+	breakers     []*CircuitBreaker
+)
+
+//NewCircuitBreaker Creates a breaker using the "breakerThreshold" and "breakerCooldown" config options and registers it
+//so it shows up in BreakerStates.
+func NewCircuitBreaker(name string) *CircuitBreaker {
+	breaker := &CircuitBreaker{Name: name, threshold: defaultBreakerThreshold, cooldown: defaultBreakerCooldown, state: BreakerClosed}
+	if CurrentConfig.BreakerThreshold > 0 {
+		breaker.threshold = CurrentConfig.BreakerThreshold
+	}
+	if CurrentConfig.BreakerCooldownMS > 0 {
+		breaker.cooldown = time.Duration(CurrentConfig.BreakerCooldownMS) * time.Millisecond
+	}
+	breakersLock.Lock()
+	breakers = append(breakers, breaker)
+	breakersLock.Unlock()
+	return breaker
+}
+
+//Allow Returns an UpstreamError wrapping ErrUnavailable if no calls should be made right now. Every call to Allow that
+//returns nil must be followed by a call to Record.
+func (b *CircuitBreaker) Allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return UpstreamError{Service: b.Name, Err: ErrUnavailable}
+		}
+		b.state, b.trial = BreakerHalfOpen, true
+	case BreakerHalfOpen:
+		if b.trial {
+			return UpstreamError{Service: b.Name, Err: ErrUnavailable}
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+//Record Tells the breaker how the call it allowed went.
+func (b *CircuitBreaker) Record(success bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+	if success {
+		if b.state != BreakerClosed {
+			LogInfo("breaker", b.Name, "dependency is back, closing circuit")
+		}
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		LogWarn("breaker", b.Name, "dependency is failing, opening circuit")
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+//State ...
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+//BreakerStates Returns the state of every breaker, keyed by name. Used by the health check.
+func BreakerStates() map[string]BreakerState {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	states := make(map[string]BreakerState, len(breakers))
+	for _, breaker := range breakers {
+		states[breaker.Name] = breaker.State()
+	}
+	return states
+}
@@ -1,9 +1,12 @@
 package crypto
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
+	"net"
 	"os"
 	"time"
 
@@ -19,8 +22,14 @@ const (
 	vaultDecryptEndpoint = "/transit/decrypt/"
 	vaultEncryptEndpoint = "/transit/encrypt/"
 	vaultDataKeyEndpoint = "/transit/datakey/plaintext/"
+
+	//defaultVaultTimeout How long a single Vault call gets when "vaultTimeout" isn't set.
+	defaultVaultTimeout = 10 * time.Second
 )
 
+//ErrKeyNotFound Returned by ReadKeyFromKV when there's nothing stored at the requested path.
+var ErrKeyNotFound = errors.New("not found")
+
 //VaultKMS ...
 type VaultKMS struct {
 	stopRefresh          bool
@@ -32,6 +41,8 @@ type VaultKMS struct {
 	serviceName          string
 	vaultKVPath          string
 	vaultDBCredsEndpoint string
+	retry                common.RetryPolicy
+	breaker              *common.CircuitBreaker
 }
 
 //Context extra info describing usage of a particular key. Useful in generating derived keys.
@@ -54,19 +65,19 @@ func NewVaultKMS(dev bool) *VaultKMS {
 			common.LogError("", err)
 		}
 	}
-	kms := VaultKMS{client: c, dev: dev}
+	kms := VaultKMS{client: c, dev: dev, retry: common.NewRetryPolicy(isRetryableVaultError), breaker: common.NewCircuitBreaker("vault")}
 	kms.setClientParameters()
 	kms.setAccessToken()
 	return &kms
 }
 
 //GenerateKey Generates a data key. Returns plaintext and encrypted (or "sealed") forms of the key. Or an error.
-func (kms *VaultKMS) GenerateKey(ctx Context) (key [32]byte, sealed []byte, e error) {
-	if bytes, err := json.Marshal(&ctx); err == nil {
+func (kms *VaultKMS) GenerateKey(ctx context.Context, keyContext Context) (key [32]byte, sealed []byte, e error) {
+	if bytes, err := json.Marshal(&keyContext); err == nil {
 		payload := map[string]interface{}{
 			"context": base64.StdEncoding.EncodeToString(bytes),
 		}
-		if newKey, err := kms.client.Logical().Write(vaultDataKeyEndpoint+kms.serviceName, payload); err == nil {
+		if newKey, err := kms.write(ctx, vaultDataKeyEndpoint+kms.serviceName, payload); err == nil {
 			sealedKey := newKey.Data["ciphertext"].(string)
 			if notsealed, err := base64.StdEncoding.DecodeString(newKey.Data["plaintext"].(string)); err != nil {
 				return key, sealed, err
@@ -84,9 +95,9 @@ func (kms *VaultKMS) GenerateKey(ctx Context) (key [32]byte, sealed []byte, e er
 }
 
 //GetDBCredentials Gets a username-password pair for the DB from Vault
-func (kms *VaultKMS) GetDBCredentials() (string, string, error) {
+func (kms *VaultKMS) GetDBCredentials(ctx context.Context) (string, string, error) {
 	common.LogDebug("", "", "GetDBCredentials")
-	if creds, err := kms.client.Logical().Read(kms.vaultDBCredsEndpoint); err == nil {
+	if creds, err := kms.read(ctx, kms.vaultDBCredsEndpoint); err == nil {
 		// kms.renewDBCreds(creds.LeaseID, time.Duration(creds.LeaseDuration-20)*time.Second)
 		// kms.dbCredsRenewer(credRenewer)
 		return creds.Data["username"].(string), creds.Data["password"].(string), nil
@@ -96,12 +107,12 @@ func (kms *VaultKMS) GetDBCredentials() (string, string, error) {
 }
 
 //Encrypt Encrypts a plainBlob using the key named in the global config. Returns a cipherBlob. Or an error
-func (kms *VaultKMS) Encrypt(plainBlob string) (string, error) {
+func (kms *VaultKMS) Encrypt(ctx context.Context, plainBlob string) (string, error) {
 	keyID := common.CurrentConfig.VaultKeyName
 	payload := map[string]interface{}{
 		"plaintext": base64.StdEncoding.EncodeToString([]byte(plainBlob)),
 	}
-	if ciphertext, err := kms.client.Logical().Write(vaultEncryptEndpoint+keyID, payload); err == nil {
+	if ciphertext, err := kms.write(ctx, vaultEncryptEndpoint+keyID, payload); err == nil {
 		return ciphertext.Data["ciphertext"].(string), nil
 	} else {
 		return "", err
@@ -109,12 +120,12 @@ func (kms *VaultKMS) Encrypt(plainBlob string) (string, error) {
 }
 
 //Decrypt Decrypts a given cipherBlob using the key named in the global config. Returns a plainBlob. Or an error
-func (kms *VaultKMS) Decrypt(cipherBlob string) ([]byte, error) {
+func (kms *VaultKMS) Decrypt(ctx context.Context, cipherBlob string) ([]byte, error) {
 	keyID := common.CurrentConfig.VaultKeyName
 	payload := map[string]interface{}{
 		"ciphertext": cipherBlob,
 	}
-	if plaintext, err := kms.client.Logical().Write(vaultDecryptEndpoint+keyID, payload); err == nil {
+	if plaintext, err := kms.write(ctx, vaultDecryptEndpoint+keyID, payload); err == nil {
 		return base64.StdEncoding.DecodeString(plaintext.Data["plaintext"].(string))
 	} else {
 		return nil, err
@@ -122,44 +133,44 @@ func (kms *VaultKMS) Decrypt(cipherBlob string) ([]byte, error) {
 }
 
 //WriteKeyToKVStorage Writes decryption key to the specified route in Vault
-func (kms *VaultKMS) WriteKeyToKVStorage(key, path string) error {
+func (kms *VaultKMS) WriteKeyToKVStorage(ctx context.Context, key, path string) error {
 	payload := map[string]interface{}{}
 	payload["key"] = key
-	if _, e := kms.client.Logical().Write(kms.vaultKVPath+"/"+vaultKVPrefix+"/"+path, payload); e != nil {
+	if _, e := kms.write(ctx, kms.vaultKVPath+"/"+vaultKVPrefix+"/"+path, payload); e != nil {
 		return e
 	}
 	return nil
 }
 
 //ReadKeyFromKV Read a decryption key stored at the specified path from Vault
-func (kms *VaultKMS) ReadKeyFromKV(path string) (string, error) {
-	if value, e := kms.client.Logical().Read(kms.vaultKVPath + "/" + vaultKVPrefix + "/" + path); e != nil {
+func (kms *VaultKMS) ReadKeyFromKV(ctx context.Context, path string) (string, error) {
+	if value, e := kms.read(ctx, kms.vaultKVPath+"/"+vaultKVPrefix+"/"+path); e != nil {
 		return "", e
 	} else {
 		if value != nil {
 			return value.Data["key"].(string), nil
 		} else {
-			return "", common.LogError("", errors.New("not found"))
+			return "", common.LogError("", ErrKeyNotFound)
 		}
 	}
 }
 
 //DeleteKeyFromKV Deletes a decryption key stored at the specified path
-func (kms *VaultKMS) DeleteKeyFromKV(path string) error {
-	if _, e := kms.client.Logical().Delete(kms.vaultKVPath + "/" + vaultKVPrefix + "/" + path); e != nil {
+func (kms *VaultKMS) DeleteKeyFromKV(ctx context.Context, path string) error {
+	if _, e := kms.delete(ctx, kms.vaultKVPath+"/"+vaultKVPrefix+"/"+path); e != nil {
 		return common.LogError("", e)
 	}
 	return nil
 }
 
 //UnsealKey Unseals the provided key using the provided master key. Returns plaintext key. Or an error.
-func (kms *VaultKMS) UnsealKey(sealedKey []byte, ctx Context) (key [32]byte, e error) {
-	if bytes, err := json.Marshal(&ctx); err == nil {
+func (kms *VaultKMS) UnsealKey(ctx context.Context, sealedKey []byte, keyContext Context) (key [32]byte, e error) {
+	if bytes, err := json.Marshal(&keyContext); err == nil {
 		payload := map[string]interface{}{
 			"ciphertext": string(sealedKey),
 			"context":    base64.StdEncoding.EncodeToString(bytes),
 		}
-		if unsealed, err := kms.client.Logical().Write(vaultDecryptEndpoint+kms.serviceName, payload); err == nil {
+		if unsealed, err := kms.write(ctx, vaultDecryptEndpoint+kms.serviceName, payload); err == nil {
 			base64Key := unsealed.Data["plaintext"].(string)
 			if plainKey, err := base64.StdEncoding.DecodeString(base64Key); err == nil {
 				copy(key[:], []byte(plainKey))
@@ -178,6 +189,63 @@ func (kms *VaultKMS) UnsealKey(sealedKey []byte, ctx Context) (key [32]byte, e e
 	}
 }
 
+//read, write and delete do the same as the client's Logical().Read/Write/Delete, but bounded by ctx and the
+//"vaultTimeout" config option.
+func (kms *VaultKMS) read(ctx context.Context, path string) (*vault.Secret, error) {
+	return kms.do(ctx, kms.client.NewRequest("GET", "/v1/"+path))
+}
+
+func (kms *VaultKMS) write(ctx context.Context, path string, data map[string]interface{}) (*vault.Secret, error) {
+	r := kms.client.NewRequest("PUT", "/v1/"+path)
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+	return kms.do(ctx, r)
+}
+
+func (kms *VaultKMS) delete(ctx context.Context, path string) (*vault.Secret, error) {
+	return kms.do(ctx, kms.client.NewRequest("DELETE", "/v1/"+path))
+}
+
+//do Sends r, retrying as the retry policy allows if Vault's unreachable or having problems of its own (5xx).
+func (kms *VaultKMS) do(ctx context.Context, r *vault.Request) (secret *vault.Secret, err error) {
+	ctx, cancel := common.WithTimeout(ctx, common.CurrentConfig.VaultTimeoutMS, defaultVaultTimeout)
+	defer cancel()
+	err = kms.retry.Do(ctx, kms.breaker, func() error {
+		secret, err = kms.send(ctx, r)
+		return err
+	})
+	return secret, common.ContextError("vault", ctx, err)
+}
+
+func (kms *VaultKMS) send(ctx context.Context, r *vault.Request) (*vault.Secret, error) {
+	resp, err := kms.client.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		//Same as the client, a 404 with nothing in it just means there's nothing at that path.
+		secret, parseErr := vault.ParseSecret(resp.Body)
+		if parseErr == io.EOF || (parseErr == nil && (secret == nil || (len(secret.Warnings) == 0 && len(secret.Data) == 0))) {
+			return nil, nil
+		} else if parseErr == nil {
+			return secret, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vault.ParseSecret(resp.Body)
+}
+
+func isRetryableVaultError(err error) bool {
+	if respErr, ok := err.(*vault.ResponseError); ok {
+		return respErr.StatusCode >= 500
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
 //RenewToken Renews a token
 func (kms *VaultKMS) RenewToken() *vault.Secret {
 	if s, e := kms.client.Auth().Token().RenewTokenAsSelf(kms.client.Token(), 600); e == nil {
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"errors"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	//maxAuditAppendAttempts Appends race other instances for the next Seq, the loser chains onto the winner's entry
+	//and tries again.
+	maxAuditAppendAttempts = 5
+	defaultAuditLimit      = 100
+)
+
+//AppendAuditEntry Chains the entry onto the end of the log and stores it, hashed with key (see AuditHash).
+func (data *MongoStore) AppendAuditEntry(ctx context.Context, entry AuditEntry, key []byte) (AuditEntry, error) {
+	audit := data.db.Collection("audit", nil)
+	for attempt := 0; attempt < maxAuditAppendAttempts; attempt++ {
+		var last AuditEntry
+		err := data.retryableQuery(ctx, func(ctx context.Context) error {
+			result := audit.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{"seq": -1}).SetProjection(bson.M{"_id": 0}))
+			if result.Err() == mongo.ErrNoDocuments {
+				return nil
+			} else if result.Err() != nil {
+				return result.Err()
+			}
+			return result.Decode(&last)
+		})
+		if err != nil {
+			return AuditEntry{}, common.LogError("", err)
+		}
+
+		chainAuditEntry(&entry, last, key)
+		err = data.retryableQuery(ctx, func(ctx context.Context) error {
+			_, err := audit.InsertOne(ctx, entry, &options.InsertOneOptions{})
+			return err
+		})
+		if isDuplicateKeyError(err) {
+			continue
+		}
+		return entry, common.LogError("", err)
+	}
+	return AuditEntry{}, common.LogError("", errors.New("gave up appending to the audit log"))
+}
+
+//GetAuditEntries ...
+func (data *MongoStore) GetAuditEntries(ctx context.Context, query AuditQuery) (entries []AuditEntry, err error) {
+	filter := bson.M{"seq": bson.M{"$gt": query.AfterSeq}}
+	if query.Actor != "" {
+		filter["actor"] = query.Actor
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.Resource != "" {
+		filter["resources"] = query.Resource
+	}
+	if query.Outcome != "" {
+		filter["outcome"] = query.Outcome
+	}
+	if query.Since != 0 || query.Until != 0 {
+		timestamp := bson.M{"$gte": query.Since}
+		if query.Until != 0 {
+			timestamp["$lte"] = query.Until
+		}
+		filter["timestamp"] = timestamp
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultAuditLimit
+	}
+
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		entries = nil
+		opts := options.Find().SetSort(bson.M{"seq": 1}).SetLimit(query.Limit).SetProjection(bson.M{"_id": 0})
+		r, err := data.db.Collection("audit", nil).Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &entries)
+	})
+	return entries, common.LogError("", err)
+}
@@ -0,0 +1,755 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sort"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBuckets = []string{"notebooks", "pages", "tags", "apikeys", "sharedpages", "reminders", "webhooks", "webhookdeliveries", "comments", "users", "twofactor", "audit"}
+
+//errStopScan Returned from an eachDoc callback to stop iterating early, it's never returned to the caller.
+var errStopScan = errors.New("stop scan")
+
+//BoltStore A DataStore kept in a single bbolt file, so nb_server can run without a database server. Documents are
+//gob encoded (so fields hidden from the API with json:"-" still get persisted) and keyed by id, with the exception
+//of api keys, which are keyed by hash since that's what every request looks them up by.
+type BoltStore struct {
+	db    *bolt.DB
+	cache CacheService
+}
+
+//NewBoltStore Opens (or creates) the database file at path.
+func NewBoltStore(path string, cache CacheService) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, common.LogError("", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return splitBoltNotebookPages(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, common.LogError("", err)
+	}
+	return &BoltStore{db: db, cache: cache}, nil
+}
+
+//Close ...
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+//NewNotebook ...
+func (b *BoltStore) NewNotebook(ctx context.Context, notebook Notebook) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		exists := false
+		err := eachDoc(tx, "notebooks", func(raw []byte) error {
+			var nb Notebook
+			if err := decodeDoc(raw, &nb); err != nil {
+				return err
+			}
+			if nb.Name == notebook.Name {
+				exists = true
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return storeError{ErrConflict, "this notebook already exists"}
+		}
+		notebook.Pages = nil
+		return putDoc(tx, "notebooks", notebook.ID, notebook)
+	}))
+}
+
+//GetUserNotebookNames ...
+func (b *BoltStore) GetUserNotebookNames(ctx context.Context, username string) (names []NotebookReference, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "notebooks", func(raw []byte) error {
+			var nb Notebook
+			if err := decodeDoc(raw, &nb); err != nil {
+				return err
+			}
+			if nb.Owner == username {
+				names = append(names, NotebookReference{Name: nb.Name, ID: nb.ID})
+			}
+			return nil
+		})
+	})
+	return names, common.LogError("", err)
+}
+
+//GetNotebookPages Returns the notebook's pages sorted and paged as described by query.
+func (b *BoltStore) GetNotebookPages(ctx context.Context, notebookID, creator string, query PageQuery) (PageList, error) {
+	var pages []Page
+	cursor, err := query.validate()
+	if err != nil {
+		return PageList{}, err
+	}
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		var nb Notebook
+		if found, err := getDoc(tx, "notebooks", notebookID, &nb); err != nil {
+			return err
+		} else if !found || nb.Owner != creator {
+			return ErrNotFound
+		}
+		pages, err = findPages(tx, func(p Page) bool { return p.NotebookID == notebookID })
+		return err
+	})
+	if err != nil {
+		return PageList{}, common.LogError("GetNotebookPages", err)
+	}
+
+	before := func(a, b Page) bool {
+		if query.SortBy == SortByLastEdited && a.LastEdited != b.LastEdited {
+			return (a.LastEdited < b.LastEdited) != query.Descending
+		} else if query.SortBy != SortByLastEdited && a.Title != b.Title {
+			return (a.Title < b.Title) != query.Descending
+		}
+		return a.ID != b.ID && (a.ID < b.ID) != query.Descending
+	}
+	sort.Slice(pages, func(i, j int) bool { return before(pages[i], pages[j]) })
+	if query.Cursor != "" {
+		last := Page{ID: cursor.ID, Title: cursor.Title, LastEdited: cursor.LastEdited}
+		start := sort.Search(len(pages), func(i int) bool { return before(last, pages[i]) })
+		pages = pages[start:]
+	}
+	if query.Limit > 0 && int64(len(pages)) > query.Limit+1 {
+		pages = pages[:query.Limit+1]
+	}
+	return PageList{Pages: pages}.trim(query.Limit), nil
+}
+
+//DeleteNotebook Deletes the notebook along with its pages and their reminders, comments and share links. Returns the
+//deleted pages.
+func (b *BoltStore) DeleteNotebook(ctx context.Context, id string) (pages []Page, err error) {
+	err = b.update(ctx, func(tx *bolt.Tx) error {
+		var nb Notebook
+		if found, err := getDoc(tx, "notebooks", id, &nb); err != nil {
+			return err
+		} else if !found {
+			return ErrNotFound
+		}
+		if pages, err = findPages(tx, func(p Page) bool { return p.NotebookID == id }); err != nil {
+			return err
+		}
+		if err := deleteSharedPagesWhere(tx, func(sp SharedPage) bool { return sp.NotebookID == id }); err != nil {
+			return err
+		}
+		if err := deleteRemindersWhere(tx, func(r Reminder) bool { return r.NotebookID == id }); err != nil {
+			return err
+		}
+		if err := deleteCommentsWhere(tx, func(c PageComment) bool { return c.NotebookID == id }); err != nil {
+			return err
+		}
+		for _, page := range pages {
+			if err := tx.Bucket([]byte("pages")).Delete([]byte(page.ID)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte("notebooks")).Delete([]byte(id))
+	})
+	if err != nil {
+		return nil, common.LogError("", err)
+	}
+	return pages, nil
+}
+
+//NewPage ...
+func (b *BoltStore) NewPage(ctx context.Context, page Page, notebookID string) error {
+	page.NotebookID = notebookID
+	page.LastEdited = common.UnixTimestampInMS()
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("notebooks")).Get([]byte(notebookID)) == nil {
+			return ErrNotFound
+		}
+		if tx.Bucket([]byte("pages")).Get([]byte(page.ID)) != nil {
+			return errors.New("not modified")
+		}
+		sameTitle, err := findPages(tx, func(p Page) bool { return p.NotebookID == notebookID && p.Title == page.Title })
+		if err != nil {
+			return common.LogError("", err)
+		} else if len(sameTitle) > 0 {
+			return errPageTitleTaken
+		}
+		return common.LogError("", putDoc(tx, "pages", page.ID, page))
+	})
+}
+
+//GetPageByID ...
+func (b *BoltStore) GetPageByID(ctx context.Context, pageID, notebookID string) (page Page, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "pages", pageID, &page); err != nil {
+			return err
+		} else if !found || (notebookID != "" && page.NotebookID != notebookID) {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return page, common.LogError("", err)
+}
+
+//GetPageCreator ...
+func (b *BoltStore) GetPageCreator(ctx context.Context, pageID string) (name string, err error) {
+	page, err := b.GetPageByID(ctx, pageID, "")
+	return page.Creator, err
+}
+
+//GetPagesWithTags Returns pagerefs of docs matching the 'tag(s)' specified
+func (b *BoltStore) GetPagesWithTags(ctx context.Context, tags []string, notebookID string) (pages []Page, err error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		pages, err = findPages(tx, func(p Page) bool { return p.NotebookID == notebookID && hasAllTags(p, tags) })
+		return err
+	})
+	return pages, common.LogError("", err)
+}
+
+//UpdatePage Replaces the page's metadata. Like NewPage, it won't give the page a title another page in the notebook has.
+func (b *BoltStore) UpdatePage(ctx context.Context, notebookID string, value Page) (bool, error) {
+	value.NotebookID = notebookID
+	value.LastEdited = common.UnixTimestampInMS()
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		var page Page
+		if found, err := getDoc(tx, "pages", value.ID, &page); err != nil {
+			return err
+		} else if !found || page.NotebookID != notebookID {
+			return ErrNotFound
+		}
+		sameTitle, err := findPages(tx, func(p Page) bool { return p.NotebookID == notebookID && p.Title == value.Title && p.ID != value.ID })
+		if err != nil {
+			return err
+		} else if len(sameTitle) > 0 {
+			return errPageTitleTaken
+		}
+		return putDoc(tx, "pages", value.ID, value)
+	})
+	return err == nil, common.LogError("", err)
+}
+
+//DeletePage Deletes the Page with the ID specified along with its reminders, its comments and any share links pointing
+//at it.
+func (b *BoltStore) DeletePage(ctx context.Context, pageID, notebookID string) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		var page Page
+		if found, err := getDoc(tx, "pages", pageID, &page); err != nil {
+			return err
+		} else if !found || page.NotebookID != notebookID {
+			return ErrNotFound
+		}
+		if err := tx.Bucket([]byte("pages")).Delete([]byte(pageID)); err != nil {
+			return err
+		}
+		if err := deleteRemindersWhere(tx, func(r Reminder) bool { return r.PageID == pageID }); err != nil {
+			return err
+		}
+		if err := deleteCommentsWhere(tx, func(c PageComment) bool { return c.PageID == pageID }); err != nil {
+			return err
+		}
+		return deleteSharedPagesWhere(tx, func(sp SharedPage) bool { return sp.PageID == pageID })
+	})
+}
+
+//NewTag ...
+func (b *BoltStore) NewTag(ctx context.Context, tag PageTag) (PageTag, error) {
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		exists := false
+		err := eachDoc(tx, "tags", func(raw []byte) error {
+			var t PageTag
+			if err := decodeDoc(raw, &t); err != nil {
+				return err
+			}
+			if t.TagValue == tag.TagValue {
+				exists = true
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return storeError{ErrConflict, "this tag already exists"}
+		}
+		return putDoc(tx, "tags", tag.TagID, tag)
+	})
+	if err != nil {
+		return PageTag{}, err
+	}
+	b.cache.DeleteString("notescache", "tags")
+	return tag, nil
+}
+
+//GetTags ...
+func (b *BoltStore) GetTags(ctx context.Context) (tags []PageTag, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "tags", func(raw []byte) error {
+			var tag PageTag
+			if err := decodeDoc(raw, &tag); err != nil {
+				return err
+			}
+			tags = append(tags, tag)
+			return nil
+		})
+	})
+	return tags, common.LogError("", err)
+}
+
+//IsValidTagID Returns true if the provided tag IDs both exist and were created by the provided username
+func (b *BoltStore) IsValidTagID(ctx context.Context, ids []string, username string) (valid bool, err error) {
+	if len(ids) == 0 {
+		return false, nil
+	}
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			var tag PageTag
+			if found, err := getDoc(tx, "tags", id, &tag); err != nil || !found || tag.Creator != username {
+				return err
+			}
+		}
+		valid = true
+		return nil
+	})
+	return valid, common.LogError("", err)
+}
+
+//DeleteTag Deletes a tag that has no assigned docs. If this function is called on with a tagid that is in active use an error is returned.
+func (b *BoltStore) DeleteTag(ctx context.Context, tagID string) error {
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		inUse, err := findPages(tx, func(p Page) bool { return hasAllTags(p, []string{tagID}) })
+		if err != nil {
+			return err
+		}
+		if len(inUse) > 0 {
+			return errors.New("this tag is still assigned to pages in a notebook")
+		}
+		return tx.Bucket([]byte("tags")).Delete([]byte(tagID))
+	})
+	if err == nil {
+		b.cache.DeleteString("notescache", "tags")
+	}
+	return err
+}
+
+//NewAPIKey ...
+func (b *BoltStore) NewAPIKey(ctx context.Context, keyRequest NewAPIKeyRequest) (string, error) {
+	apiKey, t, err := newAPIKey(keyRequest)
+	if err != nil {
+		return "", err
+	}
+	err = b.update(ctx, func(tx *bolt.Tx) error {
+		return putDoc(tx, "apikeys", apiKey.Hash, apiKey)
+	})
+	if err != nil {
+		return "", common.LogError("", err)
+	}
+	return t, nil
+}
+
+//GetAPIKey ...
+func (b *BoltStore) GetAPIKey(ctx context.Context, keyHash string) (key UserAPIKey, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "apikeys", keyHash, &key); err != nil {
+			return err
+		} else if !found {
+			return storeError{ErrNotFound, "no such api key"}
+		}
+		return nil
+	})
+	upgradeAPIKey(&key)
+	return key, err
+}
+
+//GetAPIKeys ...
+func (b *BoltStore) GetAPIKeys(ctx context.Context, username string) (keys []UserAPIKey, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "apikeys", func(raw []byte) error {
+			var apiKey UserAPIKey
+			if err := decodeDoc(raw, &apiKey); err != nil {
+				return err
+			}
+			if apiKey.Creator == username {
+				apiKey.Hash = ""
+				upgradeAPIKey(&apiKey)
+				keys = append(keys, apiKey)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, common.LogError("", err)
+	}
+	return keys, nil
+}
+
+//DeleteAPIKey ...
+func (b *BoltStore) DeleteAPIKey(ctx context.Context, id string) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		var hash []byte
+		err := tx.Bucket([]byte("apikeys")).ForEach(func(k, raw []byte) error {
+			var apiKey UserAPIKey
+			if err := decodeDoc(raw, &apiKey); err != nil {
+				return err
+			}
+			if apiKey.ID == id {
+				hash = append([]byte{}, k...)
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil && err != errStopScan {
+			return err
+		}
+		if hash == nil {
+			return errInvalidKeyID
+		}
+		return tx.Bucket([]byte("apikeys")).Delete(hash)
+	})
+}
+
+//RotateAPIKey Issues a replacement for one of creator's keys, with the same scopes and description. The old key stops
+//working at graceUntil, or when it was going to expire anyway if that's sooner. Keys that have already expired can't be
+//rotated.
+func (b *BoltStore) RotateAPIKey(ctx context.Context, id, creator string, keyRequest NewAPIKeyRequest, graceUntil int64) (key string, err error) {
+	err = b.update(ctx, func(tx *bolt.Tx) error {
+		var old UserAPIKey
+		err := eachDoc(tx, "apikeys", func(raw []byte) error {
+			var apiKey UserAPIKey
+			if err := decodeDoc(raw, &apiKey); err != nil {
+				return err
+			}
+			if apiKey.ID == id && apiKey.Creator == creator {
+				old = apiKey
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil && err != errStopScan {
+			return err
+		}
+		if old.ID == "" {
+			return errInvalidKeyID
+		} else if old.ExpiresAt != 0 && old.ExpiresAt <= common.UnixTimestampInMS() {
+			return errKeyExpired
+		}
+
+		upgradeAPIKey(&old)
+		keyRequest.Creator, keyRequest.Scopes, keyRequest.Constraints, keyRequest.Description = old.Creator, old.ScopeList, old.Constraints, old.Description
+		apiKey, t, err := newAPIKey(keyRequest)
+		if err != nil {
+			return err
+		}
+		if err := putDoc(tx, "apikeys", apiKey.Hash, apiKey); err != nil {
+			return err
+		}
+		if old.ExpiresAt == 0 || old.ExpiresAt > graceUntil {
+			old.ExpiresAt = graceUntil
+		}
+		key = t
+		return putDoc(tx, "apikeys", old.Hash, old)
+	})
+	return key, err
+}
+
+//RecordAPIKeyUsage Records a batch of key uses in one transaction. Older uses than the one already recorded are ignored.
+func (b *BoltStore) RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		for _, use := range usage {
+			var apiKey UserAPIKey
+			if found, err := getDoc(tx, "apikeys", use.Hash, &apiKey); err != nil {
+				return err
+			} else if !found || apiKey.LastUsedAt >= use.LastUsedAt {
+				continue
+			}
+			apiKey.LastUsedAt, apiKey.LastUsedIP = use.LastUsedAt, use.LastUsedIP
+			if err := putDoc(tx, "apikeys", use.Hash, apiKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+//NewSharedPage ...
+func (b *BoltStore) NewSharedPage(ctx context.Context, sharedPageReq SharePageRequest, username string) (SharedPage, error) {
+	sharedPageMD := newSharedPage(sharedPageReq, username)
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		shared := false
+		err := eachDoc(tx, "sharedpages", func(raw []byte) error {
+			var sp SharedPage
+			if err := decodeDoc(raw, &sp); err != nil {
+				return err
+			}
+			if sp.PageID == sharedPageReq.PageID {
+				shared = true
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			return common.LogError("", err)
+		}
+		if shared {
+			return errors.New("this page is already shared")
+		}
+		return common.LogError("", putDoc(tx, "sharedpages", sharedPageMD.ID, sharedPageMD))
+	})
+	if err != nil {
+		return SharedPage{}, err
+	}
+	return sharedPageMD, nil
+}
+
+//GetSharedPageInfo ...
+func (b *BoltStore) GetSharedPageInfo(ctx context.Context, accessToken string) (page SharedPage, err error) {
+	found := false
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "sharedpages", func(raw []byte) error {
+			var sp SharedPage
+			if err := decodeDoc(raw, &sp); err != nil {
+				return err
+			}
+			if sp.AccessToken == accessToken {
+				page, found = sp, true
+				return errStopScan
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return SharedPage{}, err
+	}
+	if !found {
+		return SharedPage{}, storeError{ErrNotFound, "no such shared page"}
+	}
+	return page, nil
+}
+
+//GetSharedPages ...
+func (b *BoltStore) GetSharedPages(ctx context.Context, username string) (pages []SharedPage, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "sharedpages", func(raw []byte) error {
+			var sp SharedPage
+			if err := decodeDoc(raw, &sp); err != nil {
+				return err
+			}
+			if sp.Owner == username {
+				pages = append(pages, sp)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return []SharedPage{}, err
+	}
+	return pages, nil
+}
+
+//DeleteSharedPage ...
+func (b *BoltStore) DeleteSharedPage(ctx context.Context, sharedPageID, username string) (bool, error) {
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		var sp SharedPage
+		if found, err := getDoc(tx, "sharedpages", sharedPageID, &sp); err != nil {
+			return err
+		} else if !found || sp.Owner != username {
+			return storeError{ErrNotFound, "no such shared page"}
+		}
+		return tx.Bucket([]byte("sharedpages")).Delete([]byte(sharedPageID))
+	})
+	return err == nil, common.LogError("", err)
+}
+
+//SetSharedPageComments Turns commenting visibility on or off for a shared page.
+func (b *BoltStore) SetSharedPageComments(ctx context.Context, sharedPageID, username string, allow bool) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		var sp SharedPage
+		if found, err := getDoc(tx, "sharedpages", sharedPageID, &sp); err != nil {
+			return err
+		} else if !found || sp.Owner != username {
+			return storeError{ErrNotFound, "no such shared page"}
+		}
+		sp.AllowComments = allow
+		return putDoc(tx, "sharedpages", sp.ID, sp)
+	}))
+}
+
+func deleteSharedPagesWhere(tx *bolt.Tx, match func(SharedPage) bool) error {
+	_, err := deleteDocsWhere(tx, "sharedpages", func(raw []byte) (bool, error) {
+		var sp SharedPage
+		if err := decodeDoc(raw, &sp); err != nil {
+			return false, err
+		}
+		return match(sp), nil
+	})
+	return err
+}
+
+func findPages(tx *bolt.Tx, match func(Page) bool) (pages []Page, err error) {
+	err = eachDoc(tx, "pages", func(raw []byte) error {
+		var p Page
+		if err := decodeDoc(raw, &p); err != nil {
+			return err
+		}
+		if match(p) {
+			pages = append(pages, p)
+		}
+		return nil
+	})
+	return pages, err
+}
+
+//splitBoltNotebookPages Moves pages embedded in notebook documents (the original layout) into the pages bucket.
+func splitBoltNotebookPages(tx *bolt.Tx) error {
+	if tx.Bucket([]byte("pageindex")) != nil {
+		if err := tx.DeleteBucket([]byte("pageindex")); err != nil {
+			return err
+		}
+	}
+	_, err := updateDocsWhere(tx, "notebooks", func(raw []byte) (interface{}, error) {
+		var nb Notebook
+		if err := decodeDoc(raw, &nb); err != nil {
+			return nil, err
+		}
+		if len(nb.Pages) == 0 {
+			return nil, nil
+		}
+		for _, page := range nb.Pages {
+			page.NotebookID = nb.ID
+			if err := putDoc(tx, "pages", page.ID, page); err != nil {
+				return nil, err
+			}
+		}
+		nb.Pages = nil
+		return nb, nil
+	})
+	return err
+}
+
+func hasAllTags(page Page, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, pageTag := range page.Tags {
+			if pageTag == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//update Runs fn in a read-write transaction, unless ctx is already done. bbolt can't abandon a transaction once it's
+//started, but they're local and quick, so checking up front is enough to stop work for requests that have gone away.
+func (b *BoltStore) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return common.ContextError("embedded db", ctx, err)
+	}
+	return b.db.Update(fn)
+}
+
+//view Same as update, for a read-only transaction.
+func (b *BoltStore) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return common.ContextError("embedded db", ctx, err)
+	}
+	return b.db.View(fn)
+}
+
+func getDoc(tx *bolt.Tx, bucket, id string, doc interface{}) (bool, error) {
+	raw := tx.Bucket([]byte(bucket)).Get([]byte(id))
+	if raw == nil {
+		return false, nil
+	}
+	return true, decodeDoc(raw, doc)
+}
+
+func putDoc(tx *bolt.Tx, bucket, id string, doc interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucket)).Put([]byte(id), buf.Bytes())
+}
+
+func decodeDoc(raw []byte, doc interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(doc)
+}
+
+//eachDoc Calls fn with every document in the bucket until it returns an error (errStopScan just stops the scan).
+func eachDoc(tx *bolt.Tx, bucket string, fn func(raw []byte) error) error {
+	err := tx.Bucket([]byte(bucket)).ForEach(func(_, raw []byte) error {
+		return fn(raw)
+	})
+	if err == errStopScan {
+		return nil
+	}
+	return err
+}
+
+//deleteDocsWhere Deletes every document in the bucket that match says to. Returns how many were deleted.
+func deleteDocsWhere(tx *bolt.Tx, bucket string, match func(raw []byte) (bool, error)) (int64, error) {
+	var ids [][]byte
+	err := tx.Bucket([]byte(bucket)).ForEach(func(k, raw []byte) error {
+		if matched, err := match(raw); err != nil {
+			return err
+		} else if matched {
+			ids = append(ids, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := tx.Bucket([]byte(bucket)).Delete(id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), nil
+}
+
+//updateDocsWhere Calls update with every document in the bucket, and writes back whatever it returns (nil leaves the
+//document alone). Returns how many were updated.
+func updateDocsWhere(tx *bolt.Tx, bucket string, update func(raw []byte) (interface{}, error)) (int64, error) {
+	updated := map[string]interface{}{}
+	err := tx.Bucket([]byte(bucket)).ForEach(func(k, raw []byte) error {
+		if doc, err := update(raw); err != nil {
+			return err
+		} else if doc != nil {
+			updated[string(k)] = doc
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for id, doc := range updated {
+		if err := putDoc(tx, bucket, id, doc); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(updated)), nil
+}
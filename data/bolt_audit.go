@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"encoding/binary"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//AppendAuditEntry Chains the entry onto the end of the log and stores it, hashed with key (see AuditHash). Entries are
+//keyed by Seq (big endian) so they're kept in order.
+func (b *BoltStore) AppendAuditEntry(ctx context.Context, entry AuditEntry, key []byte) (AuditEntry, error) {
+	err := b.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("audit"))
+		var last AuditEntry
+		if _, raw := bucket.Cursor().Last(); raw != nil {
+			if err := decodeDoc(raw, &last); err != nil {
+				return err
+			}
+		}
+		chainAuditEntry(&entry, last, key)
+		return putDoc(tx, "audit", auditKey(entry.Seq), entry)
+	})
+	if err != nil {
+		return AuditEntry{}, common.LogError("", err)
+	}
+	return entry, nil
+}
+
+//GetAuditEntries ...
+func (b *BoltStore) GetAuditEntries(ctx context.Context, query AuditQuery) (entries []AuditEntry, err error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultAuditLimit
+	}
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte("audit")).Cursor()
+		for _, raw := cursor.Seek([]byte(auditKey(query.AfterSeq + 1))); raw != nil && int64(len(entries)) < query.Limit; _, raw = cursor.Next() {
+			var entry AuditEntry
+			if err := decodeDoc(raw, &entry); err != nil {
+				return err
+			}
+			if auditEntryMatches(entry, query) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, common.LogError("", err)
+}
+
+func auditKey(seq int64) string {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(seq))
+	return string(key[:])
+}
+
+func auditEntryMatches(entry AuditEntry, query AuditQuery) bool {
+	return (query.Actor == "" || entry.Actor == query.Actor) &&
+		(query.Action == "" || entry.Action == query.Action) &&
+		(query.Resource == "" || common.Contains(entry.Resources, query.Resource)) &&
+		(query.Outcome == "" || entry.Outcome == query.Outcome) &&
+		entry.Timestamp >= query.Since &&
+		(query.Until == 0 || entry.Timestamp <= query.Until)
+}
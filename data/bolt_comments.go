@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"sort"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//NewComment ...
+func (b *BoltStore) NewComment(ctx context.Context, comment PageComment) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return putDoc(tx, "comments", comment.ID, comment)
+	}))
+}
+
+//GetComments Returns every comment on the specified page, oldest first.
+func (b *BoltStore) GetComments(ctx context.Context, pageID string) (comments []PageComment, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "comments", func(raw []byte) error {
+			var c PageComment
+			if err := decodeDoc(raw, &c); err != nil {
+				return err
+			}
+			if c.PageID == pageID {
+				comments = append(comments, c)
+			}
+			return nil
+		})
+	})
+	sort.Slice(comments, func(i, j int) bool { return comments[i].CreatedAt < comments[j].CreatedAt })
+	return comments, common.LogError("", err)
+}
+
+//GetComment ...
+func (b *BoltStore) GetComment(ctx context.Context, id, pageID string) (comment PageComment, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "comments", id, &comment); err != nil {
+			return err
+		} else if !found || comment.PageID != pageID {
+			return storeError{ErrNotFound, "no such comment"}
+		}
+		return nil
+	})
+	return comment, err
+}
+
+//UpdateCommentBody ...
+func (b *BoltStore) UpdateCommentBody(ctx context.Context, id, body string) error {
+	return common.LogError("", b.updateComment(ctx, id, func(c *PageComment) {
+		c.Body, c.EditedAt = body, common.UnixTimestampInMS()
+	}))
+}
+
+//ResolveCommentThread Marks the thread started by the comment with the specified id as (un)resolved.
+func (b *BoltStore) ResolveCommentThread(ctx context.Context, id string, resolved bool, username string) error {
+	if !resolved {
+		username = ""
+	}
+	return common.LogError("", b.updateComment(ctx, id, func(c *PageComment) {
+		c.Resolved, c.ResolvedBy = resolved, username
+	}))
+}
+
+//DeleteComment Deletes a comment, along with its replies if it started a thread.
+func (b *BoltStore) DeleteComment(ctx context.Context, id string) error {
+	return b.deleteComments(ctx, func(c PageComment) bool { return c.ID == id || c.ParentID == id })
+}
+
+func (b *BoltStore) updateComment(ctx context.Context, id string, update func(*PageComment)) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		var c PageComment
+		if found, err := getDoc(tx, "comments", id, &c); err != nil || !found {
+			return err
+		}
+		update(&c)
+		return putDoc(tx, "comments", c.ID, c)
+	})
+}
+
+func (b *BoltStore) deleteComments(ctx context.Context, match func(PageComment) bool) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return deleteCommentsWhere(tx, match)
+	}))
+}
+
+//deleteCommentsWhere Deletes the comments match picks as part of tx.
+func deleteCommentsWhere(tx *bolt.Tx, match func(PageComment) bool) error {
+	_, err := deleteDocsWhere(tx, "comments", func(raw []byte) (bool, error) {
+		var c PageComment
+		if err := decodeDoc(raw, &c); err != nil {
+			return false, err
+		}
+		return match(c), nil
+	})
+	return err
+}
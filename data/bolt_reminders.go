@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"sort"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//NewReminder ...
+func (b *BoltStore) NewReminder(ctx context.Context, reminder Reminder) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return putDoc(tx, "reminders", reminder.ID, reminder)
+	}))
+}
+
+//GetReminders Returns every reminder owned by username that hasn't been cancelled.
+func (b *BoltStore) GetReminders(ctx context.Context, username string) ([]Reminder, error) {
+	return b.findReminders(ctx, func(r Reminder) bool {
+		return r.Owner == username && r.Status != ReminderCancelled
+	})
+}
+
+//GetReminder ...
+func (b *BoltStore) GetReminder(ctx context.Context, id, username string) (reminder Reminder, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "reminders", id, &reminder); err != nil {
+			return err
+		} else if !found || reminder.Owner != username {
+			return storeError{ErrNotFound, "no such reminder"}
+		}
+		return nil
+	})
+	return reminder, err
+}
+
+//GetDueReminders Returns pending reminders that are scheduled to fire at or before the provided time (unix ms).
+func (b *BoltStore) GetDueReminders(ctx context.Context, before int64) ([]Reminder, error) {
+	return b.findReminders(ctx, func(r Reminder) bool {
+		return r.Status == ReminderPending && r.FireAt <= before
+	})
+}
+
+//ClaimReminder Moves a pending reminder into the sending state. Returns false if some other scheduler got to it first.
+func (b *BoltStore) ClaimReminder(ctx context.Context, id string) (claimed bool, err error) {
+	err = b.updateReminder(ctx, id, func(r *Reminder) bool {
+		if r.Status != ReminderPending {
+			return false
+		}
+		r.Status = ReminderSending
+		r.ClaimedAt = common.UnixTimestampInMS()
+		claimed = true
+		return true
+	})
+	return claimed, common.LogError("", err)
+}
+
+//FinishReminder Records the outcome of an attempt to fire a reminder. A pending status with a new fireAt reschedules it.
+func (b *BoltStore) FinishReminder(ctx context.Context, id string, status ReminderStatus, attempts int, fireAt int64) error {
+	return common.LogError("", b.updateReminder(ctx, id, func(r *Reminder) bool {
+		r.Status, r.Attempts, r.FireAt, r.ClaimedAt = status, attempts, fireAt, 0
+		return true
+	}))
+}
+
+//ReleaseStuckReminders Returns reminders that were claimed before "claimedBefore" but never finished (ie. the process
+//died mid-send) to the pending state so they get picked up again.
+func (b *BoltStore) ReleaseStuckReminders(ctx context.Context, claimedBefore int64) (released int64, err error) {
+	err = b.update(ctx, func(tx *bolt.Tx) error {
+		released, err = updateDocsWhere(tx, "reminders", func(raw []byte) (interface{}, error) {
+			var r Reminder
+			if err := decodeDoc(raw, &r); err != nil {
+				return nil, err
+			}
+			if r.Status != ReminderSending || r.ClaimedAt >= claimedBefore {
+				return nil, nil
+			}
+			r.Status, r.ClaimedAt = ReminderPending, 0
+			return r, nil
+		})
+		return err
+	})
+	return released, common.LogError("", err)
+}
+
+//SnoozeReminder Pushes a reminder's fire time back to fireAt and makes it pending again.
+func (b *BoltStore) SnoozeReminder(ctx context.Context, id, username string, fireAt int64) (updated bool, err error) {
+	err = b.updateReminder(ctx, id, func(r *Reminder) bool {
+		if r.Owner != username || r.Status == ReminderCancelled || r.Status == ReminderSending {
+			return false
+		}
+		r.Status, r.FireAt, r.Attempts = ReminderPending, fireAt, 0
+		updated = true
+		return true
+	})
+	return updated, common.LogError("", err)
+}
+
+//CancelReminder ...
+func (b *BoltStore) CancelReminder(ctx context.Context, id, username string) (updated bool, err error) {
+	err = b.updateReminder(ctx, id, func(r *Reminder) bool {
+		if r.Owner != username || r.Status == ReminderCancelled {
+			return false
+		}
+		r.Status = ReminderCancelled
+		updated = true
+		return true
+	})
+	return updated, common.LogError("", err)
+}
+
+//findReminders Returns the reminders match picks, soonest first.
+func (b *BoltStore) findReminders(ctx context.Context, match func(Reminder) bool) (reminders []Reminder, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "reminders", func(raw []byte) error {
+			var r Reminder
+			if err := decodeDoc(raw, &r); err != nil {
+				return err
+			}
+			if match(r) {
+				reminders = append(reminders, r)
+			}
+			return nil
+		})
+	})
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].FireAt < reminders[j].FireAt })
+	return reminders, common.LogError("", err)
+}
+
+//updateReminder Applies update to the reminder with the specified id, it's only written back if update returns true.
+//A missing reminder isn't an error, same as an update that matches nothing in Mongo.
+func (b *BoltStore) updateReminder(ctx context.Context, id string, update func(*Reminder) bool) error {
+	return b.update(ctx, func(tx *bolt.Tx) error {
+		var r Reminder
+		if found, err := getDoc(tx, "reminders", id, &r); err != nil || !found {
+			return err
+		}
+		if !update(&r) {
+			return nil
+		}
+		return putDoc(tx, "reminders", r.ID, r)
+	})
+}
+
+//deleteRemindersWhere Deletes the reminders match picks as part of tx, like a page or notebook being deleted.
+func deleteRemindersWhere(tx *bolt.Tx, match func(Reminder) bool) error {
+	_, err := deleteDocsWhere(tx, "reminders", func(raw []byte) (bool, error) {
+		var r Reminder
+		if err := decodeDoc(raw, &r); err != nil {
+			return false, err
+		}
+		return match(r), nil
+	})
+	return err
+}
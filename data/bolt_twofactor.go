@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//SaveTwoFactor Creates or replaces the user's enrollment.
+func (b *BoltStore) SaveTwoFactor(ctx context.Context, twoFactor TwoFactor) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return putDoc(tx, "twofactor", twoFactor.Username, twoFactor)
+	}))
+}
+
+//GetTwoFactor Returns false if the user has never enrolled.
+func (b *BoltStore) GetTwoFactor(ctx context.Context, username string) (twoFactor TwoFactor, found bool, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		found, err = getDoc(tx, "twofactor", username, &twoFactor)
+		return err
+	})
+	return twoFactor, found, common.LogError("", err)
+}
+
+//AdvanceTwoFactorStep Records that a code for step was used. Returns false if one for step (or a later one) already
+//was.
+func (b *BoltStore) AdvanceTwoFactorStep(ctx context.Context, username string, step int64) (advanced bool, err error) {
+	err = b.updateTwoFactor(ctx, username, func(twoFactor *TwoFactor) bool {
+		if twoFactor.LastStep >= step {
+			return false
+		}
+		twoFactor.LastStep, advanced = step, true
+		return true
+	})
+	return advanced, err
+}
+
+//UseRecoveryCode Removes the recovery code with the given hash. Returns false if the user doesn't have it.
+func (b *BoltStore) UseRecoveryCode(ctx context.Context, username, codeHash string) (used bool, err error) {
+	err = b.updateTwoFactor(ctx, username, func(twoFactor *TwoFactor) bool {
+		for i, code := range twoFactor.RecoveryCodes {
+			if code == codeHash {
+				twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i], twoFactor.RecoveryCodes[i+1:]...)
+				used = true
+				return true
+			}
+		}
+		return false
+	})
+	return used, err
+}
+
+//DeleteTwoFactor ...
+func (b *BoltStore) DeleteTwoFactor(ctx context.Context, username string) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("twofactor")).Delete([]byte(username))
+	}))
+}
+
+//updateTwoFactor Writes back the user's enrollment if update returns true. A user that never enrolled is left alone.
+func (b *BoltStore) updateTwoFactor(ctx context.Context, username string, update func(*TwoFactor) bool) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		var twoFactor TwoFactor
+		if found, err := getDoc(tx, "twofactor", username, &twoFactor); err != nil || !found {
+			return err
+		}
+		if !update(&twoFactor) {
+			return nil
+		}
+		return putDoc(tx, "twofactor", username, twoFactor)
+	}))
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//NewUser ...
+func (b *BoltStore) NewUser(ctx context.Context, user User) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("users")).Get([]byte(user.Username)) != nil {
+			return storeError{ErrConflict, "this user already exists"}
+		}
+		return putDoc(tx, "users", user.Username, user)
+	}))
+}
+
+//GetUser ...
+func (b *BoltStore) GetUser(ctx context.Context, username string) (user User, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "users", username, &user); err != nil {
+			return err
+		} else if !found {
+			return storeError{ErrNotFound, "no such user"}
+		}
+		return nil
+	})
+	return user, err
+}
+
+//SetUserPassword Replaces the user's password hash, changedAt (unix ms) invalidates every session issued before it.
+func (b *BoltStore) SetUserPassword(ctx context.Context, username, passHash string, changedAt int64) error {
+	return b.updateUser(ctx, username, func(user *User) {
+		user.PassHash, user.PasswordChangedAt = passHash, changedAt
+	})
+}
+
+//SetUserDisabled ...
+func (b *BoltStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return b.updateUser(ctx, username, func(user *User) {
+		user.Disabled = disabled
+	})
+}
+
+func (b *BoltStore) updateUser(ctx context.Context, username string, update func(*User)) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		var user User
+		if found, err := getDoc(tx, "users", username, &user); err != nil {
+			return err
+		} else if !found {
+			return storeError{ErrNotFound, "no such user"}
+		}
+		update(&user)
+		return putDoc(tx, "users", username, user)
+	}))
+}
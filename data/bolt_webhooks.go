@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"sort"
+
+	"go.alargerobot.dev/notebook/common"
+	bolt "go.etcd.io/bbolt"
+)
+
+//NewWebhook ...
+func (b *BoltStore) NewWebhook(ctx context.Context, hook WebhookSubscription) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		exists := false
+		err := eachDoc(tx, "webhooks", func(raw []byte) error {
+			var h WebhookSubscription
+			if err := decodeDoc(raw, &h); err != nil {
+				return err
+			}
+			if h.Owner == hook.Owner && h.URL == hook.URL {
+				exists = true
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if exists {
+			return storeError{ErrConflict, "a webhook for this url already exists"}
+		}
+		return putDoc(tx, "webhooks", hook.ID, hook)
+	}))
+}
+
+//GetWebhooks ...
+func (b *BoltStore) GetWebhooks(ctx context.Context, username string) ([]WebhookSubscription, error) {
+	return b.findWebhooks(ctx, func(h WebhookSubscription) bool { return h.Owner == username })
+}
+
+//GetWebhooksForEvent Returns the subscriptions owned by username that want to hear about event.
+func (b *BoltStore) GetWebhooksForEvent(ctx context.Context, username, event string) ([]WebhookSubscription, error) {
+	return b.findWebhooks(ctx, func(h WebhookSubscription) bool {
+		if h.Owner != username {
+			return false
+		}
+		for _, e := range h.Events {
+			if e == event {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+//GetWebhook ...
+func (b *BoltStore) GetWebhook(ctx context.Context, id string) (hook WebhookSubscription, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		if found, err := getDoc(tx, "webhooks", id, &hook); err != nil {
+			return err
+		} else if !found {
+			return storeError{ErrNotFound, "no such webhook"}
+		}
+		return nil
+	})
+	return hook, err
+}
+
+//DeleteWebhook Deletes the subscription and its delivery log.
+func (b *BoltStore) DeleteWebhook(ctx context.Context, id, username string) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		var hook WebhookSubscription
+		if found, err := getDoc(tx, "webhooks", id, &hook); err != nil {
+			return err
+		} else if !found || hook.Owner != username {
+			return storeError{ErrNotFound, "no such webhook"}
+		}
+		if err := tx.Bucket([]byte("webhooks")).Delete([]byte(id)); err != nil {
+			return err
+		}
+		_, err := deleteDocsWhere(tx, "webhookdeliveries", func(raw []byte) (bool, error) {
+			var d WebhookDelivery
+			if err := decodeDoc(raw, &d); err != nil {
+				return false, err
+			}
+			return d.SubscriptionID == id, nil
+		})
+		return err
+	}))
+}
+
+//NewWebhookDelivery ...
+func (b *BoltStore) NewWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		return putDoc(tx, "webhookdeliveries", delivery.ID, delivery)
+	}))
+}
+
+//GetWebhookDeliveries Returns the most recent deliveries (newest first) for the specified subscription.
+func (b *BoltStore) GetWebhookDeliveries(ctx context.Context, subscriptionID, username string, limit int64) ([]WebhookDelivery, error) {
+	deliveries, err := b.findDeliveries(ctx, func(d WebhookDelivery) bool {
+		return d.SubscriptionID == subscriptionID && d.Owner == username
+	})
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt > deliveries[j].CreatedAt })
+	if limit > 0 && int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, err
+}
+
+//GetDueWebhookDeliveries Returns pending deliveries whose next attempt is at or before the provided time (unix ms).
+func (b *BoltStore) GetDueWebhookDeliveries(ctx context.Context, before int64) ([]WebhookDelivery, error) {
+	deliveries, err := b.findDeliveries(ctx, func(d WebhookDelivery) bool {
+		return d.Status == DeliveryPending && d.NextAttempt <= before
+	})
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttempt < deliveries[j].NextAttempt })
+	return deliveries, err
+}
+
+//ClaimWebhookDelivery Moves a pending delivery into the sending state. Returns false if it was already claimed.
+func (b *BoltStore) ClaimWebhookDelivery(ctx context.Context, id string) (claimed bool, err error) {
+	err = b.update(ctx, func(tx *bolt.Tx) error {
+		var d WebhookDelivery
+		if found, err := getDoc(tx, "webhookdeliveries", id, &d); err != nil || !found || d.Status != DeliveryPending {
+			return err
+		}
+		d.Status, d.ClaimedAt = DeliverySending, common.UnixTimestampInMS()
+		claimed = true
+		return putDoc(tx, "webhookdeliveries", d.ID, d)
+	})
+	if err != nil {
+		claimed = false
+	}
+	return claimed, common.LogError("", err)
+}
+
+//FinishWebhookDelivery Records the outcome of a delivery attempt.
+func (b *BoltStore) FinishWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		var d WebhookDelivery
+		if found, err := getDoc(tx, "webhookdeliveries", delivery.ID, &d); err != nil || !found {
+			return err
+		}
+		d.Status = delivery.Status
+		d.Attempts = delivery.Attempts
+		d.ResponseCode = delivery.ResponseCode
+		d.LastError = delivery.LastError
+		d.NextAttempt = delivery.NextAttempt
+		d.ClaimedAt = 0
+		return putDoc(tx, "webhookdeliveries", d.ID, d)
+	}))
+}
+
+//ReleaseStuckWebhookDeliveries Returns deliveries claimed before claimedBefore that never finished to the pending state.
+func (b *BoltStore) ReleaseStuckWebhookDeliveries(ctx context.Context, claimedBefore int64) error {
+	return common.LogError("", b.update(ctx, func(tx *bolt.Tx) error {
+		_, err := updateDocsWhere(tx, "webhookdeliveries", func(raw []byte) (interface{}, error) {
+			var d WebhookDelivery
+			if err := decodeDoc(raw, &d); err != nil {
+				return nil, err
+			}
+			if d.Status != DeliverySending || d.ClaimedAt >= claimedBefore {
+				return nil, nil
+			}
+			d.Status, d.ClaimedAt = DeliveryPending, 0
+			return d, nil
+		})
+		return err
+	}))
+}
+
+func (b *BoltStore) findWebhooks(ctx context.Context, match func(WebhookSubscription) bool) (hooks []WebhookSubscription, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "webhooks", func(raw []byte) error {
+			var h WebhookSubscription
+			if err := decodeDoc(raw, &h); err != nil {
+				return err
+			}
+			if match(h) {
+				hooks = append(hooks, h)
+			}
+			return nil
+		})
+	})
+	return hooks, common.LogError("", err)
+}
+
+func (b *BoltStore) findDeliveries(ctx context.Context, match func(WebhookDelivery) bool) (deliveries []WebhookDelivery, err error) {
+	err = b.view(ctx, func(tx *bolt.Tx) error {
+		return eachDoc(tx, "webhookdeliveries", func(raw []byte) error {
+			var d WebhookDelivery
+			if err := decodeDoc(raw, &d); err != nil {
+				return err
+			}
+			if match(d) {
+				deliveries = append(deliveries, d)
+			}
+			return nil
+		})
+	})
+	return deliveries, common.LogError("", err)
+}
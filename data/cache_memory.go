@@ -0,0 +1,333 @@
+package data
+
+import (
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+)
+
+//MemoryCache A CacheService that keeps everything in process. Keys are composed the same way RedisCache composes them
+//and expire the same way, the least recently used ones are evicted once there are more than maxEntries. Published
+//messages only reach subscribers in the same process.
+type MemoryCache struct {
+	lock        sync.Mutex
+	maxEntries  int
+	entries     map[string]*list.Element
+	lru         *list.List
+	subLock     sync.RWMutex
+	subscribers map[string]map[*func(string)]bool
+}
+
+type memoryEntry struct {
+	key       string
+	value     string
+	set       map[string]bool
+	sortedSet map[string]float64
+	expiresAt time.Time
+}
+
+//NewMemoryCache ...
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries:  maxEntries,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		subscribers: make(map[string]map[*func(string)]bool),
+	}
+}
+
+//get Returns the live entry for key, marking it as recently used. Must be called with the lock held.
+func (c *MemoryCache) get(key string) *memoryEntry {
+	element, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+//put Stores entry under its key, replacing whatever was there and evicting the least recently used entries if the
+//cache is full. Must be called with the lock held.
+func (c *MemoryCache) put(entry *memoryEntry) {
+	if element, exists := c.entries[entry.key]; exists {
+		c.remove(element)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*memoryEntry).key)
+}
+
+func (c *MemoryCache) putString(key, value string, expiresIn int) {
+	entry := &memoryEntry{key: key, value: value}
+	if expiresIn > 0 {
+		entry.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	c.lock.Lock()
+	c.put(entry)
+	c.lock.Unlock()
+}
+
+func (c *MemoryCache) addToSet(key, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key)
+	if entry == nil {
+		entry = &memoryEntry{key: key, set: make(map[string]bool)}
+		c.put(entry)
+	} else if entry.set == nil {
+		common.LogError("AddStringToSet", errors.New("key holds a value that isn't a set"))
+		return
+	}
+	entry.set[value] = true
+}
+
+//PutString Caches a string with the given key+field
+func (c *MemoryCache) PutString(key, field, value string) {
+	c.putString(key+":"+field, value, 0)
+}
+
+//PutObject Caches an object (as a string) with the given key+field
+func (c *MemoryCache) PutObject(key, field string, value interface{}) {
+	newValue, _ := json.Marshal(value)
+	c.putString(key+":"+field, string(newValue), 0)
+}
+
+//PutStringWithExpiration Caches a string with the given key+field that expires in expiresIn seconds.
+func (c *MemoryCache) PutStringWithExpiration(key, field, value string, expiresIn int) {
+	c.putString(key+":"+field, value, expiresIn)
+}
+
+//AddStringToSet Adds a string to a set with the given key+field
+func (c *MemoryCache) AddStringToSet(setname, key, value string) {
+	c.addToSet(setname+":"+key, value)
+}
+
+//AddStringToSortedSet Adds a string to a sorted set with given setname+key, value and score. Like ZADD NX the score of
+//a value that's already in the set is left alone.
+func (c *MemoryCache) AddStringToSortedSet(setname, key, value, score string) {
+	parsedScore, err := strconv.ParseFloat(score, 64)
+	if err != nil {
+		common.LogError("AddStringToSortedSet", err)
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(setname + ":" + key)
+	if entry == nil {
+		entry = &memoryEntry{key: setname + ":" + key, sortedSet: make(map[string]float64)}
+		c.put(entry)
+	} else if entry.sortedSet == nil {
+		common.LogError("AddStringToSortedSet", errors.New("key holds a value that isn't a sorted set"))
+		return
+	}
+	if _, exists := entry.sortedSet[value]; !exists {
+		entry.sortedSet[value] = parsedScore
+	}
+}
+
+//AddStringToGlobalSet Add a string to a "global" set (ie one that's not specific to a user) with the given key
+func (c *MemoryCache) AddStringToGlobalSet(key, value string) {
+	c.addToSet(key, value)
+}
+
+//SetTTLOnKey Sets a TTL in seconds (time-to-live) on the given key+field
+func (c *MemoryCache) SetTTLOnKey(key, field string, ttl int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if entry := c.get(key + ":" + field); entry != nil {
+		entry.expiresAt = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+}
+
+//GetString Returns a cached string with the given key+field
+func (c *MemoryCache) GetString(key, field string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if entry := c.get(key + ":" + field); entry != nil && entry.set == nil && entry.sortedSet == nil {
+		return entry.value
+	}
+	return ""
+}
+
+//Increment Adds one to the counter with the given key+field and returns its new value. A new counter expires in
+//expiresIn seconds.
+func (c *MemoryCache) Increment(key, field string, expiresIn int) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key + ":" + field)
+	if entry == nil {
+		entry = &memoryEntry{key: key + ":" + field, value: "0"}
+		if expiresIn > 0 {
+			entry.expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+		}
+		c.put(entry)
+	} else if entry.set != nil || entry.sortedSet != nil {
+		return 0, errors.New("key holds a value that isn't a counter")
+	}
+	count, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	count++
+	entry.value = strconv.FormatInt(count, 10)
+	return count, nil
+}
+
+//GetInt Returns a cached Int (stored as a string) with the given key+field
+func (c *MemoryCache) GetInt(key string, field int) (int, error) {
+	value, err := strconv.Atoi(c.GetString(key, strconv.Itoa(field)))
+	if err != nil {
+		return 0, common.LogError("", err)
+	}
+	return value, nil
+}
+
+//GetSet ...
+func (c *MemoryCache) GetSet(key, field string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key + ":" + field)
+	if entry == nil || entry.set == nil {
+		return nil
+	}
+	set := make([]string, 0, len(entry.set))
+	for member := range entry.set {
+		set = append(set, member)
+	}
+	sort.Strings(set)
+	return set
+}
+
+//GetSortedSet Returns the members of the sorted set lowest score first, members with the same score are in
+//lexicographical order.
+func (c *MemoryCache) GetSortedSet(key, field string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key + ":" + field)
+	if entry == nil || entry.sortedSet == nil {
+		return nil
+	}
+	set := make([]string, 0, len(entry.sortedSet))
+	for member := range entry.sortedSet {
+		set = append(set, member)
+	}
+	sort.Slice(set, func(i, j int) bool {
+		if entry.sortedSet[set[i]] != entry.sortedSet[set[j]] {
+			return entry.sortedSet[set[i]] < entry.sortedSet[set[j]]
+		}
+		return set[i] < set[j]
+	})
+	return set
+}
+
+//IsInSet Whether member is in the set with the given key+field
+func (c *MemoryCache) IsInSet(key, field, member string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key + ":" + field)
+	return entry != nil && entry.set[member]
+}
+
+//DeleteString ...
+func (c *MemoryCache) DeleteString(key, field string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, exists := c.entries[key+":"+field]; exists {
+		c.remove(element)
+	}
+}
+
+//DeleteInt ...
+func (c *MemoryCache) DeleteInt(key string, field int) {
+	c.DeleteString(key, strconv.Itoa(field))
+}
+
+//DeleteSetItem ...
+func (c *MemoryCache) DeleteSetItem(setname, key, member string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := c.get(key + ":" + setname)
+	if entry == nil || entry.set == nil {
+		return
+	}
+	delete(entry.set, member)
+	if len(entry.set) == 0 {
+		c.remove(c.entries[entry.key])
+	}
+}
+
+//CacheQuery Caches a query's result for expiresIn seconds, keyed by the hash of cacheKey (which should say
+//everything the result depends on).
+func (c *MemoryCache) CacheQuery(cacheKey, result string, expiresIn int) {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	c.PutStringWithExpiration("dcgqlcache", hex.EncodeToString(hash), result, expiresIn)
+}
+
+//GetCachedQuery The result cached by CacheQuery for cacheKey, empty if there isn't one.
+func (c *MemoryCache) GetCachedQuery(cacheKey string) string {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	return c.GetString("dcgqlcache", hex.EncodeToString(hash))
+}
+
+//DeleteCachedQuery ...
+func (c *MemoryCache) DeleteCachedQuery(cacheKey string) {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	c.DeleteString("dcgqlcache", hex.EncodeToString(hash))
+}
+
+//DoesKeyExist ...
+func (c *MemoryCache) DoesKeyExist(key, field string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.get(key+":"+field) != nil
+}
+
+//Publish Calls the handler of every subscriber to the given channel in this process with message
+func (c *MemoryCache) Publish(channel, message string) error {
+	c.subLock.RLock()
+	defer c.subLock.RUnlock()
+	for handler := range c.subscribers[channel] {
+		(*handler)(message)
+	}
+	return nil
+}
+
+//Subscribe Calls handler with every message published to the given channel until stop is closed. The subscription
+//can't be lost, so onState is called with true straight away and false only once stop is closed.
+func (c *MemoryCache) Subscribe(channel string, handler func(string), onState func(bool), stop <-chan bool) {
+	c.subLock.Lock()
+	if c.subscribers[channel] == nil {
+		c.subscribers[channel] = make(map[*func(string)]bool)
+	}
+	c.subscribers[channel][&handler] = true
+	c.subLock.Unlock()
+	onState(true)
+
+	<-stop
+
+	c.subLock.Lock()
+	delete(c.subscribers[channel], &handler)
+	if len(c.subscribers[channel]) == 0 {
+		delete(c.subscribers, channel)
+	}
+	c.subLock.Unlock()
+	onState(false)
+}
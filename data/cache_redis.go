@@ -0,0 +1,369 @@
+package data
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+	"go.alargerobot.dev/notebook/common"
+)
+
+//RedisCache A CacheService backed by Redis, shared by every instance pointed at the same server.
+type RedisCache struct {
+	redisClient *pool.Pool
+	addr        string
+	password    string
+	db          int
+	retry       common.RetryPolicy
+	breaker     *common.CircuitBreaker
+}
+
+//NewRedisCache Connects a pool of poolSize connections to the Redis server at addr, authenticating with password and
+//selecting db if they're set. If the first connection fails the error is returned along with a cache that's still
+//usable: the pool dials again whenever a command needs a connection, so commands fail only until Redis is reachable.
+func NewRedisCache(addr, password string, db, poolSize int) (*RedisCache, error) {
+	c := &RedisCache{
+		addr:     addr,
+		password: password,
+		db:       db,
+		//cmd only ever hands Do connection (IO) errors, errors Redis itself replied with are left in the Resp.
+		retry:   common.NewRetryPolicy(func(error) bool { return true }),
+		breaker: common.NewCircuitBreaker("redis"),
+	}
+	client, err := pool.NewCustom("tcp", addr, poolSize, func(network, addr string) (*redis.Client, error) {
+		return c.dial()
+	})
+	c.redisClient = client
+	if err != nil {
+		return c, common.LogError("NewRedisCache", err)
+	}
+	return c, nil
+}
+
+func (c *RedisCache) dial() (*redis.Client, error) {
+	client, err := redis.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if c.password != "" {
+		if err := client.Cmd("AUTH", c.password).Err; err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if err := client.Cmd("SELECT", c.db).Err; err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+//cmd Runs a command on a pooled connection, retrying as the retry policy allows if the connection to Redis fails.
+func (c *RedisCache) cmd(command string, args ...interface{}) (resp *redis.Resp) {
+	err := c.retry.Do(context.Background(), c.breaker, func() error {
+		resp = c.redisClient.Cmd(command, args...)
+		if resp.IsType(redis.IOErr) {
+			return resp.Err
+		}
+		return nil
+	})
+	if resp == nil {
+		resp = redis.NewResp(err)
+	}
+	return resp
+}
+
+//PutString Caches a string with the given key+field
+func (c *RedisCache) PutString(key, field, value string) {
+	if resp := c.cmd("SET", key+":"+field, value); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "PutString", 500)
+	}
+}
+
+//PutObject Caches an object (as a string) with the given key+field
+func (c *RedisCache) PutObject(key, field string, value interface{}) {
+	newValue, _ := json.Marshal(value)
+	if resp := c.cmd("SET", key+":"+field, newValue); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "PutObject", 500)
+	}
+}
+
+//PutStringWithExpiration Caches a string with the given key+field that expires in expiresIn seconds.
+func (c *RedisCache) PutStringWithExpiration(key, field, value string, expiresIn int) {
+	if resp := c.cmd("SET", key+":"+field, value, "EX", expiresIn); resp.Err != nil {
+		common.CreateFailureResponseWithFields(resp.Err, 500, logrus.Fields{
+			"func":  "PutStringWithExpiration",
+			"key":   key,
+			"field": field,
+		})
+	}
+}
+
+//AddStringToSet Adds a string to a set with the given key+field
+func (c *RedisCache) AddStringToSet(setname, key, value string) {
+	if resp := c.cmd("SADD", setname+":"+key, value); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "AddStringToSet", 500)
+	}
+}
+
+//AddStringToSortedSet Adds a string to a sorted set with given setname+key, value and score
+func (c *RedisCache) AddStringToSortedSet(setname, key, value, score string) {
+	if resp := c.cmd("ZADD", setname+":"+key, "NX", score, value); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "AddStringToSortedSet", 500)
+	}
+}
+
+//AddStringToGlobalSet Add a string to a "global" set (ie one that's not specific to a user) with the given key
+func (c *RedisCache) AddStringToGlobalSet(key, value string) {
+	if resp := c.cmd("SADD", key, value); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "AddStringToGlobalSet", 500)
+	}
+}
+
+//SetTTLOnKey Sets a TTL in seconds (time-to-live) on the given key+field
+func (c *RedisCache) SetTTLOnKey(key, field string, ttl int) {
+	if resp := c.cmd("EXPIRE", key+":"+field, ttl); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "SetTTLOnKey", 500)
+	}
+}
+
+//GetString Returns a cached string with the given key+field
+func (c *RedisCache) GetString(key, field string) string {
+	if c.DoesKeyExist(key, field) == false {
+		return ""
+	}
+	if resp := c.cmd("GET", key+":"+field); resp.Err != nil {
+		common.CreateFailureResponseWithFields(resp.Err, 500, logrus.Fields{
+			"func":  "GetString",
+			"key":   key,
+			"field": field,
+		})
+	} else {
+		if value, err := resp.Str(); err == nil {
+			return value
+		} else {
+			return ""
+		}
+	}
+	return ""
+}
+
+//Increment Adds one to the counter with the given key+field and returns its new value. A new counter expires in
+//expiresIn seconds.
+func (c *RedisCache) Increment(key, field string, expiresIn int) (int64, error) {
+	resp := c.cmd("INCR", key+":"+field)
+	if resp.Err != nil {
+		return 0, resp.Err
+	}
+	count, err := resp.Int64()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if resp := c.cmd("EXPIRE", key+":"+field, expiresIn); resp.Err != nil {
+			return count, resp.Err
+		}
+	}
+	return count, nil
+}
+
+//GetInt Returns a cached Int (stored as a string) with the given key+field
+func (c *RedisCache) GetInt(key string, field int) (int, error) {
+	value, err := strconv.Atoi(c.GetString(key, strconv.Itoa(field)))
+	if err != nil {
+		return 0, common.LogError("", err)
+	}
+	return value, nil
+}
+
+//GetSet ...
+func (c *RedisCache) GetSet(key, field string) []string {
+	if c.DoesKeyExist(key, field) == false {
+		return nil
+	}
+	if resp := c.cmd("SMEMBERS", key+":"+field); resp.Err != nil {
+		common.CreateFailureResponseWithFields(resp.Err, 500, logrus.Fields{
+			"func": "GetSet", "key": key, "field": field,
+		})
+	} else {
+		if set, err := resp.List(); err == nil {
+			return set
+		} else {
+			common.CreateFailureResponse(err, "GetSet", 500)
+			return nil
+		}
+	}
+	return nil
+}
+
+//GetSortedSet ...
+func (c *RedisCache) GetSortedSet(key, field string) []string {
+	if c.DoesKeyExist(key, field) == false {
+		return nil
+	}
+	if resp := c.cmd("ZRANGE", key+":"+field, "0", "-1"); resp.Err != nil {
+		common.CreateFailureResponseWithFields(resp.Err, 500, logrus.Fields{
+			"func": "GetSet", "key": key, "field": field,
+		})
+	} else {
+		if set, err := resp.List(); err == nil {
+			return set
+		} else {
+			common.CreateFailureResponse(err, "GetSet", 500)
+			return nil
+		}
+	}
+	return nil
+}
+
+//IsInSet Whether member is in the set with the given key+field
+func (c *RedisCache) IsInSet(key, field, member string) bool {
+	if resp := c.cmd("SISMEMBER", key+":"+field, member); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "IsInSet", 500)
+	} else {
+		if ret, err := resp.Int(); err == nil {
+			if ret == 1 {
+				return true
+			} else {
+				return false
+			}
+		} else {
+			common.CreateFailureResponse(resp.Err, "IsInSet", 500)
+			return false
+		}
+	}
+	return false
+}
+
+//DeleteString ...
+func (c *RedisCache) DeleteString(key, field string) {
+	if resp := c.cmd("DEL", key+":"+field); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "DeleteString", 500)
+	}
+}
+
+//DeleteInt ...
+func (c *RedisCache) DeleteInt(key string, field int) {
+	c.DeleteString(key, strconv.Itoa(field))
+}
+
+//DeleteSetItem ...
+func (c *RedisCache) DeleteSetItem(setname, key, member string) {
+	if resp := c.cmd("SREM", key+":"+setname, member); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "DeleteString", 500)
+	}
+}
+
+//CacheQuery Caches a query's result for expiresIn seconds, keyed by the hash of cacheKey (which should say
+//everything the result depends on).
+func (c *RedisCache) CacheQuery(cacheKey, result string, expiresIn int) {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	c.PutStringWithExpiration("dcgqlcache", hex.EncodeToString(hash), result, expiresIn)
+}
+
+//GetCachedQuery The result cached by CacheQuery for cacheKey, empty if there isn't one.
+func (c *RedisCache) GetCachedQuery(cacheKey string) string {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	return c.GetString("dcgqlcache", hex.EncodeToString(hash))
+}
+
+//DeleteCachedQuery ...
+func (c *RedisCache) DeleteCachedQuery(cacheKey string) {
+	hash := common.ToSHA256Bytes([]byte(cacheKey))
+	field := hex.EncodeToString(hash)
+	if resp := c.cmd("DEL", "dcgqlcache"+":"+field); resp.Err != nil {
+		common.CreateFailureResponse(resp.Err, "DeleteString", 500)
+	}
+}
+
+//Publish Publishes message to everyone subscribed to the given pub/sub channel
+func (c *RedisCache) Publish(channel, message string) error {
+	if resp := c.cmd("PUBLISH", channel, message); resp.Err != nil {
+		return common.LogError("Publish", resp.Err)
+	}
+	return nil
+}
+
+//Subscribe Subscribes to the given pub/sub channel on a dedicated connection and calls handler with every message
+//published to it until stop is closed. If the connection drops it's re-established after a short delay. Calls
+//onState with true when the subscription becomes active, and false when it's lost.
+func (c *RedisCache) Subscribe(channel string, handler func(string), onState func(bool), stop <-chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if conn, err := c.dial(); err == nil {
+			sub := pubsub.NewSubClient(conn)
+			if resp := sub.Subscribe(channel); resp.Err == nil {
+				onState(true)
+				c.receive(sub, handler, stop)
+				onState(false)
+			} else {
+				common.LogError("Subscribe", resp.Err)
+			}
+			conn.Close()
+		} else {
+			common.LogError("Subscribe", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *RedisCache) receive(sub *pubsub.SubClient, handler func(string), stop <-chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		resp := sub.Receive()
+		if resp.Timeout() {
+			continue
+		}
+		if resp.Err != nil {
+			common.LogError("Subscribe", resp.Err)
+			return
+		}
+		if resp.Type == pubsub.Message {
+			handler(resp.Message)
+		}
+	}
+}
+
+//DoesKeyExist ...
+func (c *RedisCache) DoesKeyExist(key, field string) bool {
+	if resp := c.cmd("EXISTS", key+":"+field); resp.Err != nil {
+		common.CreateFailureResponseWithFields(resp.Err, 500, logrus.Fields{
+			"func":  "DoesKeyExist",
+			"key":   key,
+			"field": field,
+		})
+	} else {
+		if ret, err := resp.Int(); err == nil {
+			if ret == 1 {
+				return true
+			} else {
+				return false
+			}
+		} else {
+			common.CreateFailureResponse(resp.Err, "DoesKeyExist", 500)
+			return false
+		}
+	}
+	return false
+}
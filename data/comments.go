@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//NewComment ...
+func (data *MongoStore) NewComment(ctx context.Context, comment PageComment) error {
+	_, err := data.insertItem(ctx, "comments", comment)
+	return common.LogError("", err)
+}
+
+//GetComments Returns every comment on the specified page, oldest first.
+func (data *MongoStore) GetComments(ctx context.Context, pageID string) (comments []PageComment, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"createdat": 1})
+		r, err := data.db.Collection("comments", nil).Find(ctx, bson.M{"pageid": pageID}, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &comments)
+	})
+	return comments, common.LogError("", err)
+}
+
+//GetComment ...
+func (data *MongoStore) GetComment(ctx context.Context, id, pageID string) (comment PageComment, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("comments", nil).FindOne(ctx, bson.M{"id": id, "pageid": pageID}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+		if result.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such comment"}
+		} else if result.Err() != nil {
+			return result.Err()
+		}
+		return result.Decode(&comment)
+	})
+	return comment, err
+}
+
+//UpdateCommentBody ...
+func (data *MongoStore) UpdateCommentBody(ctx context.Context, id, body string) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("comments", nil).UpdateOne(ctx, bson.M{"id": id},
+			bson.M{"$set": bson.M{"body": body, "editedat": common.UnixTimestampInMS()}}, &options.UpdateOptions{})
+		return err
+	}))
+}
+
+//ResolveCommentThread Marks the thread started by the comment with the specified id as (un)resolved.
+func (data *MongoStore) ResolveCommentThread(ctx context.Context, id string, resolved bool, username string) error {
+	if !resolved {
+		username = ""
+	}
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("comments", nil).UpdateOne(ctx, bson.M{"id": id},
+			bson.M{"$set": bson.M{"resolved": resolved, "resolvedby": username}}, &options.UpdateOptions{})
+		return err
+	}))
+}
+
+//DeleteComment Deletes a comment, along with its replies if it started a thread.
+func (data *MongoStore) DeleteComment(ctx context.Context, id string) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("comments", nil).DeleteMany(ctx, bson.M{"$or": []bson.M{{"id": id}, {"parentid": id}}}, &options.DeleteOptions{})
+		return err
+	}))
+}
+
+//SetSharedPageComments Turns commenting visibility on or off for a shared page.
+func (data *MongoStore) SetSharedPageComments(ctx context.Context, sharedPageID, username string, allow bool) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("sharedpages", nil).UpdateOne(ctx, bson.M{"id": sharedPageID, "owner": username},
+			bson.M{"$set": bson.M{"allowcomments": allow}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		if r.MatchedCount == 0 {
+			return storeError{ErrNotFound, "no such shared page"}
+		}
+		return nil
+	}))
+}
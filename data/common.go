@@ -13,12 +13,91 @@ type User struct {
 	Id, PassHash string
 	Username     string `storm:"id"`
 	Group        string
+	Disabled     bool
+	CreatedAt    int64
+	//PasswordChangedAt Sessions issued before this (unix ms) aren't valid any more.
+	PasswordChangedAt int64
 }
 
-//AccessLevel ...
+//NewUserRequest An admin registering a local account. Group "root" makes the account an admin.
+type NewUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Group    string `json:"group"`
+}
+
+//LoginRequest Code is only needed if the account has two-factor authentication turned on.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+//ChangePasswordRequest ...
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+//DisableUserRequest ...
+type DisableUserRequest struct {
+	Username string `json:"username"`
+	Disabled bool   `json:"disabled"`
+}
+
+//TwoFactor A user's TOTP enrollment. It isn't Enabled until the user has proven their authenticator works. The
+//secret is sealed by the KMS, recovery codes are stored hashed and removed as they're used.
+type TwoFactor struct {
+	Username      string
+	SealedSecret  string
+	Enabled       bool
+	RecoveryCodes []string
+	//LastStep The last time step a code was accepted for, codes from it or earlier are rejected so they can't be replayed.
+	LastStep  int64
+	CreatedAt int64
+}
+
+//TwoFactorCodeRequest ...
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+//AccessLevel KeyID is only set for API keys.
 type AccessLevel struct {
-	Username string
-	Scopes   []string
+	Username    string
+	TokenType   string
+	KeyID       string
+	Scopes      []string
+	Constraints APIKeyConstraints
+}
+
+//AuditEntry One security relevant action. Entries are chained, each one's Hash covers the entry and the Hash of the
+//one before it, so removing or changing an entry breaks the chain from that point on.
+type AuditEntry struct {
+	Seq       int64    `json:"seq"`
+	Timestamp int64    `json:"timestamp"`
+	Actor     string   `json:"actor"`
+	TokenType string   `json:"tokenType,omitempty"`
+	KeyID     string   `json:"keyID,omitempty"`
+	Action    string   `json:"action"`
+	Resources []string `json:"resources,omitempty"`
+	Outcome   string   `json:"outcome"`
+	//ClientIP From common.ClientIP, so forwarding headers only count when they were set by a trusted proxy.
+	ClientIP string `json:"clientIP"`
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+//AuditQuery Every filter is optional. Entries come back in Seq order, starting after AfterSeq.
+type AuditQuery struct {
+	Actor    string
+	Action   string
+	Resource string
+	Outcome  string
+	Since    int64
+	Until    int64
+	AfterSeq int64
+	Limit    int64
 }
 
 //DeleteAPIKeyRequest ...
@@ -34,28 +113,64 @@ type NewPageRequest struct {
 	NotebookID string `json:"notebookID"`
 }
 
-//NewAPIKeyRequest ...
+//NewAPIKeyRequest ExpiresInDays of 0 means the key never expires (unless the "apiKeyMaxDays" config option says
+//otherwise). ExpiresAt is worked out from it when the key is created.
 type NewAPIKeyRequest struct {
-	Creator     string `json:"-"`
-	Scopes      string `json:"scopes"`
-	Description string `json:"description"`
+	Creator       string            `json:"-"`
+	Scopes        ScopeList         `json:"scopes"`
+	Constraints   APIKeyConstraints `json:"constraints"`
+	Description   string            `json:"description"`
+	ExpiresInDays int               `json:"expiresInDays"`
+	ExpiresAt     int64             `json:"-"`
+}
+
+//APIKeyConstraints Narrows down what a key can get at on top of its scopes. The zero value doesn't narrow anything.
+type APIKeyConstraints struct {
+	//Notebooks The key only works on these notebooks.
+	Notebooks []string `json:"notebooks,omitempty"`
+	//Tags The key can only read, and only pages with at least one of these tags.
+	Tags []string `json:"tags,omitempty"`
+}
+
+//RotateAPIKeyRequest The old key keeps working for GraceHours after the new one is issued, so it can be swapped out
+//without downtime.
+type RotateAPIKeyRequest struct {
+	ID            string `json:"id"`
+	GraceHours    int    `json:"graceHours"`
+	ExpiresInDays int    `json:"expiresInDays"`
 }
 
 //SharePageRequest ...
 type SharePageRequest struct {
-	PageID     string `json:"page"`
-	PageTitle  string `json:"title"`
-	NotebookID string `json:"notebook"`
+	PageID        string `json:"page"`
+	PageTitle     string `json:"title"`
+	NotebookID    string `json:"notebook"`
+	AllowComments bool   `json:"comments"`
 }
 
-//UserAPIKey ...
+//UserAPIKey Scopes is how keys stored before scopes were a list have them (comma separated), it's moved into
+//ScopeList when the key is read.
 type UserAPIKey struct {
-	ID          string `json:"id"`
-	Hash        string `json:"hash"`
-	Scopes      string `json:"scopes"`
-	Creator     string `json:"creator"`
-	CreatedAt   string `json:"createdAt"`
-	Description string `json:"description"`
+	ID          string            `json:"id"`
+	Hash        string            `json:"hash"`
+	Scopes      string            `json:"-" bson:",omitempty"`
+	ScopeList   ScopeList         `json:"scopes"`
+	Constraints APIKeyConstraints `json:"constraints"`
+	Creator     string            `json:"creator"`
+	CreatedAt   string            `json:"createdAt"`
+	Description string            `json:"description"`
+	//ExpiresAt In ms, 0 if the key never expires.
+	ExpiresAt  int64 `json:"expiresAt"`
+	LastUsedAt int64 `json:"lastUsedAt"`
+	//LastUsedIP From common.ClientIP, like AuditEntry.ClientIP.
+	LastUsedIP string `json:"lastUsedIP"`
+}
+
+//APIKeyUsage When (and where from) a key was last used.
+type APIKeyUsage struct {
+	Hash       string
+	LastUsedAt int64
+	LastUsedIP string
 }
 
 //NewAPIKeyResponse ...
@@ -68,7 +183,7 @@ type DeleteAPIKeyResponse struct {
 	Tokens []UserAPIKey `json:"keys"`
 }
 
-//Notebook ...
+//Notebook Pages is only ever filled in on notebooks stored before pages got a collection of their own.
 type Notebook struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
@@ -84,12 +199,28 @@ type Notebooks struct {
 //Page ...
 type Page struct {
 	ID         string   `json:"id"`
+	NotebookID string   `json:"notebookID"`
 	Tags       []string `json:"tags"`
 	Title      string   `json:"title"`
 	Creator    string   `json:"creator"`
 	LastEdited int64    `json:"lastEdited"`
 }
 
+//PageQuery How to sort and page through a notebook's pages. SortBy is either "title" or "lastEdited" (empty sorts by
+//title), a Limit of 0 returns everything, and Cursor is the NextCursor from the previous PageList.
+type PageQuery struct {
+	SortBy     string
+	Descending bool
+	Limit      int64
+	Cursor     string
+}
+
+//PageList One page (so to speak) of a notebook's pages. NextCursor is empty once there's nothing left.
+type PageList struct {
+	Pages      []Page `json:"pages"`
+	NextCursor string `json:"next,omitempty"`
+}
+
 //NotebookReference ...
 type NotebookReference struct {
 	ID   string `json:"id"`
@@ -134,10 +265,153 @@ type TagFilterResult []struct {
 
 //SharedPage ...
 type SharedPage struct {
-	ID          string `json:"id"`
-	Owner       string `json:"owner"`
-	PageID      string `json:"pageID"`
-	PageTitle   string `json:"pageTitle"`
-	NotebookID  string `json:"notebookID"`
-	AccessToken string `json:"accessToken"`
+	ID            string `json:"id"`
+	Owner         string `json:"owner"`
+	PageID        string `json:"pageID"`
+	PageTitle     string `json:"pageTitle"`
+	NotebookID    string `json:"notebookID"`
+	AccessToken   string `json:"accessToken"`
+	AllowComments bool   `json:"allowComments"`
+}
+
+//SharedPageContent What a share link shows.
+type SharedPageContent struct {
+	Title         string `json:"title"`
+	LastEdit      int64  `json:"lastEdit"`
+	Content       string `json:"content"`
+	AllowComments bool   `json:"allowComments"`
+}
+
+//ReminderStatus ...
+type ReminderStatus string
+
+const (
+	ReminderPending   ReminderStatus = "pending"
+	ReminderSending   ReminderStatus = "sending"
+	ReminderFired     ReminderStatus = "fired"
+	ReminderFailed    ReminderStatus = "failed"
+	ReminderCancelled ReminderStatus = "cancelled"
+)
+
+//Reminder A scheduled notification attached to a page, or to a task inside a page.
+type Reminder struct {
+	ID         string         `json:"id"`
+	Owner      string         `json:"owner"`
+	PageID     string         `json:"pageID"`
+	TaskID     string         `json:"taskID,omitempty"`
+	NotebookID string         `json:"notebookID"`
+	PageTitle  string         `json:"pageTitle"`
+	Message    string         `json:"message"`
+	Notifier   string         `json:"notifier"`
+	Target     string         `json:"target"`
+	FireAt     int64          `json:"fireAt"`
+	Status     ReminderStatus `json:"status"`
+	Attempts   int            `json:"attempts"`
+	ClaimedAt  int64          `json:"-"`
+}
+
+//NewReminderRequest ...
+type NewReminderRequest struct {
+	PageID     string `json:"page"`
+	TaskID     string `json:"task"`
+	NotebookID string `json:"notebook"`
+	Message    string `json:"message"`
+	Notifier   string `json:"notifier"`
+	Target     string `json:"target"`
+	FireAt     int64  `json:"fireAt"`
+}
+
+//SnoozeReminderRequest Either an absolute time (unix ms) or a number of minutes from now.
+type SnoozeReminderRequest struct {
+	Until   int64 `json:"until"`
+	Minutes int   `json:"minutes"`
+}
+
+//WebhookSubscription ...
+type WebhookSubscription struct {
+	ID           string   `json:"id"`
+	Owner        string   `json:"owner"`
+	URL          string   `json:"url"`
+	Events       []string `json:"events"`
+	Description  string   `json:"description"`
+	SealedSecret string   `json:"-"`
+	CreatedAt    int64    `json:"createdAt"`
+}
+
+//NewWebhookRequest ...
+type NewWebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+//NewWebhookResponse The signing secret is only ever returned here, when the subscription is created.
+type NewWebhookResponse struct {
+	Webhook WebhookSubscription `json:"webhook"`
+	Secret  string              `json:"secret"`
+}
+
+//DeliveryStatus ...
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySending   DeliveryStatus = "sending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+//WebhookDelivery A single attempt (and its retries) to deliver an event to a webhook subscription.
+type WebhookDelivery struct {
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscriptionID"`
+	Owner          string         `json:"-"`
+	Event          string         `json:"event"`
+	Payload        string         `json:"payload"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	ResponseCode   int            `json:"responseCode"`
+	LastError      string         `json:"lastError,omitempty"`
+	CreatedAt      int64          `json:"createdAt"`
+	NextAttempt    int64          `json:"nextAttempt"`
+	ClaimedAt      int64          `json:"-"`
+}
+
+//CommentAnchor The range of page text (in characters) a comment thread is attached to.
+type CommentAnchor struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+//PageComment A comment on a page. Comments with a ParentID are replies in the thread started by that comment.
+//Body is only ever plaintext outside of the DataStore, it's stored encrypted with the page's comment key.
+type PageComment struct {
+	ID         string         `json:"id"`
+	PageID     string         `json:"pageID"`
+	NotebookID string         `json:"notebookID"`
+	ParentID   string         `json:"parentID,omitempty"`
+	Author     string         `json:"author"`
+	Body       string         `json:"body"`
+	Anchor     *CommentAnchor `json:"anchor,omitempty"`
+	Resolved   bool           `json:"resolved"`
+	ResolvedBy string         `json:"resolvedBy,omitempty"`
+	CreatedAt  int64          `json:"createdAt"`
+	EditedAt   int64          `json:"editedAt,omitempty"`
+}
+
+//NewCommentRequest ...
+type NewCommentRequest struct {
+	Body     string         `json:"body"`
+	ParentID string         `json:"parentID"`
+	Anchor   *CommentAnchor `json:"anchor"`
+}
+
+//EditCommentRequest ...
+type EditCommentRequest struct {
+	Body string `json:"body"`
+}
+
+//ResolveCommentRequest ...
+type ResolveCommentRequest struct {
+	Resolved bool `json:"resolved"`
 }
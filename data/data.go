@@ -2,50 +2,58 @@ package data
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"errors"
-	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/x/mongo/driver/auth"
 
-	"github.com/google/uuid"
 	"go.alargerobot.dev/notebook/common"
 	"go.alargerobot.dev/notebook/crypto"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
-//DataStore ...
-type DataStore struct {
-	Cache           *CacheService
+const (
+	//defaultDBTimeout How long a single database operation gets when "dbTimeout" isn't set.
+	defaultDBTimeout = 5 * time.Second
+
+	mongoWriteConflict = 112
+)
+
+//MongoStore A DataStore backed by MongoDB, using credentials leased from Vault.
+type MongoStore struct {
+	cache           CacheService
 	mongo           *mongo.Client
 	db              *mongo.Database
 	vault           *crypto.VaultKMS
 	stopCredRefresh bool
+	txnLock         sync.Mutex
+	txnSupport      *bool
+	retry           common.RetryPolicy
+	breaker         *common.CircuitBreaker
 }
 
-//NewDataStore ...
-func NewDataStore(vault *crypto.VaultKMS) *DataStore {
-	ds := &DataStore{vault: vault, Cache: NewCacheService()}
-	if err := ds.ConnectToMongoDB(); err != nil {
+//NewMongoStore ...
+func NewMongoStore(vault *crypto.VaultKMS, cache CacheService) *MongoStore {
+	ds := &MongoStore{vault: vault, cache: cache, retry: common.NewRetryPolicy(isRetryableMongoError), breaker: common.NewCircuitBreaker("database")}
+	if err := ds.ConnectToMongoDB(context.Background()); err != nil {
 		panic(err)
 	}
 	return ds
 }
 
 //ConnectToMongoDB ...
-func (data *DataStore) ConnectToMongoDB() error {
-	if user, pass, err := data.vault.GetDBCredentials(); err == nil {
+func (data *MongoStore) ConnectToMongoDB(ctx context.Context) error {
+	if user, pass, err := data.vault.GetDBCredentials(ctx); err == nil {
 		mongoURL := "mongodb://" + user + ":" + pass + "@" + common.CurrentConfig.DBServerAddr + "/" + common.CurrentConfig.DBName + "?authsource=" + common.CurrentConfig.DBName
 		mongoClientOpts := options.Client().ApplyURI(mongoURL)
 		if mongoClient, err := mongo.NewClient(mongoClientOpts); err == nil {
-			if err = mongoClient.Connect(context.Background()); err != nil {
+			if err = mongoClient.Connect(ctx); err != nil {
 				return err
 			}
 			data.mongo = mongoClient
@@ -57,92 +65,40 @@ func (data *DataStore) ConnectToMongoDB() error {
 	return nil
 }
 
-//NewPage ...
-func (data *DataStore) NewPage(page Page, notebookID string) error {
-	if err := data.checkConnection(); err != nil {
-		return err
-	}
-
-	return data.retryableQuery(func() error {
-		if count, err := data.db.Collection("notebooks", nil).CountDocuments(context.Background(), bson.M{"pages.title": page.Title}, &options.CountOptions{}); err == nil {
-			if count > 0 {
-				return errors.New("page with the specified title alrady exists")
-			}
-		}
-
-		page.LastEdited = time.Now().Unix()
-
-		r, err := data.db.Collection("notebooks", nil).UpdateOne(context.Background(), bson.M{"id": notebookID}, bson.M{"$addToSet": bson.M{"pages": page}}, &options.UpdateOptions{})
-		if r != nil {
-			if r.ModifiedCount == 0 && err == nil {
-				return errors.New("not modified")
-			} else if r.MatchedCount == 0 && err == nil {
-				return errors.New("not found")
-			} else if err != nil {
-				return err
-			} else {
-				return nil
-			}
-		} else {
-			return common.LogError("", err)
-		}
-	})
-
-}
-
 //NewTag ...
-func (data *DataStore) NewTag(tag PageTag) (PageTag, error) {
-	if err := data.checkConnection(); err != nil {
-		return PageTag{}, err
-	}
-
-	inserted, err := data.insertUniqueItem("tags", tag, bson.M{"tagvalue": tag.TagValue})
+func (data *MongoStore) NewTag(ctx context.Context, tag PageTag) (PageTag, error) {
+	inserted, err := data.insertUniqueItem(ctx, "tags", tag, bson.M{"tagvalue": tag.TagValue})
 
 	if !inserted {
-		return PageTag{}, errors.New("this tag already exists")
+		return PageTag{}, storeError{ErrConflict, "this tag already exists"}
 	}
 	if err == nil {
-		data.Cache.DeleteString("notescache", "tags")
+		data.cache.DeleteString("notescache", "tags")
 		return tag, nil
 	}
 	return PageTag{}, err
 }
 
 //NewNotebook ...
-func (data *DataStore) NewNotebook(notebook Notebook) error {
-	if err := data.checkConnection(); err != nil {
-		return err
-	}
-	inserted, err := data.insertUniqueItem("notebooks", notebook, bson.M{"name": notebook.Name})
+func (data *MongoStore) NewNotebook(ctx context.Context, notebook Notebook) error {
+	inserted, err := data.insertUniqueItem(ctx, "notebooks", notebook, bson.M{"name": notebook.Name})
 	if !inserted {
-		return errors.New("this notebook already exists")
+		return storeError{ErrConflict, "this notebook already exists"}
+	}
+	if err == nil {
+		data.cache.DeleteString(notebookRefsCache, notebook.Owner)
 	}
 	return err
 }
 
 //NewAPIKey ...
-func (data *DataStore) NewAPIKey(keyRequest NewAPIKeyRequest) (key string, err error) {
-	if err := data.checkConnection(); err != nil {
-		return "", err
-	}
-	var b [20]byte
-	var apiKey UserAPIKey
-
-	newT := time.Now().Format("Jan 2, 2006")
-	if _, err := rand.Read(b[:]); err != nil {
-		common.LogError("", err)
+func (data *MongoStore) NewAPIKey(ctx context.Context, keyRequest NewAPIKeyRequest) (key string, err error) {
+	apiKey, t, err := newAPIKey(keyRequest)
+	if err != nil {
 		return "", err
 	}
-	t := hex.EncodeToString(b[:])
-
-	apiKey.CreatedAt = newT
-	apiKey.ID = uuid.New().String()
-	apiKey.Scopes = keyRequest.Scopes
-	apiKey.Creator = keyRequest.Creator
-	apiKey.Description = keyRequest.Description
-	apiKey.Hash = hex.EncodeToString(common.ToSHA256Bytes([]byte(t)))
 
-	if success, err := data.insertItem("apikeys", apiKey); success {
+	if success, err := data.insertItem(ctx, "apikeys", apiKey); success {
 		return string(t), nil
 	} else {
 		return "", err
@@ -150,20 +106,10 @@ func (data *DataStore) NewAPIKey(keyRequest NewAPIKeyRequest) (key string, err e
 }
 
 //NewSharedPage ...
-func (data *DataStore) NewSharedPage(sharedPageReq SharePageRequest, username string) (SharedPage, error) {
-	if err := data.checkConnection(); err != nil {
-		return SharedPage{}, err
-	}
+func (data *MongoStore) NewSharedPage(ctx context.Context, sharedPageReq SharePageRequest, username string) (SharedPage, error) {
+	sharedPageMD := newSharedPage(sharedPageReq, username)
 
-	var sharedPageMD SharedPage
-	sharedPageMD.ID = uuid.New().String()
-	sharedPageMD.Owner = username
-	sharedPageMD.PageID = sharedPageReq.PageID
-	sharedPageMD.NotebookID = sharedPageReq.NotebookID
-	sharedPageMD.PageTitle = sharedPageReq.PageTitle
-	sharedPageMD.AccessToken = common.RandomID(16)
-
-	inserted, err := data.insertUniqueItem("sharedpages", sharedPageMD, bson.M{"pageID": sharedPageReq.PageID})
+	inserted, err := data.insertUniqueItem(ctx, "sharedpages", sharedPageMD, bson.M{"pageid": sharedPageReq.PageID})
 
 	if !inserted && err == nil {
 		return SharedPage{}, errors.New("this page is already shared")
@@ -175,164 +121,35 @@ func (data *DataStore) NewSharedPage(sharedPageReq SharePageRequest, username st
 	}
 }
 
-//AddTagToPage ...
-func (data *DataStore) AddTagToPage(tag string, pageID int) (string, error) {
-	var updateResult *mongo.UpdateResult
-	if err := data.checkConnection(); err != nil {
-		return "", err
-	}
-	err := data.retryableQuery(func() error {
-		r, err := data.db.Collection("notebooks", nil).UpdateOne(context.Background(), bson.M{"pages.id": pageID}, bson.M{"$addToSet": bson.M{"pages.tags": tag}}, &options.UpdateOptions{})
-		updateResult = r
-		return err
-	})
-	if updateResult.ModifiedCount == 0 && err == nil {
-		return "not modified", nil
-	} else if updateResult.MatchedCount == 0 && err == nil {
-		return "not found", nil
-	} else if err != nil {
-		return "error", err
-	} else {
-		return "success", nil
-	}
-}
-
-//GetContentsOfNotebook ...
-func (data *DataStore) GetContentsOfNotebook(notebookID, creator string) (pageRefs []Page, e error) {
-	var pageRefMap map[string][]Page
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-	e = data.retryableQuery(func() error {
-		projection := bson.D{{"pages", 1}, {"_id", 0}}
-		result := data.db.Collection("notebooks", nil).FindOne(context.Background(), bson.M{"id": notebookID, "owner": creator}, options.FindOne().SetProjection(projection))
-		if err := common.LogError("GetContentsOfNotebook(decode)", result.Decode(&pageRefMap)); err != nil {
-			return err
-		}
-		return result.Err()
-	})
-	if e != nil {
-		return nil, e
-	}
-	return pageRefMap["pages"], nil //, nil
-}
-
-//GetPageTags ...
-func (data *DataStore) GetPageTags(pageID string) (tags []PageTag, e error) {
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-	e = data.retryableQuery(func() error {
-		projection := bson.D{{"pages.tags", 1}, {"_id", 0}}
-		result := data.db.Collection("notebooks", nil).FindOne(context.Background(), bson.M{"pages.id": pageID}, options.FindOne().SetProjection(projection))
-		if result.Err() != nil {
-			return result.Err()
-		}
-		return common.LogError("", result.Decode(&tags))
-	})
-
-	if e != nil {
-		return nil, e
-	}
-	return tags, e
-}
-
-//GetPageByID ...
-func (data *DataStore) GetPageByID(pageID, notebookID string) (page Page, e error) {
-	var p []map[string][]Page
-	if err := data.checkConnection(); err != nil {
-		return Page{}, err
-	}
-
-	e = data.retryableQuery(func() error {
-		projection := bson.D{{"$project", bson.D{{"pages", 1}, {"_id", 0}}}}
-		matchStage := bson.D{{"$match", bson.D{{"pages.id", pageID}}}}
-		unwindStage := bson.D{{"$unwind", "$pages"}}
-		group := bson.D{{"$group", bson.M{"_id": "$id", "pages": bson.D{{"$push", "$pages"}}}}}
-
-		r, e := data.db.Collection("notebooks", nil).Aggregate(context.Background(), mongo.Pipeline{unwindStage, matchStage, group, projection})
-		if e != nil {
-			return common.LogError("", e)
-		}
-
-		return r.All(context.Background(), &p)
-	})
-
-	if e != nil {
-		return Page{}, common.LogError("", e)
-	}
-
-	return p[0]["pages"][0], e
-}
-
-//GetPageTitle ...
-
-//GetPagesWithTags Returns pagerefs of docs matching the 'tag(s)' specified
-func (data *DataStore) GetPagesWithTags(tags []string, notebookID string) (pages []Page, err error) {
-	var filterResult TagFilterResult
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-
-	err = data.retryableQuery(func() error {
-		matchStage := bson.D{{"$match", bson.D{{"pages.tags", bson.M{"$all": tags}}, {"id", notebookID}}}}
-		unwindStage := bson.D{{"$unwind", "$pages"}}
-		group := bson.D{{"$group", bson.M{"_id": "$id", "pages": bson.D{{"$push", "$pages"}}}}}
-		projection := bson.D{{"$project", bson.D{{"pages", 1}, {"_id", 0}}}}
-
-		r, e := data.db.Collection("notebooks", nil).Aggregate(context.Background(), mongo.Pipeline{matchStage, unwindStage, matchStage, group, projection})
-		if e != nil {
-			return common.LogError("", e)
-		}
-
-		if err = r.All(context.Background(), &filterResult); err != nil {
-			return err
-		}
-
-		if len(filterResult) > 0 {
-			for _, res := range filterResult {
-				pages = append(pages, res.Pages...)
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return pages, common.LogError("", err)
-}
-
 //GetAPIKey ...
-func (data *DataStore) GetAPIKey(keyHash string) (key UserAPIKey, err error) {
-	if err := data.checkConnection(); err != nil {
-		return UserAPIKey{}, err
-	}
+func (data *MongoStore) GetAPIKey(ctx context.Context, keyHash string) (key UserAPIKey, err error) {
 	projection := bson.D{{"_id", 0}}
-	err = data.retryableQuery(func() error {
-		result := data.db.Collection("apikeys", nil).FindOne(context.Background(), bson.M{"hash": keyHash}, options.FindOne().SetProjection(projection))
-		if result.Err() != nil {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("apikeys", nil).FindOne(ctx, bson.M{"hash": keyHash}, options.FindOne().SetProjection(projection))
+		if result.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such api key"}
+		} else if result.Err() != nil {
 			return result.Err()
 		}
 		return result.Decode(&key)
 	})
+	upgradeAPIKey(&key)
 	return key, err
 }
 
 //GetAPIKeys ...
-func (data *DataStore) GetAPIKeys(username string) (keys []UserAPIKey, err error) {
+func (data *MongoStore) GetAPIKeys(ctx context.Context, username string) (keys []UserAPIKey, err error) {
 	var apiKey UserAPIKey
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
 
-	err = data.retryableQuery(func() error {
-		if result, err := data.db.Collection("apikeys", nil).Find(context.Background(), bson.M{"creator": username}, &options.FindOptions{}); err == nil {
-			for result.Next(context.Background()) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		if result, err := data.db.Collection("apikeys", nil).Find(ctx, bson.M{"creator": username}, &options.FindOptions{}); err == nil {
+			for result.Next(ctx) {
+				apiKey = UserAPIKey{}
 				if err = common.LogError("GetAPIKey(decode)", result.Decode(&apiKey)); err != nil {
 					return err
 				}
 				apiKey.Hash = ""
+				upgradeAPIKey(&apiKey)
 				keys = append(keys, apiKey)
 			}
 		} else {
@@ -346,28 +163,27 @@ func (data *DataStore) GetAPIKeys(username string) (keys []UserAPIKey, err error
 	return keys, nil
 }
 
-//GetUserNotebookNames ...
-func (data *DataStore) GetUserNotebookNames(username string) (names []NotebookReference, err error) {
+//GetUserNotebookNames Returns references to all of the user's notebooks, from the cache if they're there.
+func (data *MongoStore) GetUserNotebookNames(ctx context.Context, username string) (names []NotebookReference, err error) {
 	var nameList map[string]string
 
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-
-	err = data.retryableQuery(func() error {
-		projection := bson.D{{"name", 1}, {"id", 1}, {"_id", 0}}
-		r, e := data.db.Collection("notebooks", nil).Find(context.Background(), bson.M{"owner": username}, options.Find().SetProjection(projection))
-		if e != nil {
-			return e
-		}
+	err = readThrough(data.cache, notebookRefsCache, username, &names, func() error {
+		return data.retryableQuery(ctx, func(ctx context.Context) error {
+			projection := bson.D{{"name", 1}, {"id", 1}, {"_id", 0}}
+			r, e := data.db.Collection("notebooks", nil).Find(ctx, bson.M{"owner": username}, options.Find().SetProjection(projection))
+			if e != nil {
+				return e
+			}
 
-		for r.Next(context.Background()) {
-			if err = common.LogError("GetUserNotebookNames(decode)", r.Decode(&nameList)); err != nil {
-				return err
+			names = nil
+			for r.Next(ctx) {
+				if err = common.LogError("GetUserNotebookNames(decode)", r.Decode(&nameList)); err != nil {
+					return err
+				}
+				names = append(names, NotebookReference{Name: nameList["name"], ID: nameList["id"]})
 			}
-			names = append(names, NotebookReference{Name: nameList["name"], ID: nameList["id"]})
-		}
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -375,60 +191,42 @@ func (data *DataStore) GetUserNotebookNames(username string) (names []NotebookRe
 	return names, nil
 }
 
-//GetPageCreator ...
-func (data *DataStore) GetPageCreator(pageID string) (name string, e error) {
-	if err := data.checkConnection(); err != nil {
-		return "", err
-	}
-	var creator map[string][]map[string]string
-	projection := bson.D{{"pages.creator", 1}, {"_id", 0}}
-
-	err := data.retryableQuery(func() error {
-		result := data.db.Collection("notebooks", nil).FindOne(context.Background(), bson.M{"pages.id": pageID}, options.FindOne().SetProjection(projection))
-		e = common.LogError("", result.Decode(&creator))
-		return e
-	})
-
-	return creator["pages"][0]["creator"], err
-}
-
 //GetTags ...
-func (data *DataStore) GetTags() (tags []PageTag, e error) {
+func (data *MongoStore) GetTags(ctx context.Context) (tags []PageTag, e error) {
 	var tag PageTag
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
 
 	projection := bson.D{{"_id", 0}}
-	e = data.retryableQuery(func() error {
-		r, e := data.db.Collection("tags", nil).Find(context.Background(), bson.M{}, options.Find().SetProjection(projection))
+	e = data.retryableQuery(ctx, func(ctx context.Context) error {
+		tags = nil
+		r, e := data.db.Collection("tags", nil).Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+		if e != nil {
+			return e
+		}
+		defer r.Close(ctx)
 
-		for r.Next(context.Background()) {
+		for r.Next(ctx) {
 			if e = common.LogError("", r.Decode(&tag)); e != nil {
 				return e
 			}
 			tags = append(tags, tag)
 		}
-		return e
+		return r.Err()
 	})
 	return tags, e
 
 }
 
 //GetSharedPageInfo ...
-func (data *DataStore) GetSharedPageInfo(accessToken string) (SharedPage, error) {
+func (data *MongoStore) GetSharedPageInfo(ctx context.Context, accessToken string) (SharedPage, error) {
 	var page SharedPage
 
-	if err := data.checkConnection(); err != nil {
-		return SharedPage{}, err
-	}
 
-	err := data.retryableQuery(func() error {
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
 		projection := bson.D{{"_id", 0}}
 
-		result := data.db.Collection("sharedpages", nil).FindOne(context.Background(), bson.M{"accesstoken": accessToken}, options.FindOne().SetProjection(projection))
+		result := data.db.Collection("sharedpages", nil).FindOne(ctx, bson.M{"accesstoken": accessToken}, options.FindOne().SetProjection(projection))
 		if result.Err() == mongo.ErrNoDocuments {
-			return errors.New("no such shared page")
+			return storeError{ErrNotFound, "no such shared page"}
 		} else if result.Err() == nil {
 			err := result.Decode(&page)
 			return err
@@ -444,15 +242,11 @@ func (data *DataStore) GetSharedPageInfo(accessToken string) (SharedPage, error)
 }
 
 //GetSharedPages ...
-func (data *DataStore) GetSharedPages(username string) (pages []SharedPage, err error) {
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-
+func (data *MongoStore) GetSharedPages(ctx context.Context, username string) (pages []SharedPage, err error) {
 	var queryResult *mongo.Cursor
 	projection := bson.D{{"_id", 0}}
-	err = data.retryableQuery(func() error {
-		result, err := data.db.Collection("sharedpages", nil).Find(context.Background(), bson.M{"owner": username}, options.Find().SetProjection(projection))
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result, err := data.db.Collection("sharedpages", nil).Find(ctx, bson.M{"owner": username}, options.Find().SetProjection(projection))
 		if err != nil {
 			return err
 		}
@@ -462,88 +256,129 @@ func (data *DataStore) GetSharedPages(username string) (pages []SharedPage, err
 	if err != nil {
 		return []SharedPage{}, err
 	} else {
-		err = queryResult.All(context.Background(), &pages)
+		err = queryResult.All(ctx, &pages)
 		return pages, err
 	}
 }
 
 //IsValidTagID Returns true if the provided tag IDs both exist and were created by the provided username
-func (data *DataStore) IsValidTagID(ids []string, username string) (bool, error) {
-	if err := data.checkConnection(); err != nil {
-		return false, err
+func (data *MongoStore) IsValidTagID(ctx context.Context, ids []string, username string) (bool, error) {
+	if len(ids) == 0 {
+		return false, nil
 	}
-
-	var result *mongo.SingleResult
-	err := data.retryableQuery(func() error {
-		projection := bson.D{{"_id", 0}}
-		result = data.db.Collection("tags", nil).FindOne(context.Background(), bson.D{
-			{"creator", username}, {"tagid", bson.D{{"$in", ids}}}}, options.FindOne().SetProjection(projection))
-		return result.Err()
+	distinct := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	var valid bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		count, err := data.db.Collection("tags", nil).CountDocuments(ctx, bson.M{
+			"creator": username, "tagid": bson.M{"$in": distinct}}, &options.CountOptions{})
+		valid = count == int64(len(distinct))
+		return err
 	})
-
-	if result.Err() == mongo.ErrNoDocuments {
-		return false, nil
-	} else if result.Err() != nil {
+	if err != nil {
 		return false, err
-	} else {
-		return true, nil
 	}
+	return valid, nil
 }
 
 //DeleteAPIKey ...
-func (data *DataStore) DeleteAPIKey(id string) error {
-	if err := data.checkConnection(); err != nil {
-		return err
-	}
-	return data.retryableQuery(func() error {
-		r, err := data.db.Collection("apikeys", nil).DeleteOne(context.Background(), bson.M{"id": id}, &options.DeleteOptions{})
-		if r.DeletedCount == 0 {
-			return errors.New("provided key ID was invalid")
-		} else {
+func (data *MongoStore) DeleteAPIKey(ctx context.Context, id string) error {
+	return data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("apikeys", nil).DeleteOne(ctx, bson.M{"id": id}, &options.DeleteOptions{})
+		if err != nil {
 			return err
+		} else if r.DeletedCount == 0 {
+			return errInvalidKeyID
 		}
+		return nil
 	})
 }
 
-//DeletePage Deletes the Page with the ID specified.
-func (data *DataStore) DeletePage(pageID, notebookID string) error {
-	if err := data.checkConnection(); err != nil {
-		return err
-	}
-	return data.retryableQuery(func() error {
-		r, err := data.db.Collection("notebooks", nil).UpdateOne(context.Background(), bson.M{"id": notebookID}, bson.M{"$pull": bson.M{"pages": bson.M{"id": pageID}}}, &options.UpdateOptions{})
-
-		if r.ModifiedCount == 0 && err == nil {
-			return errors.New("not modified")
-		} else if r.MatchedCount == 0 && err == nil {
-			return errors.New("not found")
-		} else if err != nil {
-			return err
-		} else {
-			dr := data.db.Collection("sharedpages", nil).FindOneAndDelete(context.Background(), bson.M{"pageid": pageID}, &options.FindOneAndDeleteOptions{})
-			if dr.Err() == nil {
-				return nil
-			} else {
+//RotateAPIKey Issues a replacement for one of creator's keys, with the same scopes and description. The old key stops
+//working at graceUntil, or when it was going to expire anyway if that's sooner. Keys that have already expired can't be
+//rotated, and the new key is only kept if the old one's expiry is set too.
+func (data *MongoStore) RotateAPIKey(ctx context.Context, id, creator string, keyRequest NewAPIKeyRequest, graceUntil int64) (key string, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		return data.withTransaction(ctx, func(ctx context.Context, undo *compensation) error {
+			keys := data.db.Collection("apikeys", nil)
+			var old UserAPIKey
+			result := keys.FindOne(ctx, bson.M{"id": id, "creator": creator}, &options.FindOneOptions{})
+			if result.Err() == mongo.ErrNoDocuments {
+				return errInvalidKeyID
+			} else if result.Err() != nil {
+				return result.Err()
+			} else if err := result.Decode(&old); err != nil {
+				return err
+			}
+			if old.ExpiresAt != 0 && old.ExpiresAt <= common.UnixTimestampInMS() {
+				return errKeyExpired
+			}
 
-				return dr.Err()
+			upgradeAPIKey(&old)
+			request := keyRequest
+			request.Creator, request.Scopes, request.Constraints, request.Description = old.Creator, old.ScopeList, old.Constraints, old.Description
+			apiKey, t, err := newAPIKey(request)
+			if err != nil {
+				return err
 			}
-		}
+			if _, err := keys.InsertOne(ctx, apiKey, &options.InsertOneOptions{}); err != nil {
+				return err
+			}
+			undo.add(func(ctx context.Context) error {
+				_, err := keys.DeleteOne(ctx, bson.M{"hash": apiKey.Hash}, &options.DeleteOptions{})
+				return err
+			})
+
+			expiresAt := graceUntil
+			if old.ExpiresAt != 0 && old.ExpiresAt < expiresAt {
+				expiresAt = old.ExpiresAt
+			}
+			if _, err := keys.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"expiresat": expiresAt}}, &options.UpdateOptions{}); err != nil {
+				return err
+			}
+			key = t
+			return nil
+		})
 	})
+	if err != nil {
+		return "", common.LogError("", err)
+	}
+	return key, nil
 }
 
-//DeleteTag Deletes a tag that has no assigned docs. If this function is called on with a tagid that is in active use an error is returned.
-func (data *DataStore) DeleteTag(tagID string) error {
-	if err := data.checkConnection(); err != nil {
-		return err
+//RecordAPIKeyUsage Records a batch of key uses in one round trip. Older uses than the one already recorded are ignored.
+func (data *MongoStore) RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error {
+	if len(usage) == 0 {
+		return nil
 	}
-	return data.retryableQuery(func() error {
-		if result, err := data.db.Collection("notebooks", nil).CountDocuments(context.Background(), bson.M{"pages.tags": tagID}, &options.CountOptions{}); err == nil {
+	models := make([]mongo.WriteModel, 0, len(usage))
+	for _, use := range usage {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"hash": use.Hash, "lastusedat": bson.M{"$not": bson.M{"$gte": use.LastUsedAt}}}).
+			SetUpdate(bson.M{"$set": bson.M{"lastusedat": use.LastUsedAt, "lastusedip": use.LastUsedIP}}))
+	}
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("apikeys", nil).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	}))
+}
+
+//DeleteTag Deletes a tag that has no assigned docs. If this function is called on with a tagid that is in active use an error is returned.
+func (data *MongoStore) DeleteTag(ctx context.Context, tagID string) error {
+	return data.retryableQuery(ctx, func(ctx context.Context) error {
+		if result, err := data.db.Collection("pages", nil).CountDocuments(ctx, bson.M{"tags": tagID}, &options.CountOptions{}); err == nil {
 			if result > 0 {
 				return errors.New("this tag is still assigned to pages in a notebook")
 			} else {
-				_, err := data.db.Collection("tags", nil).DeleteOne(context.Background(), bson.M{"tagid": tagID}, &options.DeleteOptions{})
+				_, err := data.db.Collection("tags", nil).DeleteOne(ctx, bson.M{"tagid": tagID}, &options.DeleteOptions{})
 				if err == nil {
-					data.Cache.DeleteString("notescache", "tags")
+					data.cache.DeleteString("notescache", "tags")
 					return nil
 				}
 				return err
@@ -554,107 +389,107 @@ func (data *DataStore) DeleteTag(tagID string) error {
 	})
 }
 
-//DeleteNotebook ...
-func (data *DataStore) DeleteNotebook(id string) ([]Page, error) {
-	if err := data.checkConnection(); err != nil {
-		return nil, err
-	}
-	var notebook Notebook
-	err := data.retryableQuery(func() error {
-		r := data.db.Collection("notebooks", nil).FindOne(context.Background(), bson.M{"id": id}, &options.FindOneOptions{})
-		if r.Err() != nil {
-			return common.LogError("", r.Err())
-		}
+//DeleteNotebook Deletes the notebook along with its pages and their reminders, comments and share links, all or
+//nothing. Returns the deleted pages.
+func (data *MongoStore) DeleteNotebook(ctx context.Context, id string) ([]Page, error) {
+	var pages []Page
+	var notebook struct {
+		Owner string `bson:"owner"`
+	}
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		return data.withTransaction(ctx, func(ctx context.Context, undo *compensation) error {
+			notebooks, pagesCollection, sharedPages := data.db.Collection("notebooks", nil), data.db.Collection("pages", nil), data.db.Collection("sharedpages", nil)
+			if err := notebooks.FindOne(ctx, bson.M{"id": id}, &options.FindOneOptions{}).Decode(&notebook); err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			}
 
-		if err := r.Decode(&notebook); err != nil {
-			return common.LogError("", err)
-		}
+			links, err := findRaw(ctx, sharedPages, bson.M{"notebookid": id})
+			if err != nil {
+				return err
+			}
+			if _, err := sharedPages.DeleteMany(ctx, bson.M{"notebookid": id}, &options.DeleteOptions{}); err != nil {
+				return err
+			}
+			undo.add(restoreDocs(sharedPages, links...))
 
-		if r, err := data.db.Collection("sharedpages", nil).DeleteMany(context.Background(), bson.M{"notebookid": id}, &options.DeleteOptions{}); err != nil {
-			if r == nil && err != nil {
-				return common.LogError("", err)
+			if err := deleteRestorable(ctx, undo, data.db.Collection("reminders", nil), bson.M{"notebookid": id}); err != nil {
+				return err
+			}
+			if err := deleteRestorable(ctx, undo, data.db.Collection("comments", nil), bson.M{"notebookid": id}); err != nil {
+				return err
 			}
-		}
 
-		dr := data.db.Collection("notebooks", nil).FindOneAndDelete(context.Background(), bson.M{"id": id}, &options.FindOneAndDeleteOptions{})
-		return dr.Err()
+			rawPages, err := findRaw(ctx, pagesCollection, bson.M{"notebookid": id})
+			if err != nil {
+				return err
+			}
+			pages = make([]Page, len(rawPages))
+			for i, raw := range rawPages {
+				if err := bson.Unmarshal(raw, &pages[i]); err != nil {
+					return err
+				}
+			}
+			if _, err := pagesCollection.DeleteMany(ctx, bson.M{"notebookid": id}, &options.DeleteOptions{}); err != nil {
+				return err
+			}
+			undo.add(restoreDocs(pagesCollection, rawPages...))
+
+			return notebooks.FindOneAndDelete(ctx, bson.M{"id": id}, &options.FindOneAndDeleteOptions{}).Err()
+		})
 	})
 	if err != nil {
 		return nil, common.LogError("", err)
 	} else {
-		return notebook.Pages, nil
+		data.cache.DeleteString(notebookRefsCache, notebook.Owner)
+		for _, page := range pages {
+			invalidatePages(data.cache, page.ID)
+		}
+		return pages, nil
 	}
 }
 
 //DeleteSharedPage ...
-func (data *DataStore) DeleteSharedPage(sharedPageID, username string) (bool, error) {
+func (data *MongoStore) DeleteSharedPage(ctx context.Context, sharedPageID, username string) (bool, error) {
 	var queryResult bool
-	if err := data.checkConnection(); err != nil {
-		return false, err
-	}
-	err := data.retryableQuery(func() error {
-		dr := data.db.Collection("sharedpages", nil).FindOneAndDelete(context.Background(), bson.M{"id": sharedPageID, "owner": username}, &options.FindOneAndDeleteOptions{})
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		dr := data.db.Collection("sharedpages", nil).FindOneAndDelete(ctx, bson.M{"id": sharedPageID, "owner": username}, &options.FindOneAndDeleteOptions{})
 		queryResult = dr.Err() == nil
+		if dr.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such shared page"}
+		}
 		return dr.Err()
 	})
 	return queryResult, common.LogError("", err)
 }
 
-//UpdatePage ...
-func (data *DataStore) UpdatePage(notebookID string, value Page) (bool, error) {
-	var updateResult bool
-	if err := data.checkConnection(); err != nil {
-		return false, err
-	}
-	value.LastEdited = common.UnixTimestampInMS()
-
-	err := data.retryableQuery(func() error {
-		dr := data.db.Collection("notebooks", nil).FindOneAndUpdate(context.Background(), bson.M{"id": notebookID, "pages.id": value.ID},
-			bson.M{"$set": bson.M{"pages.$": value}}, &options.FindOneAndUpdateOptions{})
-		updateResult = dr.Err() == nil
-		return dr.Err()
-	})
-
-	return updateResult, common.LogError("", err)
-}
-
-func (data *DataStore) retryableQuery(queryFunc func() error) error {
-	common.LogDebug("", "", "first try")
-	if err := queryFunc(); err != nil {
-		switch wrappedErr := err.(type) {
-		case topology.ConnectionError:
-			_, ok := wrappedErr.Wrapped.(*auth.Error)
-			if ok {
-				common.LogDebug("", "", "reauth required")
-				common.LogError("", data.mongo.Disconnect(context.Background()))
-				if err := data.ConnectToMongoDB(); err != nil {
-					return err
-				}
-				return queryFunc()
-			}
-		case mongo.CommandError:
-			if wrappedErr.Name == "Unauthorized" {
-				common.LogDebug("", "", "reauth required")
-				common.LogError("", data.mongo.Disconnect(context.Background()))
-				if err := data.ConnectToMongoDB(); err != nil {
-					return common.LogError("reauth", err)
-				}
-				return queryFunc()
+//retryableQuery Runs queryFunc with a context bounded by the "dbTimeout" config option, retrying failures that are
+//the database's fault (see isRetryableMongoError) as the retry policy allows. Expired credentials are swapped for
+//fresh ones from Vault before the next attempt. Fails fast without trying if the database's circuit breaker is open.
+func (data *MongoStore) retryableQuery(ctx context.Context, queryFunc func(ctx context.Context) error) error {
+	ctx, cancel := common.WithTimeout(ctx, common.CurrentConfig.DBTimeoutMS, defaultDBTimeout)
+	defer cancel()
+	err := data.retry.Do(ctx, data.breaker, func() error {
+		err := queryFunc(ctx)
+		if isMongoAuthError(err) || err == mongo.ErrClientDisconnected {
+			common.LogDebug("", "", "reconnect required")
+			common.LogError("", data.mongo.Disconnect(ctx))
+			if err := data.ConnectToMongoDB(ctx); err != nil {
+				return common.LogError("reconnect", err)
 			}
-			break
-		default:
-			common.LogInfo("errorType", reflect.TypeOf(wrappedErr), wrappedErr)
-			return err
 		}
-	}
-	return nil
+		return err
+	})
+	return common.ContextError("database", ctx, err)
 }
-func (data *DataStore) insertUniqueItem(collectionName string, doc interface{}, criteriaForCheck bson.M) (bool, error) {
+
+func (data *MongoStore) insertUniqueItem(ctx context.Context, collectionName string, doc interface{}, criteriaForCheck bson.M) (bool, error) {
 	var insertResult bool
-	err := data.retryableQuery(func() error {
-		result := data.db.Collection(collectionName, nil).FindOne(context.Background(), criteriaForCheck, &options.FindOneOptions{})
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection(collectionName, nil).FindOne(ctx, criteriaForCheck, &options.FindOneOptions{})
 		if result.Err() == mongo.ErrNoDocuments {
-			if _, err := data.db.Collection(collectionName, nil).InsertOne(context.Background(), doc, &options.InsertOneOptions{}); err != nil {
+			if _, err := data.db.Collection(collectionName, nil).InsertOne(ctx, doc, &options.InsertOneOptions{}); err != nil {
 				insertResult = false
 				return err
 			}
@@ -667,10 +502,10 @@ func (data *DataStore) insertUniqueItem(collectionName string, doc interface{},
 	})
 	return insertResult, err
 }
-func (data *DataStore) insertItem(collectionName string, item interface{}) (bool, error) {
+func (data *MongoStore) insertItem(ctx context.Context, collectionName string, item interface{}) (bool, error) {
 	var result bool
-	err := data.retryableQuery(func() error {
-		if _, err := data.db.Collection(collectionName, nil).InsertOne(context.Background(), item, &options.InsertOneOptions{}); err != nil {
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		if _, err := data.db.Collection(collectionName, nil).InsertOne(ctx, item, &options.InsertOneOptions{}); err != nil {
 			result = false
 			return err
 		}
@@ -679,24 +514,34 @@ func (data *DataStore) insertItem(collectionName string, item interface{}) (bool
 	})
 	return result, err
 }
-func (data *DataStore) checkConnection() error {
-	if err := data.mongo.Ping(context.Background(), &readpref.ReadPref{}); err != nil {
-		switch wrappedErr := err.(type) {
-		case topology.ConnectionError:
-			_, ok := wrappedErr.Wrapped.(*auth.Error)
-			if ok {
-				if err := data.ConnectToMongoDB(); err != nil {
-					return err
-				}
-			}
-		}
-		if err == mongo.ErrClientDisconnected {
-			if err := data.ConnectToMongoDB(); err != nil {
-				return err
+
+//isRetryableMongoError Network trouble, a primary that's stepped down, a write conflict with a concurrent transaction
+//and expired credentials are all worth another try. Anything else is the query's own fault.
+func isRetryableMongoError(err error) bool {
+	switch wrappedErr := err.(type) {
+	case topology.ConnectionError:
+		return true
+	case mongo.CommandError:
+		return wrappedErr.Code == mongoWriteConflict || wrappedErr.HasErrorLabel("TransientTransactionError") ||
+			wrappedErr.HasErrorLabel("RetryableWriteError") || wrappedErr.HasErrorLabel("NetworkError") || isMongoAuthError(err)
+	case mongo.WriteException:
+		for _, e := range wrappedErr.WriteErrors {
+			if e.Code == mongoWriteConflict {
+				return true
 			}
-		} else {
-			return err
 		}
+		return wrappedErr.HasErrorLabel("RetryableWriteError")
 	}
-	return nil
+	return err == mongo.ErrClientDisconnected || (err != nil && strings.HasPrefix(err.Error(), "server selection error"))
+}
+
+func isMongoAuthError(err error) bool {
+	switch wrappedErr := err.(type) {
+	case topology.ConnectionError:
+		_, ok := wrappedErr.Wrapped.(*auth.Error)
+		return ok
+	case mongo.CommandError:
+		return wrappedErr.Name == "Unauthorized"
+	}
+	return false
 }
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//Migration One ordered change to the shape of the database. Migrations are applied in ID order and each one is only
+//ever applied once, so once one has shipped it shouldn't be changed, add a new one instead.
+type Migration struct {
+	ID   int
+	Name string
+	Up   func(m *Migrator) error
+}
+
+const (
+	migrationRunning = "running"
+	migrationApplied = "applied"
+
+	//staleMigrationTimeout How long a migration can be "running" before it's assumed whoever was running it crashed,
+	//and another instance takes it over.
+	staleMigrationTimeout = 30 * time.Minute
+	migrationPollInterval = 5 * time.Second
+)
+
+//AppliedMigration What gets recorded in the "migrations" collection. Only a migration whose status is "applied" has
+//been applied, a "running" one is still being applied (or was, by an instance that crashed part way through).
+type AppliedMigration struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	StartedAt int64  `json:"startedAt"`
+	AppliedAt int64  `json:"appliedAt"`
+}
+
+//Migrator Handed to each migration. Every change a migration makes goes through Step so a dry run can describe it
+//instead of doing it.
+type Migrator struct {
+	DB      *mongo.Database
+	DryRun  bool
+	Plan    []string
+	current Migration
+}
+
+//Step Runs fn, unless this is a dry run, and adds description to the plan.
+func (m *Migrator) Step(description string, fn func() error) error {
+	m.Plan = append(m.Plan, fmt.Sprintf("%03d %s: %s", m.current.ID, m.current.Name, description))
+	if m.DryRun {
+		return nil
+	}
+	common.LogInfo("migration", m.current.Name, description)
+	return fn()
+}
+
+var migrations = []Migration{
+	{ID: 1, Name: "split-notebook-pages", Up: splitNotebookPages},
+	{ID: 2, Name: "ensure-indexes", Up: ensureIndexes},
+	{ID: 3, Name: "normalize-sharedpage-fields", Up: normalizeSharedPageFields},
+	{ID: 4, Name: "index-users", Up: indexUsers},
+	{ID: 5, Name: "index-twofactor", Up: indexTwoFactor},
+	{ID: 6, Name: "split-apikey-scopes", Up: splitAPIKeyScopes},
+	{ID: 7, Name: "index-audit", Up: indexAudit},
+}
+
+//Migrate Applies every migration that hasn't been applied yet, in order. Returns what was (or with dryRun set, what
+//would be) done. A migration another instance is in the middle of applying is waited for rather than run twice, unless
+//it's been running for longer than staleMigrationTimeout, in which case it's taken over and run again.
+func (data *MongoStore) Migrate(dryRun bool) ([]string, error) {
+	history := data.db.Collection("migrations", nil)
+	if !dryRun {
+		if _, err := history.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)}); err != nil {
+			return nil, common.LogError("", err)
+		}
+	}
+
+	applied := map[int]bool{}
+	r, err := history.Find(context.Background(), bson.M{}, options.Find().SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return nil, common.LogError("", err)
+	}
+	var records []AppliedMigration
+	if err := r.All(context.Background(), &records); err != nil {
+		return nil, common.LogError("", err)
+	}
+	for _, record := range records {
+		applied[record.ID] = record.Status == migrationApplied
+	}
+
+	m := &Migrator{DB: data.db, DryRun: dryRun}
+	for _, migration := range migrations {
+		if applied[migration.ID] {
+			continue
+		}
+		m.current = migration
+		if dryRun {
+			if err := migration.Up(m); err != nil {
+				return m.Plan, err
+			}
+			continue
+		}
+
+		if claimed, err := claimMigration(history, migration); err != nil {
+			return m.Plan, common.LogError(migration.Name, err)
+		} else if !claimed {
+			continue
+		}
+		if err := migration.Up(m); err != nil {
+			history.DeleteOne(context.Background(), bson.M{"id": migration.ID}, &options.DeleteOptions{})
+			return m.Plan, common.LogError(migration.Name, err)
+		}
+		_, err = history.UpdateOne(context.Background(), bson.M{"id": migration.ID},
+			bson.M{"$set": bson.M{"status": migrationApplied, "appliedat": common.UnixTimestampInMS()}}, &options.UpdateOptions{})
+		if err != nil {
+			return m.Plan, common.LogError("", err)
+		}
+	}
+	return m.Plan, nil
+}
+
+//claimMigration Records that migration is running here. If it's already running somewhere else this waits for that to
+//finish, and returns false if it was applied there. A record that's been running for longer than staleMigrationTimeout
+//(or has no start time, like the ones written before it was recorded) was left behind by an instance that crashed, so
+//it's taken over.
+func claimMigration(history *mongo.Collection, migration Migration) (bool, error) {
+	ctx := context.Background()
+	for {
+		now := common.UnixTimestampInMS()
+		_, err := history.InsertOne(ctx, AppliedMigration{ID: migration.ID, Name: migration.Name, Status: migrationRunning, StartedAt: now}, &options.InsertOneOptions{})
+		if err == nil {
+			return true, nil
+		} else if !isDuplicateKeyError(err) {
+			return false, err
+		}
+
+		var record AppliedMigration
+		if err := history.FindOne(ctx, bson.M{"id": migration.ID}, &options.FindOneOptions{}).Decode(&record); err == mongo.ErrNoDocuments {
+			//It failed wherever it was running and the record was removed, try to claim it again.
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		switch {
+		case record.Status == migrationApplied:
+			return false, nil
+		case record.Status != migrationRunning:
+			return false, errors.New("unknown migration status " + record.Status)
+		case now-record.StartedAt > staleMigrationTimeout.Milliseconds():
+			filter := bson.M{"id": migration.ID, "status": migrationRunning, "startedat": record.StartedAt}
+			if record.StartedAt == 0 {
+				filter["startedat"] = bson.M{"$in": bson.A{0, nil}}
+			}
+			r, err := history.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"startedat": now}}, &options.UpdateOptions{})
+			if err != nil {
+				return false, err
+			} else if r.ModifiedCount == 1 {
+				common.LogWarn("migration", migration.Name, "was left running by an instance that didn't finish it, running it again")
+				return true, nil
+			}
+		default:
+			common.LogInfo("migration", migration.Name, "being applied somewhere else, waiting for it")
+			time.Sleep(migrationPollInterval)
+		}
+	}
+}
+
+//splitNotebookPages Moves pages still embedded in notebook documents into the pages collection. Pages are upserted by
+//id so if this gets interrupted running it again picks up where it left off.
+func splitNotebookPages(m *Migrator) error {
+	r, err := m.DB.Collection("notebooks", nil).Find(context.Background(), bson.M{"pages.0": bson.M{"$exists": true}}, options.Find().SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return err
+	}
+	var notebooks []Notebook
+	if err := r.All(context.Background(), &notebooks); err != nil {
+		return err
+	}
+	if len(notebooks) == 0 {
+		return m.Step("no notebooks with embedded pages", func() error { return nil })
+	}
+	for _, notebook := range notebooks {
+		notebook := notebook
+		err := m.Step(fmt.Sprintf("move %d page(s) out of notebook %s", len(notebook.Pages), notebook.ID), func() error {
+			for _, page := range notebook.Pages {
+				page.NotebookID = notebook.ID
+				_, err := m.DB.Collection("pages", nil).UpdateOne(context.Background(), bson.M{"id": page.ID}, bson.M{"$setOnInsert": page}, options.Update().SetUpsert(true))
+				if err != nil {
+					return err
+				}
+			}
+			_, err := m.DB.Collection("notebooks", nil).UpdateOne(context.Background(), bson.M{"id": notebook.ID}, bson.M{"$unset": bson.M{"pages": ""}}, &options.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureIndexes(m *Migrator) error {
+	unique := func(keys ...string) mongo.IndexModel {
+		model := index(keys...)
+		model.Options = options.Index().SetUnique(true)
+		return model
+	}
+	indexes := map[string][]mongo.IndexModel{
+		"notebooks":         {unique("id"), index("owner")},
+		"pages":             {unique("id"), unique("notebookid", "title"), index("notebookid", "lastedited", "id"), index("tags")},
+		"apikeys":           {unique("hash"), index("creator")},
+		"sharedpages":       {unique("accesstoken"), index("pageid"), index("owner")},
+		"tags":              {unique("tagid")},
+		"reminders":         {index("status", "fireat"), index("owner")},
+		"webhookdeliveries": {index("status", "nextattempt"), index("subscriptionid", "createdat")},
+		"comments":          {index("pageid", "createdat")},
+	}
+	for _, collection := range []string{"notebooks", "pages", "apikeys", "sharedpages", "tags", "reminders", "webhookdeliveries", "comments"} {
+		collection := collection
+		err := m.Step(fmt.Sprintf("create %d index(es) on %s", len(indexes[collection]), collection), func() error {
+			_, err := m.DB.Collection(collection, nil).Indexes().CreateMany(context.Background(), indexes[collection])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexUsers(m *Migrator) error {
+	return m.Step("create 1 index(es) on users", func() error {
+		model := index("username")
+		model.Options = options.Index().SetUnique(true)
+		_, err := m.DB.Collection("users", nil).Indexes().CreateOne(context.Background(), model)
+		return err
+	})
+}
+
+func indexTwoFactor(m *Migrator) error {
+	return m.Step("create 1 index(es) on twofactor", func() error {
+		model := index("username")
+		model.Options = options.Index().SetUnique(true)
+		_, err := m.DB.Collection("twofactor", nil).Indexes().CreateOne(context.Background(), model)
+		return err
+	})
+}
+
+func indexAudit(m *Migrator) error {
+	return m.Step("create 4 index(es) on audit", func() error {
+		seq := index("seq")
+		seq.Options = options.Index().SetUnique(true)
+		_, err := m.DB.Collection("audit", nil).Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			seq, index("actor", "seq"), index("action", "seq"), index("resources", "seq"),
+		})
+		return err
+	})
+}
+
+//normalizeSharedPageFields Shared pages are stored with the driver's lowercased field names, but documents written by
+//older versions may have camelCase ones, which none of the queries match.
+func normalizeSharedPageFields(m *Migrator) error {
+	renames := map[string]string{"pageID": "pageid", "pageTitle": "pagetitle", "notebookID": "notebookid", "accessToken": "accesstoken", "allowComments": "allowcomments"}
+	for from, to := range renames {
+		from, to := from, to
+		count, err := m.DB.Collection("sharedpages", nil).CountDocuments(context.Background(), bson.M{from: bson.M{"$exists": true}}, &options.CountOptions{})
+		if err != nil {
+			return err
+		} else if count == 0 {
+			continue
+		}
+		err = m.Step(fmt.Sprintf("rename sharedpages.%s to %s on %d document(s)", from, to, count), func() error {
+			_, err := m.DB.Collection("sharedpages", nil).UpdateMany(context.Background(), bson.M{from: bson.M{"$exists": true}}, bson.M{"$rename": bson.M{from: to}}, &options.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//splitAPIKeyScopes API keys used to keep their scopes in one comma separated string, they're a list now. Keys are
+//upgraded when they're read anyway, this just saves doing it every time.
+func splitAPIKeyScopes(m *Migrator) error {
+	r, err := m.DB.Collection("apikeys", nil).Find(context.Background(), bson.M{"scopes": bson.M{"$type": "string"}}, options.Find().SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return err
+	}
+	var keys []UserAPIKey
+	if err := r.All(context.Background(), &keys); err != nil {
+		return err
+	}
+	return m.Step(fmt.Sprintf("split scopes on %d api key(s)", len(keys)), func() error {
+		for _, key := range keys {
+			upgradeAPIKey(&key)
+			_, err := m.DB.Collection("apikeys", nil).UpdateOne(context.Background(), bson.M{"hash": key.Hash},
+				bson.M{"$set": bson.M{"scopelist": key.ScopeList}, "$unset": bson.M{"scopes": ""}}, &options.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func index(keys ...string) mongo.IndexModel {
+	spec := bson.D{}
+	for _, key := range keys {
+		spec = append(spec, bson.E{Key: key, Value: 1})
+	}
+	return mongo.IndexModel{Keys: spec}
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//NewPage ...
+func (data *MongoStore) NewPage(ctx context.Context, page Page, notebookID string) error {
+	page.NotebookID = notebookID
+	page.LastEdited = common.UnixTimestampInMS()
+
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		return data.withTransaction(ctx, func(ctx context.Context, undo *compensation) error {
+			notebooks, pages := data.db.Collection("notebooks", nil), data.db.Collection("pages", nil)
+			//Writing to the notebook (rather than just counting it) makes a DeleteNotebook running at the same time
+			//conflict with this transaction instead of leaving the new page orphaned.
+			if r, err := notebooks.UpdateOne(ctx, bson.M{"id": notebookID}, bson.M{"$set": bson.M{"pagesupdatedat": page.LastEdited}}, &options.UpdateOptions{}); err != nil {
+				return err
+			} else if r.MatchedCount == 0 {
+				return ErrNotFound
+			}
+			if count, err := pages.CountDocuments(ctx, bson.M{"notebookid": notebookID, "title": page.Title}, &options.CountOptions{}); err != nil {
+				return err
+			} else if count > 0 {
+				return errPageTitleTaken
+			}
+
+			_, err := pages.InsertOne(ctx, page, &options.InsertOneOptions{})
+			if isDuplicateKeyError(err) {
+				return errPageTitleTaken
+			} else if err != nil {
+				return common.LogError("", err)
+			}
+			undo.add(func(ctx context.Context) error {
+				_, err := pages.DeleteOne(ctx, bson.M{"id": page.ID}, &options.DeleteOptions{})
+				return err
+			})
+
+			//Without a transaction the notebook could've been deleted between the check above and the insert.
+			if count, err := notebooks.CountDocuments(ctx, bson.M{"id": notebookID}, &options.CountOptions{}); err != nil {
+				return err
+			} else if count == 0 {
+				return ErrNotFound
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		invalidatePages(data.cache, page.ID)
+	}
+	return err
+}
+
+//GetNotebookPages Returns the notebook's pages sorted and paged as described by query.
+func (data *MongoStore) GetNotebookPages(ctx context.Context, notebookID, creator string, query PageQuery) (list PageList, e error) {
+	cursor, err := query.validate()
+	if err != nil {
+		return PageList{}, err
+	}
+
+	field, dir, cmp := "title", 1, "$gt"
+	if query.SortBy == SortByLastEdited {
+		field = "lastedited"
+	}
+	if query.Descending {
+		dir, cmp = -1, "$lt"
+	}
+	filter := bson.M{"notebookid": notebookID}
+	if query.Cursor != "" {
+		var value interface{} = cursor.Title
+		if query.SortBy == SortByLastEdited {
+			value = cursor.LastEdited
+		}
+		filter["$or"] = []bson.M{{field: bson.M{cmp: value}}, {field: value, "id": bson.M{cmp: cursor.ID}}}
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.D{{Key: field, Value: dir}, {Key: "id", Value: dir}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit + 1)
+	}
+
+	e = data.retryableQuery(ctx, func(ctx context.Context) error {
+		if count, err := data.db.Collection("notebooks", nil).CountDocuments(ctx, bson.M{"id": notebookID, "owner": creator}, &options.CountOptions{}); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrNotFound
+		}
+		r, err := data.db.Collection("pages", nil).Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		list.Pages = nil
+		return r.All(ctx, &list.Pages)
+	})
+	if e != nil {
+		return PageList{}, common.LogError("GetNotebookPages", e)
+	}
+	return list.trim(query.Limit), nil
+}
+
+//GetPageByID Returns the page's metadata, from the cache if it's there. An empty notebookID matches any notebook.
+func (data *MongoStore) GetPageByID(ctx context.Context, pageID, notebookID string) (page Page, e error) {
+	//Page IDs are unique, so the page is cached by ID alone and checked against notebookID afterwards.
+	e = readThrough(data.cache, pageCache, pageID, &page, func() error {
+		return data.retryableQuery(ctx, func(ctx context.Context) error {
+			result := data.db.Collection("pages", nil).FindOne(ctx, bson.M{"id": pageID}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+			if result.Err() == mongo.ErrNoDocuments {
+				return ErrNotFound
+			} else if result.Err() != nil {
+				return result.Err()
+			}
+			return result.Decode(&page)
+		})
+	})
+	if e == nil && notebookID != "" && page.NotebookID != notebookID {
+		return Page{}, common.LogError("", ErrNotFound)
+	}
+	return page, common.LogError("", e)
+}
+
+//GetPageCreator Returns the username of the page's creator, from the cache if it's there.
+func (data *MongoStore) GetPageCreator(ctx context.Context, pageID string) (creator string, err error) {
+	err = readThrough(data.cache, pageOwnerCache, pageID, &creator, func() error {
+		page, err := data.GetPageByID(ctx, pageID, "")
+		creator = page.Creator
+		return err
+	})
+	return creator, err
+}
+
+//GetPagesWithTags Returns pagerefs of docs matching the 'tag(s)' specified
+func (data *MongoStore) GetPagesWithTags(ctx context.Context, tags []string, notebookID string) (pages []Page, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("pages", nil).Find(ctx, bson.M{"notebookid": notebookID, "tags": bson.M{"$all": tags}}, options.Find().SetProjection(bson.M{"_id": 0}))
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &pages)
+	})
+	return pages, common.LogError("", err)
+}
+
+//UpdatePage Replaces the page's metadata. Like NewPage, it won't give the page a title another page in the notebook has.
+func (data *MongoStore) UpdatePage(ctx context.Context, notebookID string, value Page) (bool, error) {
+	var updateResult bool
+	value.NotebookID = notebookID
+	value.LastEdited = common.UnixTimestampInMS()
+
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		pages := data.db.Collection("pages", nil)
+		if count, err := pages.CountDocuments(ctx, bson.M{"notebookid": notebookID, "title": value.Title, "id": bson.M{"$ne": value.ID}}, &options.CountOptions{}); err != nil {
+			return err
+		} else if count > 0 {
+			return errPageTitleTaken
+		}
+		dr := pages.FindOneAndReplace(ctx, bson.M{"id": value.ID, "notebookid": notebookID}, value, &options.FindOneAndReplaceOptions{})
+		updateResult = dr.Err() == nil
+		if dr.Err() == mongo.ErrNoDocuments {
+			return ErrNotFound
+		} else if isDuplicateKeyError(dr.Err()) {
+			//Another page got the title between the check and the replace.
+			return errPageTitleTaken
+		}
+		return dr.Err()
+	})
+	if updateResult {
+		invalidatePages(data.cache, value.ID)
+	}
+
+	return updateResult, common.LogError("", err)
+}
+
+//DeletePage Deletes the Page with the ID specified along with its reminders, its comments and any share links pointing
+//at it, all or nothing.
+func (data *MongoStore) DeletePage(ctx context.Context, pageID, notebookID string) error {
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		return data.withTransaction(ctx, func(ctx context.Context, undo *compensation) error {
+			pages, sharedPages := data.db.Collection("pages", nil), data.db.Collection("sharedpages", nil)
+			deleted, err := pages.FindOneAndDelete(ctx, bson.M{"id": pageID, "notebookid": notebookID}, &options.FindOneAndDeleteOptions{}).DecodeBytes()
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			} else if err != nil {
+				return err
+			}
+			undo.add(restoreDocs(pages, deleted))
+
+			if err := deleteRestorable(ctx, undo, data.db.Collection("reminders", nil), bson.M{"pageid": pageID}); err != nil {
+				return err
+			}
+			if err := deleteRestorable(ctx, undo, data.db.Collection("comments", nil), bson.M{"pageid": pageID}); err != nil {
+				return err
+			}
+			_, err = sharedPages.DeleteMany(ctx, bson.M{"pageid": pageID}, &options.DeleteOptions{})
+			return err
+		})
+	})
+	if err == nil {
+		invalidatePages(data.cache, pageID)
+	}
+	return err
+}
+
+func isDuplicateKeyError(err error) bool {
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+const (
+	//The cache keys read-through values are stored under, also the names their counters are reported by.
+	notebookRefsCache = "nbrefs"
+	pageCache         = "pagemd"
+	pageOwnerCache    = "pageowner"
+
+	//readCacheTTL How long (in seconds) a read-through value lives if nothing invalidates it first. Bounds how stale a
+	//value can get if a read races a write and caches what it read after the write invalidated it.
+	readCacheTTL = 300
+)
+
+//CacheStats How often lookups of one kind of value were answered from the cache, and how often they had to go to the
+//database.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+var readCacheCounters = map[string]*CacheStats{
+	notebookRefsCache: {},
+	pageCache:         {},
+	pageOwnerCache:    {},
+}
+
+//ReadCacheStats Returns the hit/miss counters of every kind of read-through cached value since startup, keyed by kind.
+func ReadCacheStats() map[string]CacheStats {
+	stats := make(map[string]CacheStats, len(readCacheCounters))
+	for kind, counter := range readCacheCounters {
+		stats[kind] = CacheStats{Hits: atomic.LoadUint64(&counter.Hits), Misses: atomic.LoadUint64(&counter.Misses)}
+	}
+	return stats
+}
+
+//readThrough Fills value from the cache entry kind:field if there is one, otherwise calls load to fill it from the
+//database and caches the result. Nothing is cached if load fails.
+func readThrough(cache CacheService, kind, field string, value interface{}, load func() error) error {
+	if cached := cache.GetString(kind, field); cached != "" && json.Unmarshal([]byte(cached), value) == nil {
+		atomic.AddUint64(&readCacheCounters[kind].Hits, 1)
+		return nil
+	}
+	atomic.AddUint64(&readCacheCounters[kind].Misses, 1)
+	if err := load(); err != nil {
+		return err
+	}
+	if encoded, err := json.Marshal(value); err == nil {
+		cache.PutStringWithExpiration(kind, field, string(encoded), readCacheTTL)
+	}
+	return nil
+}
+
+//invalidatePages Drops the cached metadata and owner of each of the pages.
+func invalidatePages(cache CacheService, pageIDs ...string) {
+	for _, pageID := range pageIDs {
+		cache.DeleteString(pageCache, pageID)
+		cache.DeleteString(pageOwnerCache, pageID)
+	}
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//NewReminder ...
+func (data *MongoStore) NewReminder(ctx context.Context, reminder Reminder) error {
+	_, err := data.insertItem(ctx, "reminders", reminder)
+	return common.LogError("", err)
+}
+
+//GetReminders Returns every reminder owned by username that hasn't been cancelled.
+func (data *MongoStore) GetReminders(ctx context.Context, username string) (reminders []Reminder, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"fireat": 1})
+		r, err := data.db.Collection("reminders", nil).Find(ctx, bson.M{"owner": username, "status": bson.M{"$ne": ReminderCancelled}}, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &reminders)
+	})
+	return reminders, common.LogError("", err)
+}
+
+//GetReminder ...
+func (data *MongoStore) GetReminder(ctx context.Context, id, username string) (reminder Reminder, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("reminders", nil).FindOne(ctx, bson.M{"id": id, "owner": username}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+		if result.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such reminder"}
+		} else if result.Err() != nil {
+			return result.Err()
+		}
+		return result.Decode(&reminder)
+	})
+	return reminder, err
+}
+
+//GetDueReminders Returns pending reminders that are scheduled to fire at or before the provided time (unix ms).
+func (data *MongoStore) GetDueReminders(ctx context.Context, before int64) (reminders []Reminder, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"fireat": 1})
+		r, err := data.db.Collection("reminders", nil).Find(ctx, bson.M{"status": ReminderPending, "fireat": bson.M{"$lte": before}}, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &reminders)
+	})
+	return reminders, common.LogError("", err)
+}
+
+//ClaimReminder Moves a pending reminder into the sending state. Returns false if some other scheduler got to it first.
+func (data *MongoStore) ClaimReminder(ctx context.Context, id string) (bool, error) {
+	var claimed bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r := data.db.Collection("reminders", nil).FindOneAndUpdate(ctx, bson.M{"id": id, "status": ReminderPending},
+			bson.M{"$set": bson.M{"status": ReminderSending, "claimedat": common.UnixTimestampInMS()}}, &options.FindOneAndUpdateOptions{})
+		if r.Err() == mongo.ErrNoDocuments {
+			claimed = false
+			return nil
+		}
+		claimed = r.Err() == nil
+		return r.Err()
+	})
+	return claimed, common.LogError("", err)
+}
+
+//FinishReminder Records the outcome of an attempt to fire a reminder. A pending status with a new fireAt reschedules it.
+func (data *MongoStore) FinishReminder(ctx context.Context, id string, status ReminderStatus, attempts int, fireAt int64) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("reminders", nil).UpdateOne(ctx, bson.M{"id": id},
+			bson.M{"$set": bson.M{"status": status, "attempts": attempts, "fireat": fireAt, "claimedat": 0}}, &options.UpdateOptions{})
+		return err
+	}))
+}
+
+//ReleaseStuckReminders Returns reminders that were claimed before "claimedBefore" but never finished (ie. the process
+//died mid-send) to the pending state so they get picked up again.
+func (data *MongoStore) ReleaseStuckReminders(ctx context.Context, claimedBefore int64) (int64, error) {
+	var released int64
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("reminders", nil).UpdateMany(ctx, bson.M{"status": ReminderSending, "claimedat": bson.M{"$lt": claimedBefore}},
+			bson.M{"$set": bson.M{"status": ReminderPending, "claimedat": 0}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		released = r.ModifiedCount
+		return nil
+	})
+	return released, common.LogError("", err)
+}
+
+//SnoozeReminder Pushes a reminder's fire time back to fireAt and makes it pending again.
+func (data *MongoStore) SnoozeReminder(ctx context.Context, id, username string, fireAt int64) (bool, error) {
+	var updated bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("reminders", nil).UpdateOne(ctx,
+			bson.M{"id": id, "owner": username, "status": bson.M{"$in": []ReminderStatus{ReminderPending, ReminderFired, ReminderFailed}}},
+			bson.M{"$set": bson.M{"status": ReminderPending, "fireat": fireAt, "attempts": 0}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		updated = r.MatchedCount > 0
+		return nil
+	})
+	return updated, common.LogError("", err)
+}
+
+//CancelReminder ...
+func (data *MongoStore) CancelReminder(ctx context.Context, id, username string) (bool, error) {
+	var updated bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("reminders", nil).UpdateOne(ctx, bson.M{"id": id, "owner": username, "status": bson.M{"$ne": ReminderCancelled}},
+			bson.M{"$set": bson.M{"status": ReminderCancelled}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		updated = r.MatchedCount > 0
+		return nil
+	})
+	return updated, common.LogError("", err)
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.alargerobot.dev/notebook/common"
+)
+
+//ScopeList Older clients send scopes as a comma separated string, which is still accepted.
+type ScopeList []string
+
+//UnmarshalJSON ...
+func (s *ScopeList) UnmarshalJSON(raw []byte) error {
+	var joined string
+	if err := json.Unmarshal(raw, &joined); err == nil {
+		*s = splitScopes(joined)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+//ReadOnly Keys limited to tagged pages can't change anything.
+func (c APIKeyConstraints) ReadOnly() bool {
+	return len(c.Tags) > 0
+}
+
+//AllowsNotebook ...
+func (c APIKeyConstraints) AllowsNotebook(notebookID string) bool {
+	return len(c.Notebooks) == 0 || common.Contains(c.Notebooks, notebookID)
+}
+
+//AllowsPage The page's notebook has to be allowed, and if the key is limited to tags the page needs one of them.
+func (c APIKeyConstraints) AllowsPage(page Page) bool {
+	if !c.AllowsNotebook(page.NotebookID) {
+		return false
+	}
+	if len(c.Tags) == 0 {
+		return true
+	}
+	for _, tag := range page.Tags {
+		if common.Contains(c.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+//upgradeAPIKey Moves the scopes of keys stored before they were a list into ScopeList.
+func upgradeAPIKey(key *UserAPIKey) {
+	if len(key.ScopeList) == 0 && key.Scopes != "" {
+		key.ScopeList = splitScopes(key.Scopes)
+	}
+	key.Scopes = ""
+}
+
+func splitScopes(joined string) ScopeList {
+	scopes := ScopeList{}
+	for _, scope := range strings.Split(joined, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/crypto"
+)
+
+const (
+	//BackendMongo Notebook data lives in MongoDB (default)
+	BackendMongo = "mongo"
+	//BackendEmbedded Notebook data lives in a single local bbolt file, no database server required
+	BackendEmbedded = "embedded"
+
+	defaultEmbeddedDBPath = "notebook.db"
+
+	//SortByTitle ...
+	SortByTitle = "title"
+	//SortByLastEdited ...
+	SortByLastEdited = "lastEdited"
+)
+
+var (
+	//ErrNotFound What was asked for doesn't exist, or isn't in the notebook it was asked for in. Both backends return it
+	//(or a storeError wrapping it) so the API can respond with a 404.
+	ErrNotFound = common.ErrNotFound
+	//ErrConflict What was asked for would clash with something that already exists, like a second page with the same
+	//title in a notebook. Responds with a 409.
+	ErrConflict = common.ErrConflict
+
+	errPageTitleTaken = storeError{ErrConflict, "page with the specified title alrady exists"}
+	errInvalidKeyID   = storeError{ErrNotFound, "provided key ID was invalid"}
+	errKeyExpired     = errors.New("an expired key can't be rotated")
+)
+
+//storeError ErrNotFound or ErrConflict with a message that says what wasn't found or what it clashed with.
+type storeError struct {
+	err     error
+	message string
+}
+
+func (e storeError) Error() string {
+	return e.message
+}
+
+//Unwrap ...
+func (e storeError) Unwrap() error {
+	return e.err
+}
+
+//DataStore Everything the services need from whatever's persisting notebooks, pages, tags, api keys and
+//shared pages (plus reminders, webhooks and comments). Pick a backend with NewDataStore.
+type DataStore interface {
+	NotebookStore
+	PageStore
+	TagStore
+	APIKeyStore
+	SharedPageStore
+	ReminderStore
+	WebhookStore
+	CommentStore
+	UserStore
+	TwoFactorStore
+	AuditStore
+}
+
+//NotebookStore ...
+type NotebookStore interface {
+	NewNotebook(ctx context.Context, notebook Notebook) error
+	GetUserNotebookNames(ctx context.Context, username string) ([]NotebookReference, error)
+	GetNotebookPages(ctx context.Context, notebookID, creator string, query PageQuery) (PageList, error)
+	DeleteNotebook(ctx context.Context, id string) ([]Page, error)
+}
+
+//PageStore ...
+type PageStore interface {
+	NewPage(ctx context.Context, page Page, notebookID string) error
+	GetPageByID(ctx context.Context, pageID, notebookID string) (Page, error)
+	GetPageCreator(ctx context.Context, pageID string) (string, error)
+	GetPagesWithTags(ctx context.Context, tags []string, notebookID string) ([]Page, error)
+	UpdatePage(ctx context.Context, notebookID string, value Page) (bool, error)
+	DeletePage(ctx context.Context, pageID, notebookID string) error
+}
+
+//TagStore ...
+type TagStore interface {
+	NewTag(ctx context.Context, tag PageTag) (PageTag, error)
+	GetTags(ctx context.Context) ([]PageTag, error)
+	IsValidTagID(ctx context.Context, ids []string, username string) (bool, error)
+	DeleteTag(ctx context.Context, tagID string) error
+}
+
+//APIKeyStore ...
+type APIKeyStore interface {
+	NewAPIKey(ctx context.Context, keyRequest NewAPIKeyRequest) (string, error)
+	GetAPIKey(ctx context.Context, keyHash string) (UserAPIKey, error)
+	GetAPIKeys(ctx context.Context, username string) ([]UserAPIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+	RotateAPIKey(ctx context.Context, id, creator string, keyRequest NewAPIKeyRequest, graceUntil int64) (string, error)
+	RecordAPIKeyUsage(ctx context.Context, usage []APIKeyUsage) error
+}
+
+//SharedPageStore ...
+type SharedPageStore interface {
+	NewSharedPage(ctx context.Context, sharedPageReq SharePageRequest, username string) (SharedPage, error)
+	GetSharedPageInfo(ctx context.Context, accessToken string) (SharedPage, error)
+	GetSharedPages(ctx context.Context, username string) ([]SharedPage, error)
+	DeleteSharedPage(ctx context.Context, sharedPageID, username string) (bool, error)
+	SetSharedPageComments(ctx context.Context, sharedPageID, username string, allow bool) error
+}
+
+//ReminderStore ...
+type ReminderStore interface {
+	NewReminder(ctx context.Context, reminder Reminder) error
+	GetReminders(ctx context.Context, username string) ([]Reminder, error)
+	GetReminder(ctx context.Context, id, username string) (Reminder, error)
+	GetDueReminders(ctx context.Context, before int64) ([]Reminder, error)
+	ClaimReminder(ctx context.Context, id string) (bool, error)
+	FinishReminder(ctx context.Context, id string, status ReminderStatus, attempts int, fireAt int64) error
+	ReleaseStuckReminders(ctx context.Context, claimedBefore int64) (int64, error)
+	SnoozeReminder(ctx context.Context, id, username string, fireAt int64) (bool, error)
+	CancelReminder(ctx context.Context, id, username string) (bool, error)
+}
+
+//WebhookStore ...
+type WebhookStore interface {
+	NewWebhook(ctx context.Context, hook WebhookSubscription) error
+	GetWebhooks(ctx context.Context, username string) ([]WebhookSubscription, error)
+	GetWebhooksForEvent(ctx context.Context, username, event string) ([]WebhookSubscription, error)
+	GetWebhook(ctx context.Context, id string) (WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, id, username string) error
+	NewWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, subscriptionID, username string, limit int64) ([]WebhookDelivery, error)
+	GetDueWebhookDeliveries(ctx context.Context, before int64) ([]WebhookDelivery, error)
+	ClaimWebhookDelivery(ctx context.Context, id string) (bool, error)
+	FinishWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error
+	ReleaseStuckWebhookDeliveries(ctx context.Context, claimedBefore int64) error
+}
+
+//CommentStore ...
+type CommentStore interface {
+	NewComment(ctx context.Context, comment PageComment) error
+	GetComments(ctx context.Context, pageID string) ([]PageComment, error)
+	GetComment(ctx context.Context, id, pageID string) (PageComment, error)
+	UpdateCommentBody(ctx context.Context, id, body string) error
+	ResolveCommentThread(ctx context.Context, id string, resolved bool, username string) error
+	DeleteComment(ctx context.Context, id string) error
+}
+
+//UserStore Local accounts, for installs that don't sign in through an identity provider.
+type UserStore interface {
+	NewUser(ctx context.Context, user User) error
+	GetUser(ctx context.Context, username string) (User, error)
+	SetUserPassword(ctx context.Context, username, passHash string, changedAt int64) error
+	SetUserDisabled(ctx context.Context, username string, disabled bool) error
+}
+
+//TwoFactorStore ...
+type TwoFactorStore interface {
+	SaveTwoFactor(ctx context.Context, twoFactor TwoFactor) error
+	GetTwoFactor(ctx context.Context, username string) (TwoFactor, bool, error)
+	AdvanceTwoFactorStep(ctx context.Context, username string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, username, codeHash string) (bool, error)
+	DeleteTwoFactor(ctx context.Context, username string) error
+}
+
+//AuditStore Append only, there's deliberately no way to change or remove entries.
+type AuditStore interface {
+	AppendAuditEntry(ctx context.Context, entry AuditEntry, key []byte) (AuditEntry, error)
+	GetAuditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+}
+
+//NewDataStore Returns the backend selected by the "storage" config option. Anything other than "embedded" gets MongoDB.
+func NewDataStore(vault *crypto.VaultKMS, cache CacheService) DataStore {
+	switch common.CurrentConfig.StorageBackend {
+	case BackendEmbedded:
+		path := common.CurrentConfig.EmbeddedDBPath
+		if path == "" {
+			path = defaultEmbeddedDBPath
+		}
+		store, err := NewBoltStore(path, cache)
+		if err != nil {
+			panic(err)
+		}
+		return store
+	default:
+		return NewMongoStore(vault, cache)
+	}
+}
+
+//Migrate Runs the backend's pending schema migrations (see migrations.go), if it has any. Returns what was done, or with
+//dryRun set what would've been.
+func Migrate(store DataStore, dryRun bool) ([]string, error) {
+	if migratable, ok := store.(interface {
+		Migrate(dryRun bool) ([]string, error)
+	}); ok {
+		return migratable.Migrate(dryRun)
+	}
+	return nil, nil
+}
+
+//newAPIKey Generates a new key for the request. Only the hash is ever stored, the key itself is returned to the caller once.
+func newAPIKey(keyRequest NewAPIKeyRequest) (UserAPIKey, string, error) {
+	var b [20]byte
+	var apiKey UserAPIKey
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return UserAPIKey{}, "", common.LogError("", err)
+	}
+	t := hex.EncodeToString(b[:])
+
+	apiKey.CreatedAt = time.Now().Format("Jan 2, 2006")
+	apiKey.ID = uuid.New().String()
+	apiKey.ScopeList = keyRequest.Scopes
+	apiKey.Constraints = keyRequest.Constraints
+	apiKey.Creator = keyRequest.Creator
+	apiKey.Description = keyRequest.Description
+	apiKey.ExpiresAt = keyRequest.ExpiresAt
+	apiKey.Hash = hex.EncodeToString(common.ToSHA256Bytes([]byte(t)))
+	return apiKey, t, nil
+}
+
+//chainAuditEntry Makes entry the one after prev (the zero value if it's the first), and works out its hash.
+func chainAuditEntry(entry *AuditEntry, prev AuditEntry, key []byte) {
+	entry.Seq, entry.PrevHash, entry.Hash = prev.Seq+1, prev.Hash, ""
+	entry.Hash = AuditHash(*entry, key)
+}
+
+//AuditHash The HMAC (keyed with key) of the entry with its Hash left out, which is what Hash should be. The key isn't
+//kept in the database, so whoever can write to it still can't rewrite entries and work out hashes that check out.
+func AuditHash(entry AuditEntry, key []byte) string {
+	entry.Hash = ""
+	encoded, _ := json.Marshal(entry)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encoded)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSharedPage(sharedPageReq SharePageRequest, username string) SharedPage {
+	return SharedPage{
+		ID:            uuid.New().String(),
+		Owner:         username,
+		PageID:        sharedPageReq.PageID,
+		NotebookID:    sharedPageReq.NotebookID,
+		PageTitle:     sharedPageReq.PageTitle,
+		AccessToken:   common.RandomID(16),
+		AllowComments: sharedPageReq.AllowComments,
+	}
+}
+
+//pageCursor Where the last PageList left off. Both sort keys are kept so a cursor doesn't care which one it's used with.
+type pageCursor struct {
+	Title      string `json:"t,omitempty"`
+	LastEdited int64  `json:"e,omitempty"`
+	ID         string `json:"id"`
+}
+
+//validate Checks the query makes sense and decodes its cursor.
+func (query PageQuery) validate() (cursor pageCursor, err error) {
+	if query.SortBy != "" && query.SortBy != SortByTitle && query.SortBy != SortByLastEdited {
+		return cursor, errors.New("pages can only be sorted by title or lastEdited")
+	}
+	if query.Limit < 0 {
+		return cursor, errors.New("invalid limit")
+	}
+	if query.Cursor == "" {
+		return cursor, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err == nil {
+		err = json.Unmarshal(raw, &cursor)
+	}
+	if err != nil || cursor.ID == "" {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+//trim Drops the extra page fetched to find out if there's anything after this one, and sets NextCursor if there was.
+func (list PageList) trim(limit int64) PageList {
+	if list.Pages == nil {
+		list.Pages = []Page{}
+	}
+	if limit > 0 && int64(len(list.Pages)) > limit {
+		list.Pages = list.Pages[:limit]
+		last := list.Pages[limit-1]
+		raw, _ := json.Marshal(pageCursor{Title: last.Title, LastEdited: last.LastEdited, ID: last.ID})
+		list.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return list
+}
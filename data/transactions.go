@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//compensation The undo steps recorded while a multi-collection write runs without a transaction. If the write fails
+//partway through they're run newest first to put back whatever was already done.
+type compensation struct {
+	steps []func(ctx context.Context) error
+}
+
+//add Records how to undo the write that just succeeded.
+func (undo *compensation) add(step func(ctx context.Context) error) {
+	undo.steps = append(undo.steps, step)
+}
+
+func (undo *compensation) run(ctx context.Context) {
+	for i := len(undo.steps) - 1; i >= 0; i-- {
+		common.LogError("compensation", undo.steps[i](ctx))
+	}
+}
+
+//withTransaction Runs fn inside a multi-document transaction when the deployment supports them (replica sets and sharded
+//clusters), so either all of its writes land or none do. On a standalone server fn runs as is, and if it fails the
+//compensating steps it recorded are run instead. fn must do all of its reads and writes with the ctx it's handed.
+func (data *MongoStore) withTransaction(ctx context.Context, fn func(ctx context.Context, undo *compensation) error) error {
+	if data.supportsTransactions(ctx) {
+		session, err := data.mongo.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(context.Background())
+		_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+			return nil, fn(ctx, &compensation{})
+		})
+		return err
+	}
+
+	undo := &compensation{}
+	if err := fn(ctx, undo); err != nil {
+		//ctx may well be what ran out, the undo still has to happen.
+		undoCtx, cancel := common.WithTimeout(context.Background(), common.CurrentConfig.DBTimeoutMS, defaultDBTimeout)
+		defer cancel()
+		undo.run(undoCtx)
+		return err
+	}
+	return nil
+}
+
+//supportsTransactions Whether the server we're connected to is part of a replica set or a sharded cluster. Only a
+//successful answer is remembered, if the check fails we fall back to compensating and ask again next time.
+func (data *MongoStore) supportsTransactions(ctx context.Context) bool {
+	data.txnLock.Lock()
+	defer data.txnLock.Unlock()
+	if data.txnSupport == nil {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		if err := data.db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+			common.LogError("", err)
+			return false
+		}
+		supported := hello.SetName != "" || hello.Msg == "isdbgrid"
+		data.txnSupport = &supported
+	}
+	return *data.txnSupport
+}
+
+//findRaw Returns the matching documents exactly as they're stored (_id included) so they can be put back as they were.
+func findRaw(ctx context.Context, collection *mongo.Collection, filter bson.M) (docs []bson.Raw, err error) {
+	r, err := collection.Find(ctx, filter, &options.FindOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return docs, r.All(ctx, &docs)
+}
+
+//deleteRestorable Deletes the matching documents, adding an undo step that puts them back.
+func deleteRestorable(ctx context.Context, undo *compensation, collection *mongo.Collection, filter bson.M) error {
+	docs, err := findRaw(ctx, collection, filter)
+	if err != nil {
+		return err
+	}
+	if _, err := collection.DeleteMany(ctx, filter, &options.DeleteOptions{}); err != nil {
+		return err
+	}
+	undo.add(restoreDocs(collection, docs...))
+	return nil
+}
+
+//restoreDocs Returns an undo step that reinserts docs into collection.
+func restoreDocs(collection *mongo.Collection, docs ...bson.Raw) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if len(docs) == 0 {
+			return nil
+		}
+		items := make([]interface{}, len(docs))
+		for i := range docs {
+			items[i] = docs[i]
+		}
+		_, err := collection.InsertMany(ctx, items, &options.InsertManyOptions{})
+		return err
+	}
+}
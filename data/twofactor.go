@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//SaveTwoFactor Creates or replaces the user's enrollment.
+func (data *MongoStore) SaveTwoFactor(ctx context.Context, twoFactor TwoFactor) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("twofactor", nil).ReplaceOne(ctx, bson.M{"username": twoFactor.Username}, twoFactor, options.Replace().SetUpsert(true))
+		return err
+	}))
+}
+
+//GetTwoFactor Returns false if the user has never enrolled.
+func (data *MongoStore) GetTwoFactor(ctx context.Context, username string) (twoFactor TwoFactor, found bool, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("twofactor", nil).FindOne(ctx, bson.M{"username": username}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+		if result.Err() == mongo.ErrNoDocuments {
+			found = false
+			return nil
+		} else if result.Err() != nil {
+			return result.Err()
+		}
+		found = true
+		return result.Decode(&twoFactor)
+	})
+	return twoFactor, found, common.LogError("", err)
+}
+
+//AdvanceTwoFactorStep Records that a code for step was used. Returns false if one for step (or a later one) already
+//was.
+func (data *MongoStore) AdvanceTwoFactorStep(ctx context.Context, username string, step int64) (bool, error) {
+	var advanced bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("twofactor", nil).UpdateOne(ctx, bson.M{"username": username, "laststep": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"laststep": step}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		advanced = r.ModifiedCount == 1
+		return nil
+	})
+	return advanced, common.LogError("", err)
+}
+
+//UseRecoveryCode Removes the recovery code with the given hash. Returns false if the user doesn't have it.
+func (data *MongoStore) UseRecoveryCode(ctx context.Context, username, codeHash string) (bool, error) {
+	var used bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("twofactor", nil).UpdateOne(ctx, bson.M{"username": username, "recoverycodes": codeHash},
+			bson.M{"$pull": bson.M{"recoverycodes": codeHash}}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		used = r.ModifiedCount == 1
+		return nil
+	})
+	return used, common.LogError("", err)
+}
+
+//DeleteTwoFactor ...
+func (data *MongoStore) DeleteTwoFactor(ctx context.Context, username string) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("twofactor", nil).DeleteOne(ctx, bson.M{"username": username}, &options.DeleteOptions{})
+		return err
+	}))
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//NewUser ...
+func (data *MongoStore) NewUser(ctx context.Context, user User) error {
+	inserted, err := data.insertUniqueItem(ctx, "users", user, bson.M{"username": user.Username})
+	if (err == nil && !inserted) || isDuplicateKeyError(err) {
+		return storeError{ErrConflict, "this user already exists"}
+	}
+	return common.LogError("", err)
+}
+
+//GetUser ...
+func (data *MongoStore) GetUser(ctx context.Context, username string) (user User, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("users", nil).FindOne(ctx, bson.M{"username": username}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+		if result.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such user"}
+		} else if result.Err() != nil {
+			return result.Err()
+		}
+		return result.Decode(&user)
+	})
+	return user, err
+}
+
+//SetUserPassword Replaces the user's password hash, changedAt (unix ms) invalidates every session issued before it.
+func (data *MongoStore) SetUserPassword(ctx context.Context, username, passHash string, changedAt int64) error {
+	return data.updateUser(ctx, username, bson.M{"passhash": passHash, "passwordchangedat": changedAt})
+}
+
+//SetUserDisabled ...
+func (data *MongoStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return data.updateUser(ctx, username, bson.M{"disabled": disabled})
+}
+
+func (data *MongoStore) updateUser(ctx context.Context, username string, set bson.M) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("users", nil).UpdateOne(ctx, bson.M{"username": username}, bson.M{"$set": set}, &options.UpdateOptions{})
+		if err != nil {
+			return err
+		} else if r.MatchedCount == 0 {
+			return storeError{ErrNotFound, "no such user"}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,145 @@
+package data
+
+import (
+	"context"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//NewWebhook ...
+func (data *MongoStore) NewWebhook(ctx context.Context, hook WebhookSubscription) error {
+	inserted, err := data.insertUniqueItem(ctx, "webhooks", hook, bson.M{"owner": hook.Owner, "url": hook.URL})
+	if !inserted && err == nil {
+		return storeError{ErrConflict, "a webhook for this url already exists"}
+	}
+	return common.LogError("", err)
+}
+
+//GetWebhooks ...
+func (data *MongoStore) GetWebhooks(ctx context.Context, username string) (hooks []WebhookSubscription, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("webhooks", nil).Find(ctx, bson.M{"owner": username}, options.Find().SetProjection(bson.M{"_id": 0}))
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &hooks)
+	})
+	return hooks, common.LogError("", err)
+}
+
+//GetWebhooksForEvent Returns the subscriptions owned by username that want to hear about event.
+func (data *MongoStore) GetWebhooksForEvent(ctx context.Context, username, event string) (hooks []WebhookSubscription, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("webhooks", nil).Find(ctx, bson.M{"owner": username, "events": event}, options.Find().SetProjection(bson.M{"_id": 0}))
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &hooks)
+	})
+	return hooks, common.LogError("", err)
+}
+
+//GetWebhook ...
+func (data *MongoStore) GetWebhook(ctx context.Context, id string) (hook WebhookSubscription, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		result := data.db.Collection("webhooks", nil).FindOne(ctx, bson.M{"id": id}, options.FindOne().SetProjection(bson.M{"_id": 0}))
+		if result.Err() == mongo.ErrNoDocuments {
+			return storeError{ErrNotFound, "no such webhook"}
+		} else if result.Err() != nil {
+			return result.Err()
+		}
+		return result.Decode(&hook)
+	})
+	return hook, err
+}
+
+//DeleteWebhook Deletes the subscription and its delivery log.
+func (data *MongoStore) DeleteWebhook(ctx context.Context, id, username string) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		r, err := data.db.Collection("webhooks", nil).DeleteOne(ctx, bson.M{"id": id, "owner": username}, &options.DeleteOptions{})
+		if err != nil {
+			return err
+		}
+		if r.DeletedCount == 0 {
+			return storeError{ErrNotFound, "no such webhook"}
+		}
+		_, err = data.db.Collection("webhookdeliveries", nil).DeleteMany(ctx, bson.M{"subscriptionid": id}, &options.DeleteOptions{})
+		return err
+	}))
+}
+
+//NewWebhookDelivery ...
+func (data *MongoStore) NewWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	_, err := data.insertItem(ctx, "webhookdeliveries", delivery)
+	return common.LogError("", err)
+}
+
+//GetWebhookDeliveries Returns the most recent deliveries (newest first) for the specified subscription.
+func (data *MongoStore) GetWebhookDeliveries(ctx context.Context, subscriptionID, username string, limit int64) (deliveries []WebhookDelivery, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"createdat": -1}).SetLimit(limit)
+		r, err := data.db.Collection("webhookdeliveries", nil).Find(ctx, bson.M{"subscriptionid": subscriptionID, "owner": username}, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &deliveries)
+	})
+	return deliveries, common.LogError("", err)
+}
+
+//GetDueWebhookDeliveries Returns pending deliveries whose next attempt is at or before the provided time (unix ms).
+func (data *MongoStore) GetDueWebhookDeliveries(ctx context.Context, before int64) (deliveries []WebhookDelivery, err error) {
+	err = data.retryableQuery(ctx, func(ctx context.Context) error {
+		opts := options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"nextattempt": 1})
+		r, err := data.db.Collection("webhookdeliveries", nil).Find(ctx, bson.M{"status": DeliveryPending, "nextattempt": bson.M{"$lte": before}}, opts)
+		if err != nil {
+			return err
+		}
+		return r.All(ctx, &deliveries)
+	})
+	return deliveries, common.LogError("", err)
+}
+
+//ClaimWebhookDelivery Moves a pending delivery into the sending state. Returns false if it was already claimed.
+func (data *MongoStore) ClaimWebhookDelivery(ctx context.Context, id string) (bool, error) {
+	var claimed bool
+	err := data.retryableQuery(ctx, func(ctx context.Context) error {
+		r := data.db.Collection("webhookdeliveries", nil).FindOneAndUpdate(ctx, bson.M{"id": id, "status": DeliveryPending},
+			bson.M{"$set": bson.M{"status": DeliverySending, "claimedat": common.UnixTimestampInMS()}}, &options.FindOneAndUpdateOptions{})
+		if r.Err() == mongo.ErrNoDocuments {
+			claimed = false
+			return nil
+		}
+		claimed = r.Err() == nil
+		return r.Err()
+	})
+	return claimed, common.LogError("", err)
+}
+
+//FinishWebhookDelivery Records the outcome of a delivery attempt.
+func (data *MongoStore) FinishWebhookDelivery(ctx context.Context, delivery WebhookDelivery) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("webhookdeliveries", nil).UpdateOne(ctx, bson.M{"id": delivery.ID},
+			bson.M{"$set": bson.M{
+				"status":       delivery.Status,
+				"attempts":     delivery.Attempts,
+				"responsecode": delivery.ResponseCode,
+				"lasterror":    delivery.LastError,
+				"nextattempt":  delivery.NextAttempt,
+				"claimedat":    0,
+			}}, &options.UpdateOptions{})
+		return err
+	}))
+}
+
+//ReleaseStuckWebhookDeliveries Returns deliveries claimed before claimedBefore that never finished to the pending state.
+func (data *MongoStore) ReleaseStuckWebhookDeliveries(ctx context.Context, claimedBefore int64) error {
+	return common.LogError("", data.retryableQuery(ctx, func(ctx context.Context) error {
+		_, err := data.db.Collection("webhookdeliveries", nil).UpdateMany(ctx, bson.M{"status": DeliverySending, "claimedat": bson.M{"$lt": claimedBefore}},
+			bson.M{"$set": bson.M{"status": DeliveryPending, "claimedat": 0}}, &options.UpdateOptions{})
+		return err
+	}))
+}
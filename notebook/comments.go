@@ -0,0 +1,176 @@
+package notebook
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/minio/sio"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/crypto"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//GetComments Returns every comment on the page, decrypted.
+func (notesAPI *ServiceAPI) GetComments(ctx context.Context, pageID, notebookID string) ([]data.PageComment, error) {
+	comments, err := notesAPI.data.GetComments(ctx, pageID)
+	if err != nil || len(comments) == 0 {
+		return []data.PageComment{}, err
+	}
+	key, err := notesAPI.commentKey(ctx, pageID, notebookID, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		if comments[i].Body, err = decryptComment(key, comments[i].Body); err != nil {
+			return nil, common.LogError(comments[i].ID, err)
+		}
+	}
+	return comments, nil
+}
+
+//AddComment ...
+func (notesAPI *ServiceAPI) AddComment(ctx context.Context, request data.NewCommentRequest, pageID, notebookID, username string) (data.PageComment, error) {
+	if strings.TrimSpace(request.Body) == "" {
+		return data.PageComment{}, errors.New("comments need a body")
+	}
+	if request.ParentID != "" {
+		parent, err := notesAPI.data.GetComment(ctx, request.ParentID, pageID)
+		if err != nil {
+			return data.PageComment{}, err
+		}
+		if parent.ParentID != "" {
+			return data.PageComment{}, errors.New("replies go on the comment that started the thread")
+		}
+		request.Anchor = nil
+	}
+	if request.Anchor != nil && (request.Anchor.Start < 0 || request.Anchor.End < request.Anchor.Start) {
+		return data.PageComment{}, errors.New("invalid comment anchor")
+	}
+
+	key, err := notesAPI.commentKey(ctx, pageID, notebookID, true)
+	if err != nil {
+		return data.PageComment{}, err
+	}
+	comment := data.PageComment{
+		ID:         uuid.New().String(),
+		PageID:     pageID,
+		NotebookID: notebookID,
+		ParentID:   request.ParentID,
+		Author:     username,
+		Anchor:     request.Anchor,
+		CreatedAt:  common.UnixTimestampInMS(),
+	}
+	stored := comment
+	if stored.Body, err = encryptComment(key, request.Body); err != nil {
+		return data.PageComment{}, err
+	}
+	if err := notesAPI.data.NewComment(ctx, stored); err != nil {
+		return data.PageComment{}, err
+	}
+	comment.Body = request.Body
+	return comment, nil
+}
+
+//EditComment Only the author of a comment can change what it says.
+func (notesAPI *ServiceAPI) EditComment(ctx context.Context, id, body, pageID, notebookID, username string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("comments need a body")
+	}
+	comment, err := notesAPI.data.GetComment(ctx, id, pageID)
+	if err != nil {
+		return err
+	}
+	if comment.Author != username {
+		return errors.New("only the author can edit a comment")
+	}
+	key, err := notesAPI.commentKey(ctx, pageID, notebookID, false)
+	if err != nil {
+		return err
+	}
+	sealed, err := encryptComment(key, body)
+	if err != nil {
+		return err
+	}
+	return notesAPI.data.UpdateCommentBody(ctx, id, sealed)
+}
+
+//ResolveComment ...
+func (notesAPI *ServiceAPI) ResolveComment(ctx context.Context, id, pageID string, resolved bool, username string) error {
+	comment, err := notesAPI.data.GetComment(ctx, id, pageID)
+	if err != nil {
+		return err
+	}
+	if comment.ParentID != "" {
+		return errors.New("only the comment that started a thread can be resolved")
+	}
+	return notesAPI.data.ResolveCommentThread(ctx, id, resolved, username)
+}
+
+//DeleteComment Comments can be deleted by their author or by the owner of the page.
+func (notesAPI *ServiceAPI) DeleteComment(ctx context.Context, id, pageID, username string, pageOwner bool) error {
+	comment, err := notesAPI.data.GetComment(ctx, id, pageID)
+	if err != nil {
+		return err
+	}
+	if comment.Author != username && !pageOwner {
+		return errors.New("not allowed to delete this comment")
+	}
+	return notesAPI.data.DeleteComment(ctx, id)
+}
+
+//commentKey Every page has its own key for comments, stored in Vault next to the page's content key.
+func (notesAPI *ServiceAPI) commentKey(ctx context.Context, pageID, notebookID string, create bool) (key crypto.Key, e error) {
+	var sealedKey crypto.PageEncryptionKey
+	path := notebookID + "/" + pageID + "/comments"
+	keyContext := crypto.Context{"pageID": pageID, "usage": "comments"}
+
+	if storedKey, err := notesAPI.vaultClient.ReadKeyFromKV(ctx, path); err == nil {
+		if err := json.Unmarshal([]byte(storedKey), &sealedKey); err != nil {
+			return key, common.LogError("", err)
+		}
+		masterKey, err := notesAPI.vaultClient.UnsealKey(ctx, sealedKey.SealedMasterKey, keyContext)
+		if err != nil {
+			return key, err
+		}
+		return key, key.Unseal(masterKey[:], sealedKey.EntryKey)
+	} else if !create || err != crypto.ErrKeyNotFound {
+		return key, err
+	}
+
+	masterKey, sealedMaster, err := notesAPI.vaultClient.GenerateKey(ctx, keyContext)
+	if err != nil {
+		return key, common.LogError("", err)
+	}
+	key = crypto.GenerateKey(masterKey[:], "comments/"+notebookID+"/"+pageID)
+	entryKey, err := key.Seal(masterKey[:], path)
+	if err != nil {
+		return key, err
+	}
+	keyJSON, _ := json.Marshal(crypto.PageEncryptionKey{EntryKey: entryKey, SealedMasterKey: sealedMaster})
+	return key, notesAPI.vaultClient.WriteKeyToKVStorage(ctx, string(keyJSON), path)
+}
+
+func encryptComment(key crypto.Key, body string) (string, error) {
+	var sealed bytes.Buffer
+	if _, err := sio.Encrypt(&sealed, strings.NewReader(body), sio.Config{Key: key[:], MinVersion: sio.Version20}); err != nil {
+		return "", common.LogError("", err)
+	}
+	return base64.StdEncoding.EncodeToString(sealed.Bytes()), nil
+}
+
+func decryptComment(key crypto.Key, sealedBody string) (string, error) {
+	var body bytes.Buffer
+	sealed, err := base64.StdEncoding.DecodeString(sealedBody)
+	if err != nil {
+		return "", err
+	}
+	if _, err := sio.Decrypt(&body, bytes.NewReader(sealed), sio.Config{Key: key[:], MinVersion: sio.Version20}); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
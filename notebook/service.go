@@ -1,6 +1,7 @@
 package notebook
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -12,68 +13,97 @@ import (
 	"go.alargerobot.dev/notebook/data"
 )
 
+//Names of the events passed to an EventEmitter.
+const (
+	EventPageCreated     = "page.created"
+	EventPageEdited      = "page.edited"
+	EventPageContent     = "page.contentchanged"
+	EventPageDeleted     = "page.deleted"
+	EventPageShared      = "page.shared"
+	EventPageUnshared    = "page.unshared"
+	EventNotebookCreated = "notebook.created"
+	EventNotebookDeleted = "notebook.deleted"
+)
+
+//EventEmitter Gets told about changes made through the ServiceAPI, on behalf of the user that owns the changed thing.
+type EventEmitter interface {
+	Emit(username, event string, payload interface{})
+}
+
+//Emitters Passes every event on to each of the EventEmitters in the list.
+type Emitters []EventEmitter
+
+//Emit ...
+func (emitters Emitters) Emit(username, event string, payload interface{}) {
+	for _, emitter := range emitters {
+		emitter.Emit(username, event, payload)
+	}
+}
+
 //ServiceAPI ...
 type ServiceAPI struct {
-	data        *data.DataStore
+	data        data.DataStore
 	vaultClient *crypto.VaultKMS
+	events      EventEmitter
 }
 
 //NewNBServiceAPI ...
-func NewNBServiceAPI(db *data.DataStore, vault *crypto.VaultKMS) *ServiceAPI {
+func NewNBServiceAPI(db data.DataStore, vault *crypto.VaultKMS, events EventEmitter) *ServiceAPI {
 	if _, err := os.Stat("notebooks"); os.IsNotExist(err) {
 		err := os.Mkdir("notebooks", 0700)
 		if err != nil {
 			panic(err)
 		}
 	}
-	return &ServiceAPI{data: db, vaultClient: vault}
+	return &ServiceAPI{data: db, vaultClient: vault, events: events}
 }
 
 //GetPages ...
-func (notesAPI *ServiceAPI) GetPages(notebookID, user string) ([]data.Page, error) {
-	return notesAPI.data.GetContentsOfNotebook(notebookID, user)
+func (notesAPI *ServiceAPI) GetPages(ctx context.Context, notebookID, user string, query data.PageQuery) (data.PageList, error) {
+	return notesAPI.data.GetNotebookPages(ctx, notebookID, user, query)
 }
 
 //GetPageMetadata ...
-func (notesAPI *ServiceAPI) GetPageMetadata(pageID, notebookID string) (data.Page, error) {
-	return notesAPI.data.GetPageByID(pageID, notebookID)
+func (notesAPI *ServiceAPI) GetPageMetadata(ctx context.Context, pageID, notebookID string) (data.Page, error) {
+	return notesAPI.data.GetPageByID(ctx, pageID, notebookID)
 }
 
 //GetNotebooks ...
-func (notesAPI *ServiceAPI) GetNotebooks(username string) ([]data.NotebookReference, error) {
-	return notesAPI.data.GetUserNotebookNames(username)
+func (notesAPI *ServiceAPI) GetNotebooks(ctx context.Context, username string) ([]data.NotebookReference, error) {
+	return notesAPI.data.GetUserNotebookNames(ctx, username)
 }
 
 //NewPage ...
-func (notesAPI *ServiceAPI) NewPage(page data.NewPageRequest) error {
-	if valid, e := notesAPI.data.IsValidTagID(page.Metadata.Tags, page.Metadata.Creator); e != nil {
+func (notesAPI *ServiceAPI) NewPage(ctx context.Context, page data.NewPageRequest) error {
+	if valid, e := notesAPI.data.IsValidTagID(ctx, page.Metadata.Tags, page.Metadata.Creator); e != nil {
 		return common.LogError("", e)
 	} else if valid == false {
 		return common.LogError("data.IsValidTagID", errors.New("One or more of the specified tags is invalid"))
 	}
 
-	if err := notesAPI.writePageContentToDisk(page.Content, page.Metadata.ID, page.NotebookID); err != nil {
+	if err := notesAPI.writePageContentToDisk(ctx, page.Content, page.Metadata.ID, page.NotebookID); err != nil {
 		return common.LogError("writePageContentToDisk", err)
 	}
 
-	if err := notesAPI.data.NewPage(page.Metadata, page.NotebookID); err != nil {
-		notesAPI.cleanupAfterError(page.Metadata.ID, page.NotebookID)
+	if err := notesAPI.data.NewPage(ctx, page.Metadata, page.NotebookID); err != nil {
+		notesAPI.cleanupAfterError(ctx, page.Metadata.ID, page.NotebookID)
 		return err
 	}
 
+	notesAPI.events.Emit(page.Metadata.Creator, EventPageCreated, pageEvent(page.Metadata, page.NotebookID))
 	return nil
 }
 
 //ReadPage ...
-func (notesAPI *ServiceAPI) ReadPage(pageID, notebookID string) (string, error) {
+func (notesAPI *ServiceAPI) ReadPage(ctx context.Context, pageID, notebookID string) (string, error) {
 	var entryKey crypto.Key
 	var entryCryptoKey crypto.PageEncryptionKey
 	if file, err := os.OpenFile("notebooks/"+notebookID+"/"+pageID, os.O_RDONLY, 0700); os.IsNotExist(err) {
 		return "", err
 	} else {
-		if contentKey, err := notesAPI.vaultClient.ReadKeyFromKV(notebookID + "/" + pageID); err == nil {
+		if contentKey, err := notesAPI.vaultClient.ReadKeyFromKV(ctx, notebookID+"/"+pageID); err == nil {
 			common.LogError("", json.Unmarshal([]byte(contentKey), &entryCryptoKey))
-			if masterKey, err := notesAPI.vaultClient.UnsealKey(entryCryptoKey.SealedMasterKey, crypto.Context{"pageiD": pageID}); err == nil {
+			if masterKey, err := notesAPI.vaultClient.UnsealKey(ctx, entryCryptoKey.SealedMasterKey, crypto.Context{"pageiD": pageID}); err == nil {
 				entryKey.Unseal(masterKey[:], entryCryptoKey.EntryKey)
 				decryptor, err := sio.DecryptReader(file, sio.Config{Key: entryKey[:], MinVersion: sio.Version20})
 				fileContent, err := ioutil.ReadAll(decryptor)
@@ -91,60 +121,91 @@ func (notesAPI *ServiceAPI) ReadPage(pageID, notebookID string) (string, error)
 }
 
 //DeletePage ...
-func (notesAPI *ServiceAPI) DeletePage(pageID, notebookID string) error {
-	if err := notesAPI.data.DeletePage(pageID, notebookID); err == nil {
+func (notesAPI *ServiceAPI) DeletePage(ctx context.Context, pageID, notebookID, username string) error {
+	if err := notesAPI.data.DeletePage(ctx, pageID, notebookID); err == nil {
+		//The store deleted the page's reminders and comments with it, what's left is the content and the keys.
+		notesAPI.events.Emit(username, EventPageDeleted, map[string]string{"id": pageID, "notebookID": notebookID})
 		wd, _ := os.Getwd()
 		common.LogError("", os.Remove(wd+"/notebooks/"+notebookID+"/"+pageID))
-		return notesAPI.vaultClient.DeleteKeyFromKV(notebookID + "/" + pageID)
+		if err := notesAPI.vaultClient.DeleteKeyFromKV(ctx, notebookID+"/"+pageID+"/comments"); err != nil {
+			return common.LogError("", err)
+		}
+		return notesAPI.vaultClient.DeleteKeyFromKV(ctx, notebookID+"/"+pageID)
 	} else {
 		return common.LogError("", err)
 	}
 }
 
+//EditPage Updates a page's metadata, and its content if any was provided.
+func (notesAPI *ServiceAPI) EditPage(ctx context.Context, pageMD data.NewPageRequest, content string) error {
+	if err := notesAPI.EditPageMD(ctx, pageMD); err != nil {
+		return err
+	}
+	if content != "" {
+		if err := notesAPI.EditPageContent(ctx, content, pageMD.Metadata.ID, pageMD.NotebookID); err != nil {
+			return err
+		}
+	}
+	event := pageEvent(pageMD.Metadata, pageMD.NotebookID)
+	event["contentChanged"] = content != ""
+	notesAPI.events.Emit(pageMD.Metadata.Creator, EventPageEdited, event)
+	if content != "" {
+		notesAPI.events.Emit(pageMD.Metadata.Creator, EventPageContent, map[string]string{"id": pageMD.Metadata.ID, "notebookID": pageMD.NotebookID})
+	}
+	return nil
+}
+
 //EditPageMD ...
-func (notesAPI *ServiceAPI) EditPageMD(pageMD data.NewPageRequest) error {
+func (notesAPI *ServiceAPI) EditPageMD(ctx context.Context, pageMD data.NewPageRequest) error {
 	if pageMD.Metadata.ID == "" {
 		return errors.New("missing required id")
 	}
 
-	updated, err := notesAPI.data.UpdatePage(pageMD.NotebookID, pageMD.Metadata)
-	if !updated {
-		return errors.New("couldn't find a page to update")
+	//The store's error says why, like the page being gone (ErrNotFound) or its new title taken (ErrConflict).
+	if updated, err := notesAPI.data.UpdatePage(ctx, pageMD.NotebookID, pageMD.Metadata); err != nil {
+		return err
+	} else if !updated {
+		return data.ErrNotFound
 	}
-	return err
+	return nil
 }
 
 //EditPageContent ...
-func (notesAPI *ServiceAPI) EditPageContent(content, pageID, notebookID string) error {
-	pageMD, err := notesAPI.GetPageMetadata(pageID, notebookID)
+func (notesAPI *ServiceAPI) EditPageContent(ctx context.Context, content, pageID, notebookID string) error {
+	pageMD, err := notesAPI.GetPageMetadata(ctx, pageID, notebookID)
 	if err != nil {
 		return err
 	}
-	if _, err := notesAPI.data.UpdatePage(notebookID, pageMD); err != nil {
+	if _, err := notesAPI.data.UpdatePage(ctx, notebookID, pageMD); err != nil {
 		return err
 	}
 
-	return notesAPI.writePageContentToDisk(content, pageID, notebookID)
+	return notesAPI.writePageContentToDisk(ctx, content, pageID, notebookID)
 }
 
 //NewNotebook ...
-func (notesAPI *ServiceAPI) NewNotebook(notebook data.Notebook) (data.NotebookReference, error) {
-	if err := notesAPI.data.NewNotebook(notebook); err == nil {
-		return data.NotebookReference{ID: notebook.ID, Name: notebook.Name}, os.Mkdir("notebooks/"+notebook.ID, 0700)
+func (notesAPI *ServiceAPI) NewNotebook(ctx context.Context, notebook data.Notebook) (data.NotebookReference, error) {
+	if err := notesAPI.data.NewNotebook(ctx, notebook); err == nil {
+		ref := data.NotebookReference{ID: notebook.ID, Name: notebook.Name}
+		notesAPI.events.Emit(notebook.Owner, EventNotebookCreated, ref)
+		return ref, os.Mkdir("notebooks/"+notebook.ID, 0700)
 	} else {
 		return data.NotebookReference{}, err
 	}
 }
 
 //DeleteNotebook ...
-func (notesAPI *ServiceAPI) DeleteNotebook(id string) error {
+func (notesAPI *ServiceAPI) DeleteNotebook(ctx context.Context, id, username string) error {
 	//TODO: Delete vault keys too.
-	if pages, err := notesAPI.data.DeleteNotebook(id); err == nil {
+	if pages, err := notesAPI.data.DeleteNotebook(ctx, id); err == nil {
+		notesAPI.events.Emit(username, EventNotebookDeleted, map[string]string{"id": id})
 		wd, _ := os.Getwd()
 
 		for _, pageRef := range pages {
-			err = notesAPI.vaultClient.DeleteKeyFromKV(id + "/" + pageRef.ID)
-			if err != nil {
+			if err := notesAPI.vaultClient.DeleteKeyFromKV(ctx, id+"/"+pageRef.ID+"/comments"); err != nil {
+				return common.LogError("", err)
+			}
+			if err := notesAPI.vaultClient.DeleteKeyFromKV(ctx, id+"/"+pageRef.ID); err != nil {
 				return common.LogError("", err)
 			}
 		}
@@ -154,7 +215,17 @@ func (notesAPI *ServiceAPI) DeleteNotebook(id string) error {
 	}
 }
 
-func (notesAPI *ServiceAPI) writePageContentToDisk(content, pageID, notebookID string) error {
+func pageEvent(page data.Page, notebookID string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         page.ID,
+		"title":      page.Title,
+		"tags":       page.Tags,
+		"notebookID": notebookID,
+		"lastEdited": page.LastEdited,
+	}
+}
+
+func (notesAPI *ServiceAPI) writePageContentToDisk(ctx context.Context, content, pageID, notebookID string) error {
 	wd, _ := os.Getwd()
 	path := wd + "/notebooks/" + notebookID + "/" + pageID
 
@@ -166,7 +237,7 @@ func (notesAPI *ServiceAPI) writePageContentToDisk(content, pageID, notebookID s
 		return err
 	}
 
-	if vKey, vSealed, err := notesAPI.vaultClient.GenerateKey(crypto.Context{"pageID": pageID}); err == nil {
+	if vKey, vSealed, err := notesAPI.vaultClient.GenerateKey(ctx, crypto.Context{"pageID": pageID}); err == nil {
 		cryptoKey := crypto.GenerateKey(vKey[:], "notes/"+notebookID+"/"+pageID)
 		sealed, _ := cryptoKey.Seal(vKey[:], notebookID+"/"+pageID)
 		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0700)
@@ -184,7 +255,7 @@ func (notesAPI *ServiceAPI) writePageContentToDisk(content, pageID, notebookID s
 			}
 			entryCryptoKey := crypto.PageEncryptionKey{EntryKey: sealed, SealedMasterKey: vSealed}
 			ecKey, _ := json.Marshal(entryCryptoKey)
-			if e := notesAPI.vaultClient.WriteKeyToKVStorage(string(ecKey), notebookID+"/"+pageID); e != nil {
+			if e := notesAPI.vaultClient.WriteKeyToKVStorage(ctx, string(ecKey), notebookID+"/"+pageID); e != nil {
 				file.Close()
 				os.Remove(path)
 				notesAPI.revertFileRename(path)
@@ -209,11 +280,11 @@ func (notesAPI *ServiceAPI) writePageContentToDisk(content, pageID, notebookID s
 	return nil
 }
 
-func (notesAPI *ServiceAPI) cleanupAfterError(pageID, notebookID string) {
+func (notesAPI *ServiceAPI) cleanupAfterError(ctx context.Context, pageID, notebookID string) {
 	wd, _ := os.Getwd()
 	path := wd + "/notebooks/" + notebookID + "/" + pageID
 	os.RemoveAll(path)
-	notesAPI.vaultClient.DeleteKeyFromKV(notebookID + "/" + pageID)
+	notesAPI.vaultClient.DeleteKeyFromKV(ctx, notebookID+"/"+pageID)
 }
 
 func (notesAPI *ServiceAPI) renameFileForEdit(path string) error {
@@ -0,0 +1,110 @@
+package reminder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+//Notifier Delivers a reminder that's come due to wherever its target says it should go.
+type Notifier interface {
+	Notify(reminder data.Reminder) error
+	ValidateTarget(target string) error
+}
+
+//WebhookNotifier POSTs a JSON copy of the reminder to the target URL.
+type WebhookNotifier struct {
+	http *http.Client
+}
+
+//SMTPNotifier Sends the reminder as a plain text email to the target address.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+//NewWebhookNotifier Targets are user supplied, so the client won't connect to internal addresses (see
+//common.NewOutboundHTTPClient).
+func NewWebhookNotifier() *WebhookNotifier {
+	return &WebhookNotifier{http: common.NewOutboundHTTPClient(time.Second * 10)}
+}
+
+//NewSMTPNotifier Creates a notifier for the SMTP server at addr. Auth is only used if a username is provided, which
+//means a local stand-in server that doesn't support AUTH works too.
+func NewSMTPNotifier(addr, from, username, password string) *SMTPNotifier {
+	notifier := &SMTPNotifier{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		notifier.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier
+}
+
+//Notify ...
+func (w *WebhookNotifier) Notify(reminder data.Reminder) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":    "reminder.fired",
+		"reminder": reminder,
+		"sentAt":   common.UnixTimestampInMS(),
+	})
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("POST", reminder.Target, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//ValidateTarget The target has to be an http(s) url that doesn't resolve to an internal address.
+func (w *WebhookNotifier) ValidateTarget(target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	return common.ValidateOutboundURL(ctx, target)
+}
+
+//Notify ...
+func (s *SMTPNotifier) Notify(reminder data.Reminder) error {
+	if s.addr == "" {
+		return errors.New("no smtp server configured")
+	}
+	var msg bytes.Buffer
+	subject := "Reminder: " + reminder.PageTitle
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", reminder.Target)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", "").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	if reminder.Message != "" {
+		msg.WriteString(reminder.Message + "\r\n\r\n")
+	}
+	fmt.Fprintf(&msg, "https://notebook%s/nb/%s/page/%s\r\n", common.BaseURL, reminder.NotebookID, reminder.PageID)
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{reminder.Target}, msg.Bytes())
+}
+
+//ValidateTarget ...
+func (s *SMTPNotifier) ValidateTarget(target string) error {
+	if strings.ContainsAny(target, "\r\n") || !strings.Contains(target, "@") {
+		return errors.New("email target is not a valid address")
+	}
+	return nil
+}
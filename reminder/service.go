@@ -0,0 +1,183 @@
+package reminder
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const (
+	maxAttempts         = 5
+	defaultPollInterval = 30
+	stuckClaimTimeout   = 5 * time.Minute
+)
+
+//ServiceAPI ...
+type ServiceAPI struct {
+	data         data.DataStore
+	notifiers    map[string]Notifier
+	pollInterval time.Duration
+	stop         chan bool
+}
+
+//NewReminderService ...
+func NewReminderService(db data.DataStore) *ServiceAPI {
+	svc := &ServiceAPI{
+		data:         db,
+		notifiers:    make(map[string]Notifier),
+		pollInterval: time.Duration(defaultPollInterval) * time.Second,
+		stop:         make(chan bool),
+	}
+	if common.CurrentConfig.ReminderPollSeconds > 0 {
+		svc.pollInterval = time.Duration(common.CurrentConfig.ReminderPollSeconds) * time.Second
+	}
+
+	svc.notifiers["webhook"] = NewWebhookNotifier()
+	svc.notifiers["email"] = NewSMTPNotifier(common.CurrentConfig.SMTPServerAddr, common.CurrentConfig.SMTPFromAddr,
+		common.CurrentConfig.SMTPUsername, common.CurrentConfig.SMTPPassword)
+
+	return svc
+}
+
+//RegisterNotifier Adds (or replaces) the notifier used for reminders with the specified notifier name.
+func (svc *ServiceAPI) RegisterNotifier(name string, notifier Notifier) {
+	svc.notifiers[name] = notifier
+}
+
+//NewReminder ...
+func (svc *ServiceAPI) NewReminder(ctx context.Context, request data.NewReminderRequest, username string) (data.Reminder, error) {
+	notifier, exists := svc.notifiers[request.Notifier]
+	if !exists {
+		return data.Reminder{}, errors.New("unknown notifier")
+	}
+	if err := notifier.ValidateTarget(request.Target); err != nil {
+		return data.Reminder{}, err
+	}
+	if request.FireAt <= common.UnixTimestampInMS() {
+		return data.Reminder{}, errors.New("reminders need to be set for some time in the future")
+	}
+
+	page, err := svc.data.GetPageByID(ctx, request.PageID, request.NotebookID)
+	if err != nil {
+		return data.Reminder{}, err
+	}
+
+	reminder := data.Reminder{
+		ID:         uuid.New().String(),
+		Owner:      username,
+		PageID:     request.PageID,
+		TaskID:     request.TaskID,
+		NotebookID: request.NotebookID,
+		PageTitle:  page.Title,
+		Message:    request.Message,
+		Notifier:   request.Notifier,
+		Target:     request.Target,
+		FireAt:     request.FireAt,
+		Status:     data.ReminderPending,
+	}
+	return reminder, svc.data.NewReminder(ctx, reminder)
+}
+
+//GetReminders ...
+func (svc *ServiceAPI) GetReminders(ctx context.Context, username string) ([]data.Reminder, error) {
+	return svc.data.GetReminders(ctx, username)
+}
+
+//SnoozeReminder ...
+func (svc *ServiceAPI) SnoozeReminder(ctx context.Context, id, username string, request data.SnoozeReminderRequest) (data.Reminder, error) {
+	fireAt := request.Until
+	if request.Minutes > 0 {
+		fireAt = common.UnixTimestampInMS() + int64(request.Minutes)*60*1000
+	}
+	if fireAt <= common.UnixTimestampInMS() {
+		return data.Reminder{}, errors.New("can't snooze a reminder into the past")
+	}
+	if updated, err := svc.data.SnoozeReminder(ctx, id, username, fireAt); err != nil {
+		return data.Reminder{}, err
+	} else if !updated {
+		return data.Reminder{}, errors.New("no such reminder")
+	}
+	return svc.data.GetReminder(ctx, id, username)
+}
+
+//CancelReminder ...
+func (svc *ServiceAPI) CancelReminder(ctx context.Context, id, username string) error {
+	if cancelled, err := svc.data.CancelReminder(ctx, id, username); err != nil {
+		return err
+	} else if !cancelled {
+		return errors.New("no such reminder")
+	}
+	return nil
+}
+
+//StartScheduler Starts the goroutine that fires reminders as they come due. Reminders live in the DataStore, so
+//anything that came due while the service was down fires on the first tick after startup.
+func (svc *ServiceAPI) StartScheduler() {
+	go func() {
+		ctx := context.Background()
+		svc.releaseStuckReminders(ctx)
+		svc.fireDueReminders(ctx)
+		ticker := time.NewTicker(svc.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				svc.releaseStuckReminders(ctx)
+				svc.fireDueReminders(ctx)
+			case <-svc.stop:
+				return
+			}
+		}
+	}()
+}
+
+//StopScheduler ...
+func (svc *ServiceAPI) StopScheduler() {
+	close(svc.stop)
+}
+
+func (svc *ServiceAPI) releaseStuckReminders(ctx context.Context) {
+	cutoff := common.UnixTimestampInMS() - stuckClaimTimeout.Milliseconds()
+	if released, err := svc.data.ReleaseStuckReminders(ctx, cutoff); err == nil && released > 0 {
+		common.LogInfo("released", released, "requeued reminders that were never finished")
+	}
+}
+
+func (svc *ServiceAPI) fireDueReminders(ctx context.Context) {
+	due, err := svc.data.GetDueReminders(ctx, common.UnixTimestampInMS())
+	if err != nil {
+		return
+	}
+	for _, reminder := range due {
+		if claimed, err := svc.data.ClaimReminder(ctx, reminder.ID); err != nil || !claimed {
+			continue
+		}
+		svc.fire(ctx, reminder)
+	}
+}
+
+func (svc *ServiceAPI) fire(ctx context.Context, reminder data.Reminder) {
+	attempts := reminder.Attempts + 1
+	notifier, exists := svc.notifiers[reminder.Notifier]
+	if !exists {
+		common.LogError(reminder.ID, errors.New("reminder uses an unknown notifier"))
+		svc.data.FinishReminder(ctx, reminder.ID, data.ReminderFailed, attempts, reminder.FireAt)
+		return
+	}
+
+	if err := notifier.Notify(reminder); err != nil {
+		common.LogError(reminder.ID, err)
+		if attempts >= maxAttempts {
+			svc.data.FinishReminder(ctx, reminder.ID, data.ReminderFailed, attempts, reminder.FireAt)
+		} else {
+			retryAt := common.UnixTimestampInMS() + int64(attempts*attempts)*time.Minute.Milliseconds()
+			svc.data.FinishReminder(ctx, reminder.ID, data.ReminderPending, attempts, retryAt)
+		}
+		return
+	}
+	svc.data.FinishReminder(ctx, reminder.ID, data.ReminderFired, attempts, reminder.FireAt)
+}
@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/data"
+)
+
+const (
+	eventChannel     = "notebook:events"
+	subscriberBuffer = 32
+)
+
+//Event What gets pushed to a client's event stream.
+type Event struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"`
+	OccurredAt int64           `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type envelope struct {
+	Username string `json:"user"`
+	Event    Event  `json:"event"`
+}
+
+//Hub Fans events out to every stream a user has open. Events are published through the cache's pub/sub so streams
+//held open by other instances hear about them too (with the Redis cache). If publishing isn't possible events are only
+//delivered to local streams.
+type Hub struct {
+	cache       data.CacheService
+	lock        sync.RWMutex
+	subscribers map[string]map[chan Event]bool
+	subscribed  int32
+	stop        chan bool
+}
+
+//NewHub ...
+func NewHub(cache data.CacheService) *Hub {
+	return &Hub{
+		cache:       cache,
+		subscribers: make(map[string]map[chan Event]bool),
+		stop:        make(chan bool),
+	}
+}
+
+//Start Starts listening for events published by any instance.
+func (h *Hub) Start() {
+	go h.cache.Subscribe(eventChannel, h.receive, func(active bool) {
+		if active {
+			atomic.StoreInt32(&h.subscribed, 1)
+		} else {
+			atomic.StoreInt32(&h.subscribed, 0)
+		}
+	}, h.stop)
+}
+
+//Stop ...
+func (h *Hub) Stop() {
+	close(h.stop)
+}
+
+//Emit ...
+func (h *Hub) Emit(username, event string, payload interface{}) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		common.LogError(event, err)
+		return
+	}
+	env := envelope{
+		Username: username,
+		Event: Event{
+			ID:         uuid.New().String(),
+			Event:      event,
+			OccurredAt: common.UnixTimestampInMS(),
+			Data:       payloadJSON,
+		},
+	}
+	if atomic.LoadInt32(&h.subscribed) == 1 {
+		message, _ := json.Marshal(env)
+		if err := h.cache.Publish(eventChannel, string(message)); err == nil {
+			return
+		}
+	}
+	h.deliver(env)
+}
+
+//Subscribe Opens a new stream for username. The returned func must be called once the stream is no longer needed.
+func (h *Hub) Subscribe(username string) (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+	h.lock.Lock()
+	if h.subscribers[username] == nil {
+		h.subscribers[username] = make(map[chan Event]bool)
+	}
+	h.subscribers[username][events] = true
+	h.lock.Unlock()
+
+	return events, func() {
+		h.lock.Lock()
+		delete(h.subscribers[username], events)
+		if len(h.subscribers[username]) == 0 {
+			delete(h.subscribers, username)
+		}
+		h.lock.Unlock()
+	}
+}
+
+func (h *Hub) receive(message string) {
+	var env envelope
+	if err := json.Unmarshal([]byte(message), &env); err != nil {
+		common.LogError("", err)
+		return
+	}
+	h.deliver(env)
+}
+
+func (h *Hub) deliver(env envelope) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for subscriber := range h.subscribers[env.Username] {
+		select {
+		case subscriber <- env.Event:
+		default:
+			common.LogWarn("user", env.Username, "event stream is full, dropping event")
+		}
+	}
+}
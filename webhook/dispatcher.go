@@ -0,0 +1,248 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.alargerobot.dev/notebook/common"
+	"go.alargerobot.dev/notebook/crypto"
+	"go.alargerobot.dev/notebook/data"
+	"go.alargerobot.dev/notebook/notebook"
+)
+
+const (
+	maxAttempts       = 6
+	pollInterval      = 15 * time.Second
+	baseRetryDelay    = 30 * time.Second
+	stuckClaimTimeout = 5 * time.Minute
+
+	signatureHeader = "X-Notebook-Signature"
+	eventHeader     = "X-Notebook-Event"
+	deliveryHeader  = "X-Notebook-Delivery"
+)
+
+//KnownEvents ...
+var KnownEvents = []string{
+	notebook.EventPageCreated,
+	notebook.EventPageEdited,
+	notebook.EventPageContent,
+	notebook.EventPageDeleted,
+	notebook.EventPageShared,
+	notebook.EventPageUnshared,
+	notebook.EventNotebookCreated,
+	notebook.EventNotebookDeleted,
+}
+
+//Event The envelope every webhook payload is wrapped in.
+type Event struct {
+	ID         string      `json:"id"`
+	Event      string      `json:"event"`
+	OccurredAt int64       `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+//Dispatcher Persists events for each matching subscription and delivers them in the background, retrying failed
+//deliveries with exponential backoff.
+type Dispatcher struct {
+	data  data.DataStore
+	vault *crypto.VaultKMS
+	http  *http.Client
+	wake  chan bool
+	stop  chan bool
+}
+
+//NewDispatcher ...
+func NewDispatcher(db data.DataStore, vault *crypto.VaultKMS) *Dispatcher {
+	return &Dispatcher{
+		data:  db,
+		vault: vault,
+		http:  common.NewOutboundHTTPClient(time.Second * 10),
+		wake:  make(chan bool, 1),
+		stop:  make(chan bool),
+	}
+}
+
+//Subscribe Creates a subscription for username and returns it along with the secret used to sign its payloads.
+func (d *Dispatcher) Subscribe(ctx context.Context, request data.NewWebhookRequest, username string) (data.NewWebhookResponse, error) {
+	//Deliveries are made by a client that refuses internal addresses too, this just says so up front.
+	if err := common.ValidateOutboundURL(ctx, request.URL); err != nil {
+		return data.NewWebhookResponse{}, err
+	}
+	if len(request.Events) == 0 {
+		return data.NewWebhookResponse{}, errors.New("a webhook with 0 events is useless")
+	}
+	for _, event := range request.Events {
+		if !common.Contains(KnownEvents, event) {
+			return data.NewWebhookResponse{}, errors.New("unknown event: " + event)
+		}
+	}
+
+	var secret [32]byte
+	if _, err := io.ReadFull(rand.Reader, secret[:]); err != nil {
+		return data.NewWebhookResponse{}, common.LogError("", err)
+	}
+	plainSecret := hex.EncodeToString(secret[:])
+	sealed, err := d.vault.Encrypt(ctx, plainSecret)
+	if err != nil {
+		return data.NewWebhookResponse{}, common.LogError("", err)
+	}
+
+	hook := data.WebhookSubscription{
+		ID:           uuid.New().String(),
+		Owner:        username,
+		URL:          request.URL,
+		Events:       request.Events,
+		Description:  request.Description,
+		SealedSecret: sealed,
+		CreatedAt:    common.UnixTimestampInMS(),
+	}
+	if err := d.data.NewWebhook(ctx, hook); err != nil {
+		return data.NewWebhookResponse{}, err
+	}
+	return data.NewWebhookResponse{Webhook: hook, Secret: plainSecret}, nil
+}
+
+//Emit Queues event for delivery to every subscription owned by username that's interested in it.
+//Never blocks the caller, failures to queue are logged and otherwise ignored.
+func (d *Dispatcher) Emit(username, event string, payload interface{}) {
+	go d.queue(context.Background(), username, event, payload)
+}
+
+func (d *Dispatcher) queue(ctx context.Context, username, event string, payload interface{}) {
+	hooks, err := d.data.GetWebhooksForEvent(ctx, username, event)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+	for _, hook := range hooks {
+		body, err := json.Marshal(Event{
+			ID:         uuid.New().String(),
+			Event:      event,
+			OccurredAt: common.UnixTimestampInMS(),
+			Data:       payload,
+		})
+		if err != nil {
+			common.LogError(event, err)
+			return
+		}
+		d.data.NewWebhookDelivery(ctx, data.WebhookDelivery{
+			ID:             uuid.New().String(),
+			SubscriptionID: hook.ID,
+			Owner:          username,
+			Event:          event,
+			Payload:        string(body),
+			Status:         data.DeliveryPending,
+			CreatedAt:      common.UnixTimestampInMS(),
+			NextAttempt:    common.UnixTimestampInMS(),
+		})
+	}
+	select {
+	case d.wake <- true:
+	default:
+	}
+}
+
+//Start Starts the background delivery worker.
+func (d *Dispatcher) Start() {
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		d.deliverDue(ctx)
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			case <-d.stop:
+				return
+			}
+			d.deliverDue(ctx)
+		}
+	}()
+}
+
+//Stop ...
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	d.data.ReleaseStuckWebhookDeliveries(ctx, common.UnixTimestampInMS()-stuckClaimTimeout.Milliseconds())
+	due, err := d.data.GetDueWebhookDeliveries(ctx, common.UnixTimestampInMS())
+	if err != nil {
+		return
+	}
+	for _, delivery := range due {
+		if claimed, err := d.data.ClaimWebhookDelivery(ctx, delivery.ID); err != nil || !claimed {
+			continue
+		}
+		d.attempt(ctx, delivery)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery data.WebhookDelivery) {
+	delivery.Attempts++
+	hook, err := d.data.GetWebhook(ctx, delivery.SubscriptionID)
+	if err != nil {
+		delivery.Status = data.DeliveryFailed
+		delivery.LastError = err.Error()
+		d.data.FinishWebhookDelivery(ctx, delivery)
+		return
+	}
+
+	delivery.ResponseCode, err = d.send(ctx, hook, delivery)
+	if err == nil {
+		delivery.Status = data.DeliveryDelivered
+		delivery.LastError = ""
+	} else if delivery.Attempts >= maxAttempts {
+		delivery.Status = data.DeliveryFailed
+		delivery.LastError = err.Error()
+	} else {
+		delivery.Status = data.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = common.UnixTimestampInMS() + (baseRetryDelay * (1 << uint(delivery.Attempts-1))).Milliseconds()
+	}
+	d.data.FinishWebhookDelivery(ctx, delivery)
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook data.WebhookSubscription, delivery data.WebhookDelivery) (int, error) {
+	secret, err := d.vault.Decrypt(ctx, hook.SealedSecret)
+	if err != nil {
+		return 0, err
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, delivery.Event)
+	req.Header.Set(deliveryHeader, delivery.ID)
+	req.Header.Set(signatureHeader, "sha256="+Sign(secret, []byte(delivery.Payload)))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+//Sign Returns the hex encoded HMAC-SHA256 of payload. Receivers compare this against the X-Notebook-Signature header.
+func Sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}